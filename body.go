@@ -16,6 +16,10 @@ type body struct {
 	// directed to.  This io.ReadSeeker will have .Seek() operations called on it
 	// and it must be exclusively used by the body type.
 	backingReader io.ReadSeeker
+	// The backing reader at is used instead of the backing reader for bodies
+	// created with newBodyAt.  Since reads from it don't change any shared
+	// position, it can be used by more than one body at the same time
+	backingReaderAt io.ReaderAt
 	// The limit reader is an io.LimitReader which ensures we only read up to
 	// `size` bytes when reading from the backingReader
 	limitReader io.Reader
@@ -31,7 +35,26 @@ func newBody(input io.ReadSeeker, offset, size int64) (*body, error) {
 		return nil, newError(nil, "cannot specify a size of 0 for body")
 	}
 
-	b := body{input, nil, offset, size}
+	b := body{backingReader: input, offset: offset, size: size}
+
+	err := b.Reset()
+	if err != nil {
+		return nil, newErrorf(err, "initializing for %s", findName(input))
+	}
+
+	return &b, nil
+}
+
+// Create a body which reads from an io.ReaderAt instead of an io.ReadSeeker.
+// Each read is made at the correct offset of the input instead of seeking it,
+// so many bodies can read from the same input concurrently, which is needed to
+// upload the parts of a multipart upload at the same time
+func newBodyAt(input io.ReaderAt, offset, size int64) (*body, error) {
+	if size == 0 {
+		return nil, newError(nil, "cannot specify a size of 0 for body")
+	}
+
+	b := body{backingReaderAt: input, offset: offset, size: size}
 
 	err := b.Reset()
 	if err != nil {
@@ -45,6 +68,11 @@ func newBody(input io.ReadSeeker, offset, size int64) (*body, error) {
 // and resetting the internal io.LimitReader that's used to read only a certain
 // number of bytes.  This is to allow retrying of a file
 func (b *body) Reset() error {
+	if b.backingReaderAt != nil {
+		b.limitReader = io.NewSectionReader(b.backingReaderAt, b.offset, b.size)
+		return nil
+	}
+
 	if _, err := b.backingReader.Seek(b.offset, io.SeekStart); err != nil {
 		return newErrorf(err, "seeking file %s to positiong %d", findName(b.backingReader), b.offset)
 	}
@@ -53,7 +81,10 @@ func (b *body) Reset() error {
 	return nil
 }
 
-// Satisfy the io.Reader interface by reading from the associated file
+// Satisfy the io.Reader interface by reading from the associated file.  Like
+// any io.Reader, this may read fewer bytes than len(p) even before the end of
+// the body, so callers which need a whole part must use io.ReadFull or keep
+// reading until io.EOF
 func (b body) Read(p []byte) (int, error) {
 	return b.limitReader.Read(p)
 }
@@ -61,6 +92,13 @@ func (b body) Read(p []byte) (int, error) {
 // Close a body and return relevant values back to their nil value
 // TODO: I'm pretty sure that I don't need this function
 func (b *body) Close() error {
+	// The backing reader at of a body is shared, so we don't close it
+	if b.backingReaderAt != nil {
+		b.backingReaderAt = nil
+		b.limitReader = nil
+		return nil
+	}
+
 	// If the backing reader happens to also support the Closer interface, we'll
 	// propogate calls to it
 	if closer, ok := b.backingReader.(io.Closer); ok {
@@ -77,5 +115,8 @@ func (b *body) Close() error {
 
 // Return a string representation of a Body for display
 func (b body) String() string {
+	if b.backingReaderAt != nil {
+		return fmt.Sprintf("backing reader at: %#v offset: %d size: %d\n", b.backingReaderAt, b.offset, b.size)
+	}
 	return fmt.Sprintf("backing reader: %#v offset: %d size: %d\n", b.backingReader, b.offset, b.size)
 }
@@ -191,3 +191,99 @@ func TestBodyReading(t *testing.T) {
 		}
 	})
 }
+
+func TestBodyReadingAt(t *testing.T) {
+
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	t.Run("should return error if size is zero", func(t *testing.T) {
+		file, _, teardown := setup(t)
+		defer teardown()
+		_, err := newBodyAt(file, 128, 0)
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("should read middle 1024 bytes of a 2048 byte file", func(t *testing.T) {
+		file, b, teardown := setup(t)
+		defer teardown()
+		body, err := newBodyAt(file, 512, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bodyData, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(bodyData, b[512:1024+512]) {
+			t.Fatalf("Body data did not match")
+		}
+	})
+
+	t.Run("should read again after reset", func(t *testing.T) {
+		file, b, teardown := setup(t)
+		defer teardown()
+		body, err := newBodyAt(file, 0, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = body.Reset()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bodyData, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(bodyData, b[:1024]) {
+			t.Fatalf("Body data did not match after reset")
+		}
+	})
+
+	t.Run("should read interleaved bodies of the same file", func(t *testing.T) {
+		file, b, teardown := setup(t)
+		defer teardown()
+		first, err := newBodyAt(file, 0, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := newBodyAt(file, 1024, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var firstData, secondData bytes.Buffer
+		buf := make([]byte, 100)
+		for firstData.Len() < 1024 || secondData.Len() < 1024 {
+			for _, r := range []struct {
+				body *body
+				out  *bytes.Buffer
+			}{{first, &firstData}, {second, &secondData}} {
+				n, err := r.body.Read(buf)
+				if err != nil && err != io.EOF {
+					t.Fatal(err)
+				}
+				r.out.Write(buf[:n])
+			}
+		}
+
+		if !bytes.Equal(firstData.Bytes(), b[:1024]) {
+			t.Fatalf("First body data did not match")
+		}
+
+		if !bytes.Equal(secondData.Bytes(), b[1024:]) {
+			t.Fatalf("Second body data did not match")
+		}
+	})
+}
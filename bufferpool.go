@@ -0,0 +1,54 @@
+package artifact
+
+import "sync"
+
+// Every request and every pass over an input needs a buffer of the chunk
+// size, so rather than allocating a new one each time, buffers are pooled.
+// There is a pool for each size, so a buffer is only ever reused for the
+// chunk size it was made for.  The pools hold pointers to slices so that
+// putting a buffer back doesn't itself allocate
+var (
+	bufferPoolsLock sync.Mutex
+	bufferPools     = map[int]*sync.Pool{}
+)
+
+// Get a buffer of size bytes from the pool for that size, allocating a new
+// one if the pool is empty.  The buffer should be given back with putBuffer
+// once it's no longer used
+func getBuffer(size int) *[]byte {
+	bufferPoolsLock.Lock()
+	pool, ok := bufferPools[size]
+	if !ok {
+		pool = &sync.Pool{
+			New: func() interface{} {
+				buf := make([]byte, size)
+				return &buf
+			},
+		}
+		bufferPools[size] = pool
+	}
+	bufferPoolsLock.Unlock()
+
+	return pool.Get().(*[]byte)
+}
+
+// Give a buffer back to the pool for its size.  Buffers for a size whose pool
+// has been dropped are left for the garbage collector
+func putBuffer(buf *[]byte) {
+	bufferPoolsLock.Lock()
+	pool, ok := bufferPools[len(*buf)]
+	bufferPoolsLock.Unlock()
+
+	if ok {
+		pool.Put(buf)
+	}
+}
+
+// Drop the pooled buffers of size bytes.  This is done when a Client's chunk
+// size changes so that buffers of the old size aren't kept around.  Should
+// another Client still use that size, a new pool is created on demand
+func dropBuffers(size int) {
+	bufferPoolsLock.Lock()
+	delete(bufferPools, size)
+	bufferPoolsLock.Unlock()
+}
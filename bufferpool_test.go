@@ -0,0 +1,91 @@
+package artifact
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBufferPool(t *testing.T) {
+	t.Run("returns buffers of the requested size", func(t *testing.T) {
+		for _, size := range []int{1024, 4096, 1024} {
+			bufp := getBuffer(size)
+			if len(*bufp) != size {
+				t.Errorf("expected a %d byte buffer, got %d bytes", size, len(*bufp))
+			}
+			putBuffer(bufp)
+		}
+	})
+
+	t.Run("dropped sizes are not pooled", func(t *testing.T) {
+		bufp := getBuffer(2048)
+		dropBuffers(2048)
+		putBuffer(bufp)
+
+		bufferPoolsLock.Lock()
+		_, ok := bufferPools[2048]
+		bufferPoolsLock.Unlock()
+		if ok {
+			t.Fatal("expected the dropped pool not to be recreated by putBuffer")
+		}
+
+		if bufp = getBuffer(2048); len(*bufp) != 2048 {
+			t.Fatalf("expected a 2048 byte buffer, got %d bytes", len(*bufp))
+		}
+		putBuffer(bufp)
+	})
+
+	t.Run("changing the chunk size drops the old buffers", func(t *testing.T) {
+		client := New(nil)
+		putBuffer(getBuffer(DefaultChunkSize))
+
+		if err := client.SetInternalSizes(64*1024, DefaultPartSize); err != nil {
+			t.Fatal(err)
+		}
+
+		bufferPoolsLock.Lock()
+		_, ok := bufferPools[DefaultChunkSize]
+		bufferPoolsLock.Unlock()
+		if ok {
+			t.Fatal("expected buffers of the old chunk size to be dropped")
+		}
+	})
+}
+
+// Compare allocating a chunk buffer for each use, as was done before buffers
+// were pooled, with taking one from the pool
+func BenchmarkChunkBuffers(b *testing.B) {
+	var sink []byte
+
+	b.Run("allocate", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sink = make([]byte, DefaultChunkSize)
+		}
+	})
+
+	b.Run("pool", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bufp := getBuffer(DefaultChunkSize)
+			sink = *bufp
+			putBuffer(bufp)
+		}
+	})
+
+	_ = sink
+}
+
+// Report the allocations of preparing a small single part upload, which are
+// dominated by the chunk buffer when it isn't pooled
+func BenchmarkSinglePartUploadAllocs(b *testing.B) {
+	input := bytes.NewReader([]byte("a small artifact"))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := singlePartUpload(input, ioutil.Discard, false, DefaultChunkSize, sha256.New, DefaultGzipHeader, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
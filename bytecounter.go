@@ -1,5 +1,7 @@
 package artifact
 
+import "io"
+
 type byteCountingWriter struct {
 	count int64
 }
@@ -9,3 +11,22 @@ func (c *byteCountingWriter) Write(p []byte) (n int, err error) {
 	c.count += int64(nBytes)
 	return nBytes, nil
 }
+
+// A countingWriter passes writes through to another io.Writer while counting
+// the bytes which were written to it
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// Name describes the underlying io.Writer so that error messages mention it
+// instead of the countingWriter
+func (c *countingWriter) Name() string {
+	return findName(c.w)
+}
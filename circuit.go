@@ -0,0 +1,120 @@
+package artifact
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitBreaker configures how a Client stops starting uploads and downloads
+// while the services it uses are failing.  The Client keeps the outcomes of
+// its latest requests to S3 and the Queue.  Once at least FailureRate of the
+// last Window requests have failed, the circuit opens and new uploads and
+// downloads return ErrCircuitOpen without making any requests until the
+// Cooldown has passed.  Requests which fail in a way that could succeed when
+// retried, like server errors and requests without a response, count as
+// failures.  Requests which get any other response count as successes, since
+// the service answered them
+type CircuitBreaker struct {
+	// Window is how many of the latest requests the failure rate is measured
+	// over.  The circuit can only open once this many requests were made
+	Window int
+	// FailureRate is the fraction of the requests in the Window which must
+	// fail for the circuit to open, greater than 0 and at most 1
+	FailureRate float64
+	// Cooldown is how long the circuit stays open.  Afterwards, the failure
+	// rate is measured again from scratch
+	Cooldown time.Duration
+}
+
+// The state of a CircuitBreaker.  Operations use copies of the Client which
+// share it, so it's locked while it's used
+type circuitBreaker struct {
+	CircuitBreaker
+	clock     clock
+	lock      sync.Mutex
+	outcomes  []bool
+	next      int
+	failures  int
+	openUntil time.Time
+}
+
+// SetCircuitBreaker sets when the Client stops starting uploads and downloads
+// because the requests it makes keep failing.  Passing nil, which is the
+// default, means it never stops.  Each call starts measuring the failure rate
+// from scratch
+func (c *Client) SetCircuitBreaker(cb *CircuitBreaker) error {
+	if cb == nil {
+		c.circuit = nil
+		return nil
+	}
+	if cb.Window < 1 {
+		return newErrorf(nil, "circuit breaker window %d is not minimum of 1", cb.Window)
+	}
+	if cb.FailureRate <= 0 || cb.FailureRate > 1 {
+		return newErrorf(nil, "circuit breaker failure rate %g is not greater than 0 and at most 1", cb.FailureRate)
+	}
+	if cb.Cooldown <= 0 {
+		return newErrorf(nil, "circuit breaker cooldown %s is not positive", cb.Cooldown)
+	}
+	c.circuit = &circuitBreaker{CircuitBreaker: *cb, clock: c.clock}
+	return nil
+}
+
+// GetCircuitBreaker returns the circuit breaker settings of the Client, or
+// nil when it has none
+func (c *Client) GetCircuitBreaker() *CircuitBreaker {
+	if c.circuit == nil {
+		return nil
+	}
+	cb := c.circuit.CircuitBreaker
+	return &cb
+}
+
+// Return ErrCircuitOpen while the circuit is open
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.clock.Now().Before(b.openUntil) {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// Record the outcome of a request, opening the circuit if too many of the
+// latest requests have failed.  Requests which were already in flight when the
+// circuit opened aren't counted towards the next measurement
+func (b *circuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.clock.Now().Before(b.openUntil) {
+		return
+	}
+
+	if len(b.outcomes) < b.Window {
+		b.outcomes = append(b.outcomes, failed)
+	} else {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+		b.outcomes[b.next] = failed
+	}
+	b.next = (b.next + 1) % b.Window
+	if failed {
+		b.failures++
+	}
+
+	if len(b.outcomes) == b.Window && float64(b.failures) >= b.FailureRate*float64(b.Window) {
+		logger.Printf("%d of the last %d requests failed, not starting uploads or downloads for %s", b.failures, b.Window, b.Cooldown)
+		b.openUntil = b.clock.Now().Add(b.Cooldown)
+		b.outcomes = nil
+		b.next = 0
+		b.failures = 0
+	}
+}
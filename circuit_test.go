@@ -0,0 +1,132 @@
+package artifact
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	client := New(nil)
+	if client.GetCircuitBreaker() != nil {
+		t.Fatal("expected no circuit breaker by default")
+	}
+
+	for _, cb := range []CircuitBreaker{
+		{Window: 0, FailureRate: 0.5, Cooldown: time.Second},
+		{Window: 4, FailureRate: 0, Cooldown: time.Second},
+		{Window: 4, FailureRate: 1.5, Cooldown: time.Second},
+		{Window: 4, FailureRate: 0.5, Cooldown: 0},
+	} {
+		if err := client.SetCircuitBreaker(&cb); err == nil {
+			t.Errorf("expected %#v to be rejected", cb)
+		}
+	}
+
+	k := newFakeClock()
+	client.clock = k
+
+	settings := CircuitBreaker{Window: 4, FailureRate: 0.5, Cooldown: time.Minute}
+	if err := client.SetCircuitBreaker(&settings); err != nil {
+		t.Fatal(err)
+	}
+	if cb := client.GetCircuitBreaker(); cb == nil || *cb != settings {
+		t.Fatalf("expected %#v, got %#v", settings, cb)
+	}
+
+	b := client.circuit
+
+	// Failures only open the circuit once the window is full
+	b.record(true)
+	b.record(true)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected the circuit to be closed, got %v", err)
+	}
+	b.record(false)
+	b.record(false)
+	if err := b.allow(); err != ErrCircuitOpen {
+		t.Fatalf("expected ErrCircuitOpen with 2 of 4 failures, got %v", err)
+	}
+
+	// Outcomes while the circuit is open aren't counted
+	b.record(true)
+	k.Sleep(settings.Cooldown - time.Second)
+	if err := b.allow(); err != ErrCircuitOpen {
+		t.Fatalf("expected the circuit to stay open until the cooldown has passed, got %v", err)
+	}
+	k.Sleep(time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected the circuit to be closed after the cooldown, got %v", err)
+	}
+
+	// The failure rate is measured from scratch after the cooldown, and the
+	// oldest outcomes leave the window as new ones are recorded
+	b.record(true)
+	for i := 0; i < 4; i++ {
+		b.record(false)
+	}
+	b.record(true)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected the circuit to be closed with 1 of 4 failures, got %v", err)
+	}
+	b.record(true)
+	if err := b.allow(); err != ErrCircuitOpen {
+		t.Fatalf("expected ErrCircuitOpen with 2 of 4 failures, got %v", err)
+	}
+
+	if err := client.SetCircuitBreaker(nil); err != nil || client.GetCircuitBreaker() != nil {
+		t.Fatalf("expected the circuit breaker to be removed, got %v", err)
+	}
+}
+
+func TestCircuitBreakerDownloads(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	requests := 0
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/task/"):
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/s3/outage")
+			w.WriteHeader(303)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+	if err := client.SetCircuitBreaker(&CircuitBreaker{Window: 4, FailureRate: 0.5, Cooldown: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each download gets the redirect, which succeeds, and then fails to
+	// download from S3
+	for i := 0; i < 2; i++ {
+		var output bytes.Buffer
+		if err := client.Download(fakeTaskID, "0", "public/outage", &output); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("download %d: expected the download to fail, got %v", i, err)
+		}
+	}
+
+	requests = 0
+	var output bytes.Buffer
+	if err := client.Download(fakeTaskID, "0", "public/outage", &output); err != ErrCircuitOpen {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if err := client.UploadBytes(fakeTaskID, "0", "public/outage", []byte("not uploaded"), false, false); err != ErrCircuitOpen {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if requests != 0 {
+		t.Fatalf("expected no requests while the circuit is open, got %d", requests)
+	}
+}
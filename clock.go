@@ -0,0 +1,19 @@
+package artifact
+
+import (
+	"time"
+)
+
+// A clock tells the time and makes timers to wait with.  Clients use the
+// system's clock, which tests replace to check expiry, backoff and cooldowns
+// without waiting for real time to pass.  Timers can be stopped, so a wait
+// which is interrupted leaves nothing behind
+type clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) *time.Timer
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                       { return time.Now() }
+func (systemClock) NewTimer(d time.Duration) *time.Timer { return time.NewTimer(d) }
@@ -0,0 +1,112 @@
+package artifact
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fakeClock tells a time which only moves on when it's slept on or a timer
+// is made, and records how long each wait was.  Its timers have already fired
+type fakeClock struct {
+	lock  sync.Mutex
+	now   time.Time
+	slept []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (k *fakeClock) Now() time.Time {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.now
+}
+
+func (k *fakeClock) Sleep(d time.Duration) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.slept = append(k.slept, d)
+	k.now = k.now.Add(d)
+}
+
+func (k *fakeClock) NewTimer(d time.Duration) *time.Timer {
+	k.Sleep(d)
+	return time.NewTimer(0)
+}
+
+func TestClock(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	t.Run("expiry", func(t *testing.T) {
+		k := newFakeClock()
+		client := New(nil)
+		client.clock = k
+
+		if expires := client.expires(); !expires.Equal(k.now.AddDate(0, 0, 1)) {
+			t.Fatalf("expected artifacts to expire a day from %s, got %s", k.now, expires)
+		}
+	})
+
+	t.Run("queue call backoff", func(t *testing.T) {
+		k := newFakeClock()
+		client := New(nil)
+		client.clock = k
+		client.MaxRetries = 3
+		client.RetryDelay = time.Second
+
+		calls := 0
+		err := client.retryQueueCall("failing call", func() error {
+			calls++
+			return fmt.Errorf("no response")
+		})
+		if err == nil {
+			t.Fatal("expected the call to fail")
+		}
+		if calls != 4 {
+			t.Fatalf("expected 4 calls, got %d", calls)
+		}
+		if expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}; !reflect.DeepEqual(k.slept, expected) {
+			t.Fatalf("expected to sleep for %v, slept for %v", expected, k.slept)
+		}
+	})
+	t.Run("interrupted wait", func(t *testing.T) {
+		k := &stoppedClock{}
+		ctx, cancel := context.WithCancel(context.Background())
+		client := New(nil)
+		client.clock = k
+		client.Context = ctx
+
+		op, done, err := client.startOperation("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer done()
+
+		cancel()
+		if err := op.sleep(time.Second); err != ErrCancelled {
+			t.Fatalf("expected ErrCancelled, got %v", err)
+		}
+		if k.timer == nil || k.timer.Stop() {
+			t.Fatal("expected the timer to be stopped once the wait was interrupted")
+		}
+	})
+}
+
+// A stoppedClock's timers never fire on their own
+type stoppedClock struct {
+	timer *time.Timer
+}
+
+func (k *stoppedClock) Now() time.Time {
+	return time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+}
+
+func (k *stoppedClock) NewTimer(d time.Duration) *time.Timer {
+	k.timer = time.NewTimer(time.Hour)
+	return k.timer
+}
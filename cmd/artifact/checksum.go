@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	artifact "github.com/taskcluster/taskcluster-lib-artifact-go"
+)
+
+// Prepare a file the way the upload command would and describe the hashes,
+// sizes and parts its artifact would be created with.  The prepared output is
+// written to a temporary file in tmpDir, which is removed afterwards
+func checksumFile(filename, tmpDir string, gzip, multipart bool, chunkSize, partSize int) (checksumSummary, error) {
+	// Prepare checks the sizes too, but it's given the part size as a number
+	// of chunks, which can only be worked out from valid sizes
+	if chunkSize <= 0 {
+		return checksumSummary{}, fmt.Errorf("chunk size %d is not positive", chunkSize)
+	}
+	if partSize%chunkSize != 0 {
+		return checksumSummary{}, fmt.Errorf("part size %d is not divisible by chunk size %d", partSize, chunkSize)
+	}
+
+	input, err := os.Open(filename)
+	if err != nil {
+		return checksumSummary{}, err
+	}
+	defer input.Close()
+
+	output, err := ioutil.TempFile(tmpDir, "tc-artifact")
+	if err != nil {
+		return checksumSummary{}, err
+	}
+	defer func() {
+		output.Close()
+		os.Remove(output.Name())
+	}()
+
+	prepared, err := artifact.Prepare(input, output, gzip, multipart, chunkSize, partSize/chunkSize)
+	if err != nil {
+		return checksumSummary{}, err
+	}
+
+	summary := checksumSummary{
+		Filename:        filename,
+		ContentEncoding: prepared.ContentEncoding,
+		ContentLength:   prepared.ContentLength,
+		ContentSha256:   hex.EncodeToString(prepared.ContentSha256),
+		TransferLength:  prepared.TransferLength,
+		TransferSha256:  hex.EncodeToString(prepared.TransferSha256),
+		Parts:           []checksumPart{},
+	}
+
+	// Parts are consecutive ranges of what's transfered
+	var offset int64
+	for _, p := range prepared.Parts {
+		summary.Parts = append(summary.Parts, checksumPart{
+			Sha256: hex.EncodeToString(p.Sha256),
+			Offset: offset,
+			Size:   p.Size,
+		})
+		offset += p.Size
+	}
+
+	return summary, nil
+}
+
+// Write a checksum summary for people to read
+func printChecksum(w io.Writer, summary checksumSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "content encoding:\t%s\n", summary.ContentEncoding)
+	fmt.Fprintf(tw, "content length:\t%d\n", summary.ContentLength)
+	fmt.Fprintf(tw, "content sha256:\t%s\n", summary.ContentSha256)
+	fmt.Fprintf(tw, "transfer length:\t%d\n", summary.TransferLength)
+	fmt.Fprintf(tw, "transfer sha256:\t%s\n", summary.TransferSha256)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(summary.Parts) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PART\tOFFSET\tSIZE\tSHA256")
+	for i, p := range summary.Parts {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%s\n", i+1, p.Offset, p.Size, p.Sha256)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestChecksumFile(t *testing.T) {
+	err := os.MkdirAll("testdata", 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("testdata", "checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	partSize := 5 * 1024 * 1024
+	content := bytes.Repeat([]byte("checksum"), (2*partSize+partSize/2)/8)
+	if _, err := f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	contentHash := sha256.Sum256(content)
+
+	t.Run("single part", func(t *testing.T) {
+		summary, err := checksumFile(f.Name(), "testdata", false, false, 128*1024, partSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.ContentSha256 != hex.EncodeToString(contentHash[:]) || summary.TransferSha256 != summary.ContentSha256 {
+			t.Errorf("unexpected hashes %s and %s", summary.ContentSha256, summary.TransferSha256)
+		}
+		if summary.ContentLength != int64(len(content)) || summary.ContentEncoding != "identity" || len(summary.Parts) != 0 {
+			t.Errorf("unexpected summary %#v", summary)
+		}
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		summary, err := checksumFile(f.Name(), "testdata", false, true, 128*1024, partSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(summary.Parts) != 3 {
+			t.Fatalf("expected 3 parts, got %d", len(summary.Parts))
+		}
+		for i, p := range summary.Parts {
+			end := p.Offset + p.Size
+			if p.Offset != int64(i*partSize) || end > int64(len(content)) {
+				t.Fatalf("unexpected part %d %#v", i, p)
+			}
+			h := sha256.Sum256(content[p.Offset:end])
+			if p.Sha256 != hex.EncodeToString(h[:]) {
+				t.Errorf("unexpected hash of part %d", i)
+			}
+		}
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		summary, err := checksumFile(f.Name(), "testdata", true, false, 128*1024, partSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.ContentEncoding != "gzip" || summary.ContentSha256 != hex.EncodeToString(contentHash[:]) || summary.TransferLength >= summary.ContentLength {
+			t.Errorf("unexpected summary %#v", summary)
+		}
+	})
+
+	t.Run("part size not divisible by chunk size", func(t *testing.T) {
+		if _, err := checksumFile(f.Name(), "testdata", false, true, 3000, partSize); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("zero chunk size", func(t *testing.T) {
+		for _, size := range []string{"0", "0B"} {
+			err := _main([]string{"artifact", "-q", "--chunk-size", size, "checksum", f.Name()})
+			if err == nil {
+				t.Errorf("expected --chunk-size %s to be rejected", size)
+			}
+		}
+	})
+
+	t.Run("printed", func(t *testing.T) {
+		summary, err := checksumFile(f.Name(), "testdata", false, true, 128*1024, partSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := printChecksum(&out, summary); err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range []string{summary.ContentSha256, summary.Parts[2].Sha256, "PART"} {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("expected %s in output %q", expected, out.String())
+			}
+		}
+	})
+}
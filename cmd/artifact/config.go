@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// configKeys maps the keys of a config file to the global flags which they
+// set.  These are the names used by other taskcluster tools
+var configKeys = map[string]string{
+	"rootUrl":      "root-url",
+	"clientId":     "client-id",
+	"accessToken":  "access-token",
+	"certificate":  "certificate",
+	"queueBaseUrl": "base-url",
+}
+
+// Read the config file given with --config, returning the value of each flag
+// which it sets.  The file has a key: value pair on each line, like a YAML
+// file with only top-level string values, and may have blank lines and
+// comments starting with #.  Values may be quoted, which a certificate must be
+// since it is JSON
+func readConfig(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The config file usually has credentials in it, so it shouldn't be
+	// readable by other users
+	if fi, err := f.Stat(); err == nil && fi.Mode().Perm()&0004 != 0 {
+		log.Printf("WARNING: config file %s is readable by everyone, consider running chmod 600 %s", filename, filename)
+	}
+
+	values := map[string]string{}
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d is not a key: value pair", filename, n)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		flag, ok := configKeys[key]
+		if !ok {
+			return nil, fmt.Errorf("%s:%d has unknown key %s", filename, n, key)
+		}
+
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			if value, err = strconv.Unquote(value); err != nil {
+				return nil, fmt.Errorf("%s:%d has an invalid quoted value for %s", filename, n, key)
+			}
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = strings.Replace(value[1:len(value)-1], "''", "'", -1)
+		}
+
+		values[flag] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// Set each global flag which the config file given with --config has a value
+// for, unless the flag was already given on the command line or with its
+// environment variable, since those override the config file
+func applyConfig(c *cli.Context) error {
+	filename := c.String("config")
+	if filename == "" {
+		return nil
+	}
+
+	values, err := readConfig(filename)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+
+	for flag, value := range values {
+		if c.IsSet(flag) {
+			continue
+		}
+		if err := c.Set(flag, value); err != nil {
+			return fmt.Errorf("setting %s from config file %s: %v", flag, filename, err)
+		}
+	}
+
+	return nil
+}
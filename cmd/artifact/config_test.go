@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestApplyConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(contents string) string {
+		filename := filepath.Join(dir, "config.yml")
+		if err := ioutil.WriteFile(filename, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	run := func(args ...string) (map[string]string, error) {
+		values := map[string]string{}
+		app := cli.NewApp()
+		app.Writer = ioutil.Discard
+		app.Flags = []cli.Flag{
+			cli.StringFlag{Name: "config"},
+			cli.StringFlag{Name: "root-url"},
+			cli.StringFlag{Name: "client-id", EnvVar: "TEST_ARTIFACT_CLIENT_ID"},
+			cli.StringFlag{Name: "access-token"},
+			cli.StringFlag{Name: "certificate"},
+			cli.StringFlag{Name: "base-url"},
+		}
+		app.Before = applyConfig
+		app.Action = func(c *cli.Context) error {
+			for _, flag := range configKeys {
+				values[flag] = c.String(flag)
+			}
+			return nil
+		}
+		err := app.Run(append([]string{"artifact"}, args...))
+		return values, err
+	}
+
+	t.Run("flags and environment override the file", func(t *testing.T) {
+		config := write(`# credentials for testing
+rootUrl: https://file.example.com
+clientId: file-client
+accessToken: 'file-token'
+
+certificate: "{\"version\":1}"
+queueBaseUrl: https://queue.example.com/v1
+`)
+
+		os.Setenv("TEST_ARTIFACT_CLIENT_ID", "env-client")
+		defer os.Unsetenv("TEST_ARTIFACT_CLIENT_ID")
+
+		values, err := run("--config", config, "--root-url", "https://flag.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{
+			"root-url":     "https://flag.example.com",
+			"client-id":    "env-client",
+			"access-token": "file-token",
+			"certificate":  `{"version":1}`,
+			"base-url":     "https://queue.example.com/v1",
+		}
+		for flag, value := range expected {
+			if values[flag] != value {
+				t.Errorf("expected %s to be %q, got %q", flag, value, values[flag])
+			}
+		}
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		for _, contents := range []string{
+			"rootUrl https://file.example.com\n",
+			"password: secret\n",
+			"certificate: \"{\"version\":1}\"\n",
+		} {
+			if _, err := run("--config", write(contents)); err == nil {
+				t.Errorf("expected an error for %q", contents)
+			}
+		}
+
+		if _, err := run("--config", filepath.Join(dir, "missing.yml")); err == nil {
+			t.Error("expected an error for a missing file")
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// relativeExpiry matches expiries like 30d, which are a number followed by a
+// unit of h (hours), d (days), w (weeks), m (months) or y (years)
+var relativeExpiry = regexp.MustCompile(`^([0-9]+)([hdwmy])$`)
+
+// Parse the value of --expires, which is either an RFC3339 timestamp or an
+// expiry relative to now.  The expiry must be in the future
+func parseExpires(value string, now time.Time) (time.Time, error) {
+	var expires time.Time
+
+	if match := relativeExpiry.FindStringSubmatch(value); match != nil {
+		n, err := strconv.Atoi(match[1])
+		if err != nil {
+			return expires, fmt.Errorf("expiry %s is too large", value)
+		}
+		switch match[2] {
+		case "h":
+			expires = now.Add(time.Duration(n) * time.Hour)
+		case "d":
+			expires = now.AddDate(0, 0, n)
+		case "w":
+			expires = now.AddDate(0, 0, 7*n)
+		case "m":
+			expires = now.AddDate(0, n, 0)
+		case "y":
+			expires = now.AddDate(n, 0, 0)
+		}
+	} else {
+		var err error
+		expires, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return expires, fmt.Errorf("expiry %s is neither an RFC3339 timestamp nor a number followed by h, d, w, m or y", value)
+		}
+	}
+
+	if !expires.After(now) {
+		return expires, fmt.Errorf("expiry %s is not in the future", value)
+	}
+
+	return expires, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpires(t *testing.T) {
+	now := time.Date(2019, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	valid := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"6h", time.Date(2019, 1, 31, 18, 0, 0, 0, time.UTC)},
+		{"30d", time.Date(2019, 3, 2, 12, 0, 0, 0, time.UTC)},
+		{"2w", time.Date(2019, 2, 14, 12, 0, 0, 0, time.UTC)},
+		{"6m", time.Date(2019, 7, 31, 12, 0, 0, 0, time.UTC)},
+		{"1y", time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{"2019-02-01T00:00:00Z", time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range valid {
+		actual, err := parseExpires(tt.value, now)
+		if err != nil {
+			t.Errorf("%s: %v", tt.value, err)
+			continue
+		}
+		if !actual.Equal(tt.expected) {
+			t.Errorf("%s: expected %s, got %s", tt.value, tt.expected, actual)
+		}
+	}
+
+	for _, value := range []string{"", "0d", "30", "30s", "tomorrow", "2019-01-01T00:00:00Z", "99999999999999999999d"} {
+		if _, err := parseExpires(value, now); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
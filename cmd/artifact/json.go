@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// uploadSummary is printed by the upload command when --json is given
+type uploadSummary struct {
+	TaskID          string   `json:"taskId"`
+	RunID           string   `json:"runId"`
+	Name            string   `json:"name"`
+	StorageType     string   `json:"storageType"`
+	ContentEncoding string   `json:"contentEncoding"`
+	ContentLength   int64    `json:"contentLength"`
+	ContentSha256   string   `json:"contentSha256"`
+	TransferLength  int64    `json:"transferLength"`
+	TransferSha256  string   `json:"transferSha256"`
+	Etags           []string `json:"etags"`
+	Elapsed         float64  `json:"elapsedSeconds"`
+}
+
+// downloadSummary is printed by the download command when --json is given.
+// Downloads of a URL have no task, run or name
+type downloadSummary struct {
+	TaskID          string  `json:"taskId,omitempty"`
+	RunID           string  `json:"runId,omitempty"`
+	Name            string  `json:"name,omitempty"`
+	URL             string  `json:"url,omitempty"`
+	StorageType     string  `json:"storageType"`
+	ContentType     string  `json:"contentType"`
+	ContentEncoding string  `json:"contentEncoding"`
+	ContentLength   int64   `json:"contentLength"`
+	ContentSha256   string  `json:"contentSha256"`
+	Elapsed         float64 `json:"elapsedSeconds"`
+}
+
+// verifySummary is printed by the verify command when --json is given.
+// Failed verifications are printed as errors, so Valid is always true
+type verifySummary struct {
+	TaskID  string  `json:"taskId,omitempty"`
+	RunID   string  `json:"runId,omitempty"`
+	Name    string  `json:"name,omitempty"`
+	URL     string  `json:"url,omitempty"`
+	Valid   bool    `json:"valid"`
+	Elapsed float64 `json:"elapsedSeconds"`
+}
+
+// artifactSummary describes each artifact printed by the list command when
+// --json is given
+type artifactSummary struct {
+	Name        string    `json:"name"`
+	StorageType string    `json:"storageType"`
+	ContentType string    `json:"contentType"`
+	Expires     time.Time `json:"expires"`
+}
+
+// checksumSummary is printed by the checksum command.  Single part uploads
+// have no parts, and the offsets of the parts are into what's transfered
+type checksumSummary struct {
+	Filename        string         `json:"filename"`
+	ContentEncoding string         `json:"contentEncoding"`
+	ContentLength   int64          `json:"contentLength"`
+	ContentSha256   string         `json:"contentSha256"`
+	TransferLength  int64          `json:"transferLength"`
+	TransferSha256  string         `json:"transferSha256"`
+	Parts           []checksumPart `json:"parts"`
+}
+
+// checksumPart describes each part of a multipart upload in a checksumSummary
+type checksumPart struct {
+	Sha256 string `json:"sha256"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// errorSummary is printed by any command which fails when --json is given
+type errorSummary struct {
+	Error    string `json:"error"`
+	Kind     string `json:"kind"`
+	ExitCode int    `json:"exitCode"`
+}
+
+// Describe an error returned by a command.  The kind is determined from the
+// exit code, so errors which aren't cli.ExitCoders are internal errors
+func newErrorSummary(err error) errorSummary {
+	code := ErrInternal
+	if ecErr, ok := err.(cli.ExitCoder); ok {
+		code = ecErr.ExitCode()
+	}
+	kind := "internal"
+	switch code {
+	case ErrCorrupt:
+		kind = "corrupt"
+	case ErrTimeout:
+		kind = "timeout"
+	case ErrCancelled:
+		kind = "cancelled"
+	}
+	return errorSummary{Error: err.Error(), Kind: kind, ExitCode: code}
+}
+
+// Write a summary as indented JSON
+func printJSON(w io.Writer, summary interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(summary)
+}
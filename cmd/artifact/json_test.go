@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestErrorSummary(t *testing.T) {
+	summaries := []struct {
+		err      error
+		expected errorSummary
+	}{
+		{cli.NewExitError("corrupt", ErrCorrupt), errorSummary{"corrupt", "corrupt", ErrCorrupt}},
+		{cli.NewExitError("timed out", ErrTimeout), errorSummary{"timed out", "timeout", ErrTimeout}},
+		{cli.NewExitError("cancelled", ErrCancelled), errorSummary{"cancelled", "cancelled", ErrCancelled}},
+		{cli.NewExitError("bad usage", ErrInternal), errorSummary{"bad usage", "internal", ErrInternal}},
+		{errors.New("unexplained"), errorSummary{"unexplained", "internal", ErrInternal}},
+	}
+
+	for _, tt := range summaries {
+		if actual := newErrorSummary(tt.err); actual != tt.expected {
+			t.Errorf("expected %#v, got %#v", tt.expected, actual)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := printJSON(&out, newErrorSummary(cli.NewExitError("corrupt", ErrCorrupt))); err != nil {
+		t.Fatal(err)
+	}
+	var printed map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &printed); err != nil {
+		t.Fatal(err)
+	}
+	if printed["kind"] != "corrupt" || printed["exitCode"] != float64(ErrCorrupt) {
+		t.Errorf("unexpected JSON %s", out.String())
+	}
+}
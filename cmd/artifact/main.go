@@ -1,9 +1,17 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/alecthomas/units"
 	tcclient "github.com/taskcluster/taskcluster-client-go"
@@ -18,12 +26,69 @@ import (
 // usage and should not be retried ever, errors which are unexplained internal
 // issues and should be retried, and errors which are because of corruption.
 // We specifically have a corruption case because corruption might need to be
-// handled differently than other errors and so is helpful to be easy to detect
+// handled differently than other errors and so is helpful to be easy to detect.
+// Transfers which exceed --timeout have their own code too, since whatever runs
+// this command may want to retry them later or give up on them entirely.
+// Transfers stopped by SIGINT or SIGTERM exit like a shell does for SIGINT
 const (
-	ErrInternal = 70 // EX_SOFTWARE
-	ErrCorrupt  = 65 // EX_DATAERR
+	ErrInternal  = 70  // EX_SOFTWARE
+	ErrCorrupt   = 65  // EX_DATAERR
+	ErrTimeout   = 75  // EX_TEMPFAIL
+	ErrCancelled = 130 // 128 + SIGINT
 )
 
+// These are the default values of the size flags, which must be in a format
+// accepted by units.ParseBase2Bytes
+var (
+	defaultChunkSize          = fmt.Sprintf("%dKB", artifact.DefaultChunkSize/1024)
+	defaultPartSize           = fmt.Sprintf("%dMB", artifact.DefaultPartSize/1024/1024)
+	defaultMultipartThreshold = "250MB"
+)
+
+// defaultConcurrency is the number of parts of a multipart upload which are
+// uploaded at the same time unless --concurrency is given
+func defaultConcurrency() int {
+	if n := runtime.NumCPU(); n < 4 {
+		return n
+	}
+	return 4
+}
+
+// Determine whether an input should be uploaded as a multipart upload when
+// neither --multipart nor --single-part is given.  Inputs which are at least
+// threshold bytes are
+func chooseMultipart(filename string, threshold int64) (bool, error) {
+	fi, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return false, fmt.Errorf("input %s does not exist", filename)
+	}
+	if err != nil {
+		return false, err
+	}
+	return fi.Size() >= threshold, nil
+}
+
+// Apply the global --chunk-size and --part-size to a client.  They're set
+// together, since the part size must be divisible by the chunk size
+func applySizeFlags(c *cli.Context, client *artifact.Client) error {
+	chunkSize, partSize := client.GetInternalSizes()
+	if c.GlobalIsSet("chunk-size") {
+		cz, err := units.ParseBase2Bytes(c.GlobalString("chunk-size"))
+		if err != nil {
+			return err
+		}
+		chunkSize = int(cz)
+	}
+	if c.GlobalIsSet("part-size") {
+		ps, err := units.ParseBase2Bytes(c.GlobalString("part-size"))
+		if err != nil {
+			return err
+		}
+		partSize = int(ps)
+	}
+	return client.SetInternalSizes(chunkSize, partSize)
+}
+
 func main() {
 	err := _main(os.Args)
 	if err == nil {
@@ -44,7 +109,7 @@ func _main(args []string) error {
 	app := cli.NewApp()
 
 	app.Name = "artifact"
-	app.Version = "0.0.1"
+	app.Version = artifact.Version
 	app.Usage = "interact with taskcluster artifacts"
 
 	app.OnUsageError = func(c *cli.Context, err error, isSubcommand bool) error {
@@ -64,6 +129,11 @@ func _main(args []string) error {
 	}
 
 	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			EnvVar: "ARTIFACT_CONFIG",
+			Usage:  "read the root URL, credentials and queue base URL from `FILE`, which flags and environment variables override",
+		},
 		cli.StringFlag{
 			Name:   "root-url",
 			EnvVar: "TASKCLUSTER_ROOT_URL",
@@ -92,23 +162,47 @@ func _main(args []string) error {
 		cli.StringFlag{
 			Name:   "chunk-size",
 			Usage:  "set the I/O chunk size to `CHUNK_SIZE`",
-			Value:  fmt.Sprintf("%d KB", artifact.DefaultChunkSize),
+			Value:  defaultChunkSize,
 			EnvVar: "ARTIFACT_CHUNK_SIZE",
 		},
 		cli.StringFlag{
 			Name:   "part-size",
-			Usage:  "set the I/O chunk size to `PART_SIZE`",
-			Value:  fmt.Sprintf("%d MB", artifact.DefaultPartSize*artifact.DefaultChunkSize),
+			Usage:  "set the multipart upload part size to `PART_SIZE`",
+			Value:  defaultPartSize,
 			EnvVar: "ARTIFACT_PART_SIZE",
 		},
 		cli.BoolFlag{
 			Name:  "quiet, q",
 			Usage: "supress debugging output",
 		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print a JSON summary of the command's result to stdout",
+		},
+		cli.BoolFlag{
+			Name:  "no-progress",
+			Usage: "do not show the progress of transfers",
+		},
 		cli.BoolFlag{
 			Name:  "allow-insecure-requests",
 			Usage: "allow insecure (http) requests. NOT RECOMMENDED",
 		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			Usage:  "abort an upload or download which takes longer than `DURATION`, like 10m",
+			EnvVar: "ARTIFACT_TIMEOUT",
+		},
+	}
+
+	// Failures are only printed as JSON once the command has run, so we need
+	// to remember whether it was asked for
+	var jsonOutput bool
+	app.Before = func(c *cli.Context) error {
+		jsonOutput = c.Bool("json")
+		if err := applyConfig(c); err != nil {
+			return cli.NewExitError(err.Error(), ErrInternal)
+		}
+		return nil
 	}
 
 	app.Commands = []cli.Command{
@@ -151,6 +245,12 @@ func _main(args []string) error {
 
 				client := artifact.New(q)
 
+				client.OperationTimeout = c.GlobalDuration("timeout")
+
+				ctx, stop := cancelOnSignal()
+				defer stop()
+				client.Context = ctx
+
 				if c.GlobalIsSet("chunk-size") {
 					var cz units.Base2Bytes
 					cz, err = units.ParseBase2Bytes(c.String("chunk-size"))
@@ -176,6 +276,10 @@ func _main(args []string) error {
 					return cli.NewExitError("must specify output", ErrInternal)
 				}
 
+				if c.GlobalBool("json") && c.String("output") == "-" {
+					return cli.NewExitError("cannot print JSON when writing output to stdout", ErrInternal)
+				}
+
 				var output *os.File
 
 				if c.String("output") != "-" {
@@ -188,35 +292,243 @@ func _main(args []string) error {
 					output = os.Stdout
 				}
 
+				if p := progressFor(c); p != nil {
+					client.OnDownloadProgress = p.report
+					defer p.finish()
+				}
+
+				// For the JSON summary, the content is hashed as it's written
+				var dest io.Writer = output
+				contentHash := sha256.New()
+				if c.GlobalBool("json") {
+					dest = io.MultiWriter(output, contentHash)
+				}
+				start := time.Now()
+
+				var result artifact.DownloadResult
+				var summary downloadSummary
 				if c.IsSet("url") {
 					if c.NArg() != 0 {
 						msg := fmt.Sprintf("--url requires zero arguments, received %v", c.Args())
 						return cli.NewExitError(msg, ErrInternal)
 					}
-					err = client.DownloadURL(c.String("url"), output)
+					summary.URL = c.String("url")
+					result, err = client.DownloadURLWithResult(c.String("url"), dest)
 				} else if c.Bool("latest") {
 					if c.NArg() != 2 {
 						msg := fmt.Sprintf("--latest requires two arguments, received %v", c.Args())
 						return cli.NewExitError(msg, ErrInternal)
 					}
-					err = client.DownloadLatest(c.Args().Get(0), c.Args().Get(1), output)
+					summary.TaskID, summary.RunID, summary.Name = c.Args().Get(0), "latest", c.Args().Get(1)
+					result, err = client.DownloadLatestWithResult(c.Args().Get(0), c.Args().Get(1), dest)
 				} else {
 					if c.NArg() != 3 {
 						msg := fmt.Sprintf("three arguments, received %v", c.Args())
 						return cli.NewExitError(msg, ErrInternal)
 					}
-					err = client.Download(c.Args().Get(0), c.Args().Get(1), c.Args().Get(2), output)
+					summary.TaskID, summary.RunID, summary.Name = c.Args().Get(0), c.Args().Get(1), c.Args().Get(2)
+					result, err = client.DownloadWithResult(c.Args().Get(0), c.Args().Get(1), c.Args().Get(2), dest)
+
+				}
+
+				if err == artifact.ErrCorrupt {
+					return cli.NewExitError(err.Error(), ErrCorrupt)
+				}
+
+				if err == artifact.ErrTimeout {
+					return cli.NewExitError(err.Error(), ErrTimeout)
+				}
+
+				if err == artifact.ErrCancelled {
+					return cli.NewExitError(err.Error(), ErrCancelled)
+				}
+
+				if err == nil && c.GlobalBool("json") {
+					summary.StorageType = result.StorageType
+					summary.ContentType = result.ContentType
+					summary.ContentEncoding = result.ContentEncoding
+					summary.ContentLength = result.BytesWritten
+					summary.ContentSha256 = hex.EncodeToString(contentHash.Sum(nil))
+					summary.Elapsed = time.Since(start).Seconds()
+					err = printJSON(os.Stdout, summary)
+				}
+
+				return err
+			},
+			Category: "Downloading",
+		},
+		{
+			Name:    "verify",
+			Aliases: []string{"v"},
+			Usage:   "check that an artifact is intact without keeping it",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "latest",
+					Usage: "verify artifact from latest run",
+				},
+				cli.StringFlag{
+					Name:   "url",
+					Usage:  "use a raw Queue URL instead of specifying taskid, runid or name",
+					EnvVar: "ARTIFACT_URL",
+				},
+			},
+			ArgsUsage: "taskId runId name",
+			Action: func(c *cli.Context) error {
+				var err error
+				if c.IsSet("latest") && c.IsSet("url") {
+					return cli.NewExitError("Cannot specify --latest and --url", ErrInternal)
+				}
+
+				q := tcqueue.New(&tcclient.Credentials{
+					ClientID:    c.GlobalString("client-id"),
+					AccessToken: c.GlobalString("access-token"),
+					Certificate: c.GlobalString("certificate"),
+				}, c.GlobalString("root-url"))
+
+				if c.GlobalIsSet("base-url") {
+					q.BaseURL = c.GlobalString("base-url")
+				}
+
+				client := artifact.New(q)
+
+				client.OperationTimeout = c.GlobalDuration("timeout")
+
+				ctx, stop := cancelOnSignal()
+				defer stop()
+				client.Context = ctx
+
+				if c.GlobalBool("allow-insecure-requests") {
+					client.AllowInsecure = true
+				}
+
+				if c.GlobalBool("quiet") {
+					artifact.SetLogOutput(ioutil.Discard)
+				}
+
+				start := time.Now()
 
+				var summary verifySummary
+				if c.IsSet("url") {
+					if c.NArg() != 0 {
+						msg := fmt.Sprintf("--url requires zero arguments, received %v", c.Args())
+						return cli.NewExitError(msg, ErrInternal)
+					}
+					summary.URL = c.String("url")
+					summary.Valid, err = client.VerifyURL(c.String("url"))
+				} else if c.Bool("latest") {
+					if c.NArg() != 2 {
+						msg := fmt.Sprintf("--latest requires two arguments, received %v", c.Args())
+						return cli.NewExitError(msg, ErrInternal)
+					}
+					summary.TaskID, summary.RunID, summary.Name = c.Args().Get(0), "latest", c.Args().Get(1)
+					summary.Valid, err = client.VerifyLatest(c.Args().Get(0), c.Args().Get(1))
+				} else {
+					if c.NArg() != 3 {
+						msg := fmt.Sprintf("three arguments, received %v", c.Args())
+						return cli.NewExitError(msg, ErrInternal)
+					}
+					summary.TaskID, summary.RunID, summary.Name = c.Args().Get(0), c.Args().Get(1), c.Args().Get(2)
+					summary.Valid, err = client.Verify(c.Args().Get(0), c.Args().Get(1), c.Args().Get(2))
 				}
 
 				if err == artifact.ErrCorrupt {
 					return cli.NewExitError(err.Error(), ErrCorrupt)
 				}
 
+				if err == artifact.ErrTimeout {
+					return cli.NewExitError(err.Error(), ErrTimeout)
+				}
+
+				if err == artifact.ErrCancelled {
+					return cli.NewExitError(err.Error(), ErrCancelled)
+				}
+
+				if err == nil && c.GlobalBool("json") {
+					summary.Elapsed = time.Since(start).Seconds()
+					err = printJSON(os.Stdout, summary)
+				}
+
 				return err
 			},
 			Category: "Downloading",
 		},
+		{
+			Name:    "list",
+			Aliases: []string{"l"},
+			Usage:   "list the artifacts of a task run",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "latest",
+					Usage: "list artifacts of latest run",
+				},
+				cli.StringFlag{
+					Name:  "prefix",
+					Usage: "only list artifacts whose names start with `PREFIX`",
+				},
+			},
+			ArgsUsage: "taskId runId",
+			Action: func(c *cli.Context) error {
+				var err error
+
+				q := tcqueue.New(&tcclient.Credentials{
+					ClientID:    c.GlobalString("client-id"),
+					AccessToken: c.GlobalString("access-token"),
+					Certificate: c.GlobalString("certificate"),
+				}, c.GlobalString("root-url"))
+
+				if c.GlobalIsSet("base-url") {
+					q.BaseURL = c.GlobalString("base-url")
+				}
+
+				client := artifact.New(q)
+
+				if c.GlobalBool("quiet") {
+					artifact.SetLogOutput(ioutil.Discard)
+				}
+
+				var artifacts []artifact.ArtifactInfo
+				if c.Bool("latest") {
+					if c.NArg() != 1 {
+						msg := fmt.Sprintf("--latest requires one argument, received %v", c.Args())
+						return cli.NewExitError(msg, ErrInternal)
+					}
+					artifacts, err = client.ListLatestArtifacts(c.Args().Get(0))
+				} else {
+					if c.NArg() != 2 {
+						msg := fmt.Sprintf("two arguments, received %v", c.Args())
+						return cli.NewExitError(msg, ErrInternal)
+					}
+					artifacts, err = client.ListArtifacts(c.Args().Get(0), c.Args().Get(1))
+				}
+				if err != nil {
+					return err
+				}
+
+				listed := []artifactSummary{}
+				for _, a := range artifacts {
+					if strings.HasPrefix(a.Name, c.String("prefix")) {
+						listed = append(listed, artifactSummary{
+							Name:        a.Name,
+							StorageType: a.StorageType,
+							ContentType: a.ContentType,
+							Expires:     a.Expires,
+						})
+					}
+				}
+
+				if c.GlobalBool("json") {
+					return printJSON(os.Stdout, listed)
+				}
+
+				tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+				fmt.Fprintln(tw, "NAME\tSTORAGE TYPE\tCONTENT TYPE\tEXPIRES")
+				for _, a := range listed {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.Name, a.StorageType, a.ContentType, a.Expires.Format(time.RFC3339))
+				}
+				return tw.Flush()
+			},
+			Category: "Downloading",
+		},
 		{
 			Name:    "upload",
 			Aliases: []string{"u"},
@@ -224,7 +536,7 @@ func _main(args []string) error {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:   "input, i",
-					Usage:  "`FILENAME` to read as artifact.  Must be seekable",
+					Usage:  "`FILENAME` to read as artifact, or - to read standard input",
 					EnvVar: "ARTIFACT_INPUT",
 				},
 				cli.StringFlag{
@@ -232,6 +544,17 @@ func _main(args []string) error {
 					Usage:  "`DIRECTORY` to write temporary files in",
 					EnvVar: "ARTIFACT_TMPDIR",
 				},
+				cli.StringFlag{
+					Name:   "content-type",
+					Usage:  "upload artifact with content type `CONTENT_TYPE` instead of determining it from the input",
+					EnvVar: "ARTIFACT_CONTENT_TYPE",
+				},
+				cli.StringFlag{
+					Name:   "expires",
+					Usage:  "expire artifact at `EXPIRES`, an RFC3339 timestamp or a number of hours, days, weeks, months or years like 6h, 30d, 2w, 6m or 1y",
+					Value:  "1d",
+					EnvVar: "ARTIFACT_EXPIRES",
+				},
 				cli.BoolFlag{
 					Name:  "gzip",
 					Usage: "serve artifact with gzip content-encoding",
@@ -244,10 +567,20 @@ func _main(args []string) error {
 					Name:  "single-part",
 					Usage: "force single part upload",
 				},
+				cli.IntFlag{
+					Name:   "concurrency",
+					Usage:  "upload `N` parts of a multipart upload at the same time",
+					Value:  defaultConcurrency(),
+					EnvVar: "ARTIFACT_CONCURRENCY",
+				},
 				cli.StringFlag{
 					Name:  "multipart-part-size",
 					Usage: "number of bytes before starting to use multipart uploads",
-					Value: "250 MB",
+					Value: defaultMultipartThreshold,
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "create the artifact without uploading or completing it, to check credentials and sizes",
 				},
 			},
 			ArgsUsage: "taskId runId name",
@@ -266,6 +599,12 @@ func _main(args []string) error {
 
 				client := artifact.New(q)
 
+				client.OperationTimeout = c.GlobalDuration("timeout")
+
+				ctx, stop := cancelOnSignal()
+				defer stop()
+				client.Context = ctx
+
 				if c.GlobalBool("quiet") {
 					artifact.SetLogOutput(ioutil.Discard)
 				}
@@ -281,58 +620,94 @@ func _main(args []string) error {
 					gzip = true
 				}
 
-				if c.Bool("single-part") {
-					mp = false
-				} else if c.Bool("multipart") {
-					mp = true
-				} else {
-					if fi, err := os.Stat(c.String("input")); err != nil {
-						if os.IsNotExist(err) {
-							return cli.NewExitError("input does not exist", ErrInternal)
-						}
-						if err != nil {
-							return cli.NewExitError(err.Error(), ErrInternal)
-						}
-						mpsize, err := units.ParseBase2Bytes(c.String("multipart-size"))
-						if err != nil {
-							return err
-						}
-						if fi.Size() >= int64(mpsize) {
-							mp = true
-						}
-					}
+				if c.NArg() != 3 {
+					msg := fmt.Sprintf("three arguments, received %v", c.Args())
+					return cli.NewExitError(msg, ErrInternal)
 				}
 
-				if c.GlobalIsSet("chunk-size") {
-					cz, err := units.ParseBase2Bytes(c.String("chunk-size"))
+				if !c.IsSet("input") {
+					return cli.NewExitError("must specify input", ErrInternal)
+				}
+
+				// The threshold is parsed even when the choice is forced, so that an
+				// invalid value is always reported
+				var mpsize units.Base2Bytes
+				mpsize, err = units.ParseBase2Bytes(c.String("multipart-part-size"))
+				if err != nil {
+					return cli.NewExitError(err.Error(), ErrInternal)
+				}
+
+				client.ContentType = c.String("content-type")
+
+				client.DryRun = c.Bool("dry-run")
+				client.UploadPlanned = func(taskID, runID, name string, r artifact.UploadResult) {
+					log.Printf("dry run of %s/%s/%s: %d bytes of content, %d bytes transfered in %d requests",
+						taskID, runID, name, r.Request.ContentLength, r.Request.TransferLength, len(r.Response.Requests))
+				}
+
+				client.Expires, err = parseExpires(c.String("expires"), time.Now().UTC())
+				if err != nil {
+					return cli.NewExitError(err.Error(), ErrInternal)
+				}
+				if !c.GlobalBool("quiet") {
+					log.Printf("artifact will expire at %s", client.Expires.Format(time.RFC3339))
+				}
+
+				// An explicit --concurrency is rejected for single part uploads.  IsSet
+				// is also true when ARTIFACT_CONCURRENCY is set, which is meant for
+				// every upload, so that isn't rejected
+				explicitConcurrency := c.IsSet("concurrency") && os.Getenv("ARTIFACT_CONCURRENCY") == ""
+				if explicitConcurrency && c.Bool("single-part") {
+					return cli.NewExitError("--concurrency only applies to multipart uploads", ErrInternal)
+				}
+
+				err = client.SetUploadConcurrency(c.Int("concurrency"))
+				if err != nil {
+					return cli.NewExitError(err.Error(), ErrInternal)
+				}
+
+				if err = applySizeFlags(c, client); err != nil {
+					return cli.NewExitError(err.Error(), ErrInternal)
+				}
+
+				// Standard input can't be seeked, so it's copied to a temporary file
+				// which is then uploaded like any other input.  This is only done
+				// once every flag has been checked, so that bad usage doesn't first
+				// read all of standard input
+				inputFilename := c.String("input")
+				if inputFilename == "-" {
+					staged, err := ioutil.TempFile(c.String("tmp-dir"), "tc-artifact-stdin")
 					if err != nil {
 						return cli.NewExitError(err.Error(), ErrInternal)
 					}
-					_, ps := client.GetInternalSizes()
-					err = client.SetInternalSizes(int(cz), ps)
-					if err != nil {
+					defer func() {
+						staged.Close()
+						os.Remove(staged.Name())
+					}()
+					if _, err = io.Copy(staged, os.Stdin); err != nil {
 						return cli.NewExitError(err.Error(), ErrInternal)
 					}
+					inputFilename = staged.Name()
 				}
 
-				if c.GlobalIsSet("part-size") {
-					var ps units.Base2Bytes
-					ps, err = units.ParseBase2Bytes(c.String("part-size"))
-					if err != nil {
-						return cli.NewExitError(err.Error(), ErrInternal)
-					}
-					cz, _ := client.GetInternalSizes()
-					err = client.SetInternalSizes(cz, int(ps))
+				if c.Bool("single-part") {
+					mp = false
+				} else if c.Bool("multipart") {
+					mp = true
+				} else {
+					mp, err = chooseMultipart(inputFilename, int64(mpsize))
 					if err != nil {
 						return cli.NewExitError(err.Error(), ErrInternal)
 					}
 				}
 
-				if !c.IsSet("input") {
-					return cli.NewExitError("must specify input", ErrInternal)
+				// Whether an upload without --single-part or --multipart is multipart
+				// depends on the size of its input, which is only known by now
+				if explicitConcurrency && !mp {
+					return cli.NewExitError("--concurrency only applies to multipart uploads", ErrInternal)
 				}
 
-				input, err := os.Open(c.String("input"))
+				input, err := os.Open(inputFilename)
 				if err != nil {
 					return cli.NewExitError(err.Error(), ErrInternal)
 				}
@@ -347,21 +722,239 @@ func _main(args []string) error {
 					os.Remove(output.Name())
 				}()
 
-				if c.NArg() != 3 {
-					msg := fmt.Sprintf("three arguments, received %v", c.Args())
-					return cli.NewExitError(msg, ErrInternal)
+				if p := progressFor(c); p != nil {
+					client.OnUploadProgress = p.report
+					defer p.finish()
+				}
+
+				var result artifact.UploadResult
+				client.UploadCompleted = func(taskID, runID, name string, r artifact.UploadResult) {
+					result = r
 				}
+				start := time.Now()
+
 				err = client.Upload(c.Args().Get(0), c.Args().Get(1), c.Args().Get(2), input, output, gzip, mp)
 
 				if err == artifact.ErrCorrupt {
 					return cli.NewExitError(err.Error(), ErrCorrupt)
 				}
 
+				if err == artifact.ErrTimeout {
+					return cli.NewExitError(err.Error(), ErrTimeout)
+				}
+
+				if err == artifact.ErrCancelled {
+					return cli.NewExitError(err.Error(), ErrCancelled)
+				}
+
+				if err == nil && c.GlobalBool("json") {
+					err = printJSON(os.Stdout, uploadSummary{
+						TaskID:          c.Args().Get(0),
+						RunID:           c.Args().Get(1),
+						Name:            c.Args().Get(2),
+						StorageType:     result.Request.StorageType,
+						ContentEncoding: result.Request.ContentEncoding,
+						ContentLength:   result.Request.ContentLength,
+						ContentSha256:   result.Request.ContentSha256,
+						TransferLength:  result.Request.TransferLength,
+						TransferSha256:  result.Request.TransferSha256,
+						Etags:           result.Etags,
+						Elapsed:         time.Since(start).Seconds(),
+					})
+				}
+
 				return err
 			},
 			Category: "Uploading",
 		},
+		{
+			Name:  "upload-dir",
+			Usage: "upload each file in a directory tree as an artifact",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "tmp-dir",
+					Usage:  "`DIRECTORY` to write temporary files in",
+					EnvVar: "ARTIFACT_TMPDIR",
+				},
+				cli.BoolFlag{
+					Name:  "gzip",
+					Usage: "serve artifacts with gzip content-encoding",
+				},
+				cli.StringFlag{
+					Name:  "multipart-part-size",
+					Usage: "number of bytes before starting to use multipart uploads",
+					Value: defaultMultipartThreshold,
+				},
+				cli.IntFlag{
+					Name:   "file-concurrency",
+					Usage:  "upload `N` files at the same time",
+					Value:  defaultConcurrency(),
+					EnvVar: "ARTIFACT_FILE_CONCURRENCY",
+				},
+				cli.StringFlag{
+					Name:   "expires",
+					Usage:  "expire artifacts at `EXPIRES`, an RFC3339 timestamp or a number of hours, days, weeks, months or years like 6h, 30d, 2w, 6m or 1y",
+					Value:  "1d",
+					EnvVar: "ARTIFACT_EXPIRES",
+				},
+			},
+			ArgsUsage: "taskId runId localDir remotePrefix",
+			Action: func(c *cli.Context) error {
+				var err error
+
+				if c.NArg() != 4 {
+					msg := fmt.Sprintf("four arguments, received %v", c.Args())
+					return cli.NewExitError(msg, ErrInternal)
+				}
+
+				if c.Int("file-concurrency") < 1 {
+					msg := fmt.Sprintf("file concurrency %d is not minimum of 1", c.Int("file-concurrency"))
+					return cli.NewExitError(msg, ErrInternal)
+				}
+
+				q := tcqueue.New(&tcclient.Credentials{
+					ClientID:    c.GlobalString("client-id"),
+					AccessToken: c.GlobalString("access-token"),
+					Certificate: c.GlobalString("certificate"),
+				}, c.GlobalString("root-url"))
+
+				if c.GlobalIsSet("base-url") {
+					q.BaseURL = c.GlobalString("base-url")
+				}
+
+				client := artifact.New(q)
+
+				client.OperationTimeout = c.GlobalDuration("timeout")
+
+				ctx, stop := cancelOnSignal()
+				defer stop()
+				client.Context = ctx
+
+				if c.GlobalBool("allow-insecure-requests") {
+					client.AllowInsecure = true
+				}
+
+				if c.GlobalBool("quiet") {
+					artifact.SetLogOutput(ioutil.Discard)
+				}
+
+				if err = applySizeFlags(c, client); err != nil {
+					return cli.NewExitError(err.Error(), ErrInternal)
+				}
+
+				var mpsize units.Base2Bytes
+				mpsize, err = units.ParseBase2Bytes(c.String("multipart-part-size"))
+				if err != nil {
+					return cli.NewExitError(err.Error(), ErrInternal)
+				}
+
+				client.Expires, err = parseExpires(c.String("expires"), time.Now().UTC())
+				if err != nil {
+					return cli.NewExitError(err.Error(), ErrInternal)
+				}
+
+				files, err := findDirFiles(c.Args().Get(2), c.Args().Get(3))
+				if err != nil {
+					return cli.NewExitError(err.Error(), ErrInternal)
+				}
+
+				results := uploadDirFiles(client, c.Args().Get(0), c.Args().Get(1), c.String("tmp-dir"), files, c.Bool("gzip"), int64(mpsize), c.Int("file-concurrency"))
+
+				var failed int
+				for _, r := range results {
+					if r.Error != "" {
+						failed++
+						if !c.GlobalBool("json") {
+							fmt.Fprintf(os.Stderr, "failed to upload %s as %s: %s\n", r.Filename, r.Name, r.Error)
+						}
+					}
+				}
+
+				if c.GlobalBool("json") {
+					err = printJSON(os.Stdout, results)
+					if err != nil {
+						return err
+					}
+				} else if !c.GlobalBool("quiet") {
+					log.Printf("uploaded %d of %d files", len(results)-failed, len(results))
+				}
+
+				if failed > 0 {
+					msg := fmt.Sprintf("%d of %d files failed to upload", failed, len(results))
+					if ctx.Err() != nil {
+						return cli.NewExitError(msg, ErrCancelled)
+					}
+					return cli.NewExitError(msg, ErrInternal)
+				}
+
+				return nil
+			},
+			Category: "Uploading",
+		},
+		{
+			Name:  "checksum",
+			Usage: "print the hashes, sizes and parts a file would be uploaded with, without uploading it",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "tmp-dir",
+					Usage:  "`DIRECTORY` to write temporary files in",
+					EnvVar: "ARTIFACT_TMPDIR",
+				},
+				cli.BoolFlag{
+					Name:  "gzip",
+					Usage: "compute the hashes of a gzip content-encoded upload",
+				},
+				cli.BoolFlag{
+					Name:  "multipart",
+					Usage: "compute the hashes of a multipart upload",
+				},
+				cli.StringFlag{
+					Name:  "part-size",
+					Usage: "set the multipart upload part size to `PART_SIZE`, instead of the global --part-size",
+				},
+			},
+			ArgsUsage: "filename",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					msg := fmt.Sprintf("one argument, received %v", c.Args())
+					return cli.NewExitError(msg, ErrInternal)
+				}
+
+				if c.GlobalBool("quiet") {
+					artifact.SetLogOutput(ioutil.Discard)
+				}
+
+				cz, err := units.ParseBase2Bytes(c.GlobalString("chunk-size"))
+				if err != nil {
+					return cli.NewExitError(err.Error(), ErrInternal)
+				}
+
+				partSize := c.GlobalString("part-size")
+				if c.IsSet("part-size") {
+					partSize = c.String("part-size")
+				}
+				ps, err := units.ParseBase2Bytes(partSize)
+				if err != nil {
+					return cli.NewExitError(err.Error(), ErrInternal)
+				}
+
+				summary, err := checksumFile(c.Args().Get(0), c.String("tmp-dir"), c.Bool("gzip"), c.Bool("multipart"), int(cz), int(ps))
+				if err != nil {
+					return cli.NewExitError(err.Error(), ErrInternal)
+				}
+
+				if c.GlobalBool("json") {
+					return printJSON(os.Stdout, summary)
+				}
+				return printChecksum(os.Stdout, summary)
+			},
+			Category: "Uploading",
+		},
 	}
 
-	return app.Run(args)
+	err := app.Run(args)
+	if err != nil && jsonOutput {
+		printJSON(os.Stdout, newErrorSummary(err))
+	}
+	return err
 }
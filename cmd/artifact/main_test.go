@@ -13,9 +13,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alecthomas/units"
 	"github.com/taskcluster/slugid-go/slugid"
 	tcclient "github.com/taskcluster/taskcluster-client-go"
 	"github.com/taskcluster/taskcluster-client-go/tcqueue"
+	artifact "github.com/taskcluster/taskcluster-lib-artifact-go"
 	"github.com/urfave/cli"
 )
 
@@ -208,6 +210,13 @@ func TestCLIUsage(t *testing.T) {
 
 	// Conflicting flags
 	badUsage(t, "upload", "--input", e.inputFilename, "--multipart", "--single-part", e.taskID, e.runID, name)
+	badUsage(t, "upload", "--input", e.inputFilename, "--single-part", "--concurrency", "2", e.taskID, e.runID, name)
+
+	// Invalid values
+	badUsage(t, "upload", "--input", e.inputFilename, "--expires", "yesterday", e.taskID, e.runID, name)
+	badUsage(t, "upload", "--input", e.inputFilename, "--multipart-part-size", "lots", e.taskID, e.runID, name)
+	badUsage(t, "upload", "--input", e.inputFilename, "--expires", "2000-01-01T00:00:00Z", e.taskID, e.runID, name)
+	badUsage(t, "upload", "--input", e.inputFilename, "--multipart", "--concurrency", "0", e.taskID, e.runID, name)
 
 	// Missing mandatory flag
 	badUsage(t, "upload", e.taskID, e.runID, name)
@@ -217,6 +226,122 @@ func TestCLIUsage(t *testing.T) {
 	badUsage(t, "upload", "--output", e.inputFilename, e.taskID, e.runID, name)
 	badUsage(t, "download", "--input", e.outputFilename, e.taskID, e.runID, name)
 	badUsage(t, "download", "--url", "--latest", "--output", e.outputFilename)
+	badUsage(t, "verify")
+	badUsage(t, "list")
+	badUsage(t, "upload-dir", e.taskID, e.runID, "testdata")
+	badUsage(t, "upload-dir", "--file-concurrency", "0", e.taskID, e.runID, "testdata", "public/dir")
+	badUsage(t, "list", "--latest", e.taskID, e.runID)
+	badUsage(t, "verify", "--url", "--latest")
+	badUsage(t, "--json", "download", "--output", "-", e.taskID, e.runID, name)
+}
+
+func TestSizeFlagDefaults(t *testing.T) {
+	defaults := []struct {
+		value    string
+		expected int
+	}{
+		{defaultChunkSize, artifact.DefaultChunkSize},
+		{defaultPartSize, artifact.DefaultPartSize},
+		{defaultMultipartThreshold, 250 * 1024 * 1024},
+	}
+
+	for _, tt := range defaults {
+		actual, err := units.ParseBase2Bytes(tt.value)
+		if err != nil {
+			t.Errorf("default %s does not parse: %v", tt.value, err)
+		} else if int(actual) != tt.expected {
+			t.Errorf("default %s is %d bytes, expected %d", tt.value, actual, tt.expected)
+		}
+	}
+}
+
+func TestApplySizeFlags(t *testing.T) {
+	sizes := []struct {
+		args      []string
+		chunkSize int
+		partSize  int
+		fails     bool
+	}{
+		{nil, artifact.DefaultChunkSize, artifact.DefaultPartSize, false},
+		{[]string{"--chunk-size", "64KB"}, 64 * 1024, artifact.DefaultPartSize, false},
+		{[]string{"--part-size", "10MB"}, artifact.DefaultChunkSize, 10 * 1024 * 1024, false},
+		{[]string{"--chunk-size", "1MB", "--part-size", "6MB"}, 1024 * 1024, 6 * 1024 * 1024, false},
+		{[]string{"--chunk-size", "lots"}, 0, 0, true},
+		{[]string{"--part-size", "1MB"}, 0, 0, true},
+	}
+
+	for _, tt := range sizes {
+		client := artifact.New(nil)
+
+		app := cli.NewApp()
+		app.Flags = []cli.Flag{
+			cli.StringFlag{Name: "chunk-size", Value: defaultChunkSize},
+			cli.StringFlag{Name: "part-size", Value: defaultPartSize},
+		}
+		var applyErr error
+		app.Commands = []cli.Command{{
+			Name: "sizes",
+			Action: func(c *cli.Context) error {
+				applyErr = applySizeFlags(c, client)
+				return nil
+			},
+		}}
+		if err := app.Run(append(append([]string{"artifact"}, tt.args...), "sizes")); err != nil {
+			t.Fatal(err)
+		}
+
+		if tt.fails {
+			if applyErr == nil {
+				t.Errorf("expected %v to fail", tt.args)
+			}
+			continue
+		}
+		if applyErr != nil {
+			t.Errorf("%v: %v", tt.args, applyErr)
+		} else if cz, ps := client.GetInternalSizes(); cz != tt.chunkSize || ps != tt.partSize {
+			t.Errorf("%v: expected sizes %d and %d, got %d and %d", tt.args, tt.chunkSize, tt.partSize, cz, ps)
+		}
+	}
+}
+
+func TestChooseMultipart(t *testing.T) {
+	err := os.MkdirAll("testdata", 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input, err := ioutil.TempFile("testdata", "test-multipart-threshold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(input.Name())
+	if _, err = input.Write(make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	input.Close()
+
+	thresholds := []struct {
+		threshold int64
+		expected  bool
+	}{
+		{1023, true},
+		{1024, true},
+		{1025, false},
+	}
+
+	for _, tt := range thresholds {
+		mp, err := chooseMultipart(input.Name(), tt.threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mp != tt.expected {
+			t.Errorf("expected multipart %t for 1024 byte input with threshold %d", tt.expected, tt.threshold)
+		}
+	}
+
+	if _, err := chooseMultipart("testdata/does-not-exist", 1024); err == nil {
+		t.Error("expected an error for an input which does not exist")
+	}
 }
 
 func TestCorruptedDownloads(t *testing.T) {
@@ -299,15 +424,19 @@ func TestCLIRuns(t *testing.T) {
 			e.validate()
 			e.run(t, "download", "--latest", "--output", e.outputFilename, e.taskID, name)
 			e.validate()
+			e.run(t, "verify", e.taskID, e.runID, name)
 		})
 	}
 
 	validateUploadOptions("auto-identity") // no upload options
 	validateUploadOptions("auto-gzip", "--gzip")
+	validateUploadOptions("auto-multipart", "--multipart-part-size", "5MB")
 	validateUploadOptions("single-part-identity", "--single-part")
 	validateUploadOptions("single-part-gzip", "--single-part", "--gzip")
 	validateUploadOptions("multipart-identity", "--multipart")
 	validateUploadOptions("multipart-gzip", "--multipart", "--gzip")
+	validateUploadOptions("multipart-concurrent", "--multipart", "--concurrency", "2")
+	validateUploadOptions("expires", "--expires", "2w")
 
 	t.Run("downloading a url", func(t *testing.T) {
 		name := "public/downloading-url"
@@ -320,6 +449,51 @@ func TestCLIRuns(t *testing.T) {
 		e.validate()
 	})
 
+	t.Run("uploading a directory", func(t *testing.T) {
+		dir, err := ioutil.TempDir("testdata", "upload-dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		b, err := ioutil.ReadFile(e.inputFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Mkdir(dir+"/nested", 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(dir+"/nested/input", b, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		e.run(t, "upload-dir", e.taskID, e.runID, dir, "public/dir")
+		e.run(t, "download", "--output", e.outputFilename, e.taskID, e.runID, "public/dir/nested/input")
+		e.validate()
+	})
+
+	t.Run("listing artifacts", func(t *testing.T) {
+		e.run(t, "list", "--prefix", "public/", e.taskID, e.runID)
+		e.run(t, "list", "--latest", e.taskID)
+	})
+
+	t.Run("upload from stdin", func(t *testing.T) {
+		name := "public/stdin"
+		stdin, err := os.Open(e.inputFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stdin.Close()
+
+		realStdin := os.Stdin
+		os.Stdin = stdin
+		defer func() { os.Stdin = realStdin }()
+
+		e.run(t, "upload", "--input", "-", e.taskID, e.runID, name)
+		e.run(t, "download", "--output", e.outputFilename, e.taskID, e.runID, name)
+		e.validate()
+	})
+
 	t.Run("download to stdout", func(t *testing.T) {
 		name := "public/small"
 		filename := "./testdata/small"
@@ -338,7 +512,82 @@ func TestCLIRuns(t *testing.T) {
 		// errors are thrown.  Patches welcome!  The specific case that I'm
 		// concerned with is that os.Stdout is an io.Seeker, but all calls to
 		// Seek() on it immediately fail.  There's probably other things, but this
-		// is the minimum that's different
+		// is the minimum that's different.  Outputs whose Seek() fails are
+		// tested without os.Stdout in the library's TestNonEmptyOutput
 		e.run(t, "download", "--output", "-", e.taskID, e.runID, name)
 	})
 }
+
+func TestConcurrencyFromEnvironment(t *testing.T) {
+	err := os.MkdirAll("testdata", 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input, err := ioutil.TempFile("testdata", "test-concurrency-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(input.Name())
+	input.WriteString("small enough to be uploaded as a single part")
+	input.Close()
+
+	// The Queue refuses the artifact, so each upload fails once it gets past
+	// checking its flags
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+	}))
+	defer ts.Close()
+
+	upload := func(flags ...string) error {
+		args := []string{"artifact", "-q", "--base-url", ts.URL, "upload", "--input", input.Name()}
+		args = append(args, flags...)
+		return _main(append(args, slugid.Nice(), "0", "public/concurrency-env"))
+	}
+
+	if err := upload("--single-part", "--concurrency", "2"); err == nil || !strings.Contains(err.Error(), "--concurrency") {
+		t.Fatalf("expected an explicit --concurrency to be rejected, got %v", err)
+	}
+
+	os.Setenv("ARTIFACT_CONCURRENCY", "2")
+	defer os.Unsetenv("ARTIFACT_CONCURRENCY")
+
+	if err := upload(); err == nil || strings.Contains(err.Error(), "--concurrency") {
+		t.Fatalf("expected ARTIFACT_CONCURRENCY to be ignored for a single part upload, got %v", err)
+	}
+}
+
+func TestStdinNotReadForBadUsage(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.WriteString("not read")
+	w.Close()
+
+	realStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = realStdin }()
+
+	taskID := slugid.Nice()
+	for _, args := range [][]string{
+		{"upload", "--input", "-", taskID, "0"},
+		{"upload", "--input", "-", "--expires", "yesterday", taskID, "0", "public/stdin"},
+		{"upload", "--input", "-", "--multipart", "--concurrency", "0", taskID, "0", "public/stdin"},
+		{"upload", "--input", "-", "--single-part", "--concurrency", "2", taskID, "0", "public/stdin"},
+		{"--part-size", "1MB", "upload", "--input", "-", taskID, "0", "public/stdin"},
+	} {
+		if err := _main(append([]string{"artifact", "-q"}, args...)); err == nil {
+			t.Fatalf("%v did not fail as expected", args)
+		}
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "not read" {
+		t.Fatalf("expected standard input to be left unread, %q was left", b)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/units"
+	"github.com/urfave/cli"
+)
+
+// progressBarWidth is the number of characters between the brackets of the
+// progress bar
+const progressBarWidth = 40
+
+// progressLogInterval is how often a line is printed for transfers of unknown
+// size when stderr isn't a terminal
+const progressLogInterval = 10 * units.MiB
+
+// A progressPrinter shows how much of a transfer has completed.  When the
+// output is a terminal, a progress bar is redrawn in place.  Otherwise, a line
+// is printed each time another tenth of the transfer has completed, so that
+// logs of scripted transfers aren't filled with progress bars
+type progressPrinter struct {
+	lock     sync.Mutex
+	out      io.Writer
+	tty      bool
+	lastStep int64
+	drawn    bool
+}
+
+// Determine whether a file is a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Create the progressPrinter for a command.  Progress is always written to
+// stderr so that it doesn't end up in output written to stdout.  When progress
+// is disabled, or when it would only be log lines and logging is disabled, nil
+// is returned
+func progressFor(c *cli.Context) *progressPrinter {
+	if c.GlobalBool("no-progress") {
+		return nil
+	}
+	tty := isTerminal(os.Stderr)
+	if !tty && c.GlobalBool("quiet") {
+		return nil
+	}
+	return &progressPrinter{out: os.Stderr, tty: tty}
+}
+
+// report is used as the Client's OnUploadProgress or OnDownloadProgress
+// function.  The total is -1 when it isn't known
+func (p *progressPrinter) report(transfered, total int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.tty {
+		p.drawn = true
+		if total <= 0 {
+			fmt.Fprintf(p.out, "\r%s transfered", units.Base2Bytes(transfered))
+			return
+		}
+		// Progress shouldn't pass the total, but a bar which overflows would
+		// be worse than one which stops at full
+		percent := transfered * 100 / total
+		if percent > 100 {
+			percent = 100
+		} else if percent < 0 {
+			percent = 0
+		}
+		filled := int(percent * progressBarWidth / 100)
+		fmt.Fprintf(p.out, "\r[%s%s] %3d%% %s/%s",
+			strings.Repeat("=", filled),
+			strings.Repeat(" ", progressBarWidth-filled),
+			percent,
+			units.Base2Bytes(transfered),
+			units.Base2Bytes(total))
+		return
+	}
+
+	if total <= 0 {
+		if step := transfered / int64(progressLogInterval); step > p.lastStep {
+			p.lastStep = step
+			fmt.Fprintf(p.out, "%s transfered\n", units.Base2Bytes(transfered))
+		}
+		return
+	}
+	if step := transfered * 10 / total; step > p.lastStep && step <= 10 {
+		p.lastStep = step
+		fmt.Fprintf(p.out, "%d%% of %s transfered\n", step*10, units.Base2Bytes(total))
+	}
+}
+
+// finish ends the line the progress bar was drawn on, so that whatever is
+// written next starts on a line of its own
+func (p *progressPrinter) finish() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.drawn {
+		fmt.Fprintln(p.out)
+		p.drawn = false
+	}
+}
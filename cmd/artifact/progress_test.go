@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProgressPrinter(t *testing.T) {
+	t.Run("draws a bar on terminals", func(t *testing.T) {
+		var out bytes.Buffer
+		p := &progressPrinter{out: &out, tty: true}
+		p.report(512, 1024)
+		p.report(1024, 1024)
+		p.finish()
+
+		expected := "\r[====================                    ]  50% 512B/1KiB" +
+			"\r[========================================] 100% 1KiB/1KiB\n"
+		if out.String() != expected {
+			t.Fatalf("expected %q, got %q", expected, out.String())
+		}
+	})
+
+	t.Run("stops at full", func(t *testing.T) {
+		var out bytes.Buffer
+		p := &progressPrinter{out: &out, tty: true}
+		p.report(1536, 1024)
+		p.finish()
+
+		expected := "\r[========================================] 100% 1KiB512B/1KiB\n"
+		if out.String() != expected {
+			t.Fatalf("expected %q, got %q", expected, out.String())
+		}
+	})
+
+	t.Run("prints a line per tenth otherwise", func(t *testing.T) {
+		var out bytes.Buffer
+		p := &progressPrinter{out: &out}
+		for i := int64(1); i <= 100; i++ {
+			p.report(i, 100)
+		}
+		p.finish()
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 10 {
+			t.Fatalf("expected 10 lines, got %d: %q", len(lines), out.String())
+		}
+		if lines[0] != "10% of 100B transfered" || lines[9] != "100% of 100B transfered" {
+			t.Fatalf("unexpected lines %q", lines)
+		}
+	})
+}
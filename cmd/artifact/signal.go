@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Return a context which is cancelled when the command receives SIGINT or
+// SIGTERM, so that the transfer it's running is aborted and the command can
+// return normally, removing any temporary files it created.  A second signal
+// exits straight away, for transfers which don't stop.  The function returned
+// stops handling the signals once the transfer is over
+func cancelOnSignal() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("received %s, stopping.  Send it again to exit immediately", sig)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-signals:
+			os.Exit(ErrCancelled)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	artifact "github.com/taskcluster/taskcluster-lib-artifact-go"
+)
+
+// A dirFile is a file found by the upload-dir command and the name of the
+// artifact it's uploaded as
+type dirFile struct {
+	Filename string `json:"filename"`
+	Name     string `json:"name"`
+}
+
+// A dirFileResult is the outcome of uploading a dirFile
+type dirFileResult struct {
+	dirFile
+	Error string `json:"error,omitempty"`
+}
+
+// Find the regular files in a directory tree.  Each file's artifact name is
+// its path relative to the directory, using forward slashes, appended to the
+// prefix.  An empty prefix leaves the relative paths as they are, so that no
+// name starts with a slash
+func findDirFiles(dir, prefix string) ([]dirFile, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	var files []dirFile
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if prefix != "" {
+			name = prefix + "/" + name
+		}
+		files = append(files, dirFile{Filename: path, Name: name})
+		return nil
+	})
+	return files, err
+}
+
+// Upload a single file found by the upload-dir command.  The choice between
+// single and multipart uploads is made for each file
+func uploadDirFile(client *artifact.Client, taskID, runID, tmpDir string, f dirFile, gzip bool, threshold int64) error {
+	mp, err := chooseMultipart(f.Filename, threshold)
+	if err != nil {
+		return err
+	}
+
+	input, err := os.Open(f.Filename)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	output, err := ioutil.TempFile(tmpDir, "tc-artifact")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		output.Close()
+		os.Remove(output.Name())
+	}()
+
+	return client.Upload(taskID, runID, f.Name, input, output, gzip, mp)
+}
+
+// Upload files, up to concurrency of them at the same time.  Every file is
+// attempted, and the results are in the same order as the files
+func uploadDirFiles(client *artifact.Client, taskID, runID, tmpDir string, files []dirFile, gzip bool, threshold int64, concurrency int) []dirFileResult {
+	results := make([]dirFileResult, len(files))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i].dirFile = files[i]
+				if err := uploadDirFile(client, taskID, runID, tmpDir, files[i], gzip, threshold); err != nil {
+					results[i].Error = err.Error()
+				}
+			}
+		}()
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
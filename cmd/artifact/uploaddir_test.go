@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFindDirFiles(t *testing.T) {
+	err := os.MkdirAll("testdata", 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("testdata", "upload-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.txt", "logs/b.log", "logs/nested/c.json"} {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, prefix := range []string{"public/build", "public/build/"} {
+		files, err := findDirFiles(dir, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+
+		expected := []string{"public/build/a.txt", "public/build/logs/b.log", "public/build/logs/nested/c.json"}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("with prefix %s, expected %v, got %v", prefix, expected, names)
+		}
+	}
+
+	for _, prefix := range []string{"", "/"} {
+		files, err := findDirFiles(dir, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+
+		expected := []string{"a.txt", "logs/b.log", "logs/nested/c.json"}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("with prefix %q, expected %v, got %v", prefix, expected, names)
+		}
+	}
+}
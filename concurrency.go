@@ -0,0 +1,91 @@
+package artifact
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// A concurrencyLimiter limits how many parts of an upload are in flight,
+// adapting the limit to how S3 responds.  The limit starts low and grows by
+// one for each part which is uploaded, up to the maximum, and is halved each
+// time S3 asks us to slow down.  This is additive increase, multiplicative
+// decrease, which avoids S3's 503 Slow Down responses to bursts of requests
+// while still reaching the full concurrency when S3 keeps up
+type concurrencyLimiter struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	max      int
+	inFlight int
+}
+
+func newConcurrencyLimiter(initial, max int) *concurrencyLimiter {
+	if initial > max {
+		initial = max
+	}
+	l := &concurrencyLimiter{limit: initial, max: max}
+	l.cond = sync.NewCond(&l.lock)
+	return l
+}
+
+// Wait until another part can be put in flight
+func (l *concurrencyLimiter) acquire() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for l.inFlight >= l.limit {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+// Take a part out of flight, adjusting the limit according to whether S3
+// asked us to slow down
+func (l *concurrencyLimiter) release(slowDown bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.inFlight--
+	if slowDown {
+		if l.limit = l.limit / 2; l.limit < 1 {
+			l.limit = 1
+		}
+	} else if l.limit < l.max {
+		l.limit++
+	}
+	l.cond.Broadcast()
+}
+
+// The current limit, for logging
+func (l *concurrencyLimiter) current() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.limit
+}
+
+// Choose a delay between half of delay and delay, so that parts which were
+// asked to slow down at the same time aren't all retried at the same time.
+// A delay which isn't positive means retrying straight away
+func jitter(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		return 0
+	}
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Wait for d on the Client's clock, returning early with ErrTimeout or
+// ErrCancelled if the operation is stopped first
+func (c *Client) sleep(d time.Duration) error {
+	t := c.clock.NewTimer(d)
+	defer t.Stop()
+	if c.agent.operation == nil {
+		<-t.C
+		return nil
+	}
+	select {
+	case <-t.C:
+		return nil
+	case <-c.agent.operation.Done():
+		return c.agent.stopped()
+	}
+}
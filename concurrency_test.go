@@ -0,0 +1,207 @@
+package artifact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/taskcluster/taskcluster-client-go/tcqueue"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := newConcurrencyLimiter(1, 4)
+
+	expect := func(limit int) {
+		t.Helper()
+		if current := l.current(); current != limit {
+			t.Fatalf("expected a limit of %d, got %d", limit, current)
+		}
+	}
+
+	// Each part which is uploaded lets one more be in flight
+	for _, limit := range []int{2, 3, 4, 4} {
+		l.acquire()
+		l.release(false)
+		expect(limit)
+	}
+
+	// Slowing down halves the limit, but never below one
+	for _, limit := range []int{2, 1, 1} {
+		l.acquire()
+		l.release(true)
+		expect(limit)
+	}
+
+	// A part which is waiting is let through once the limit allows it
+	l.acquire()
+	acquired := make(chan struct{})
+	go func() {
+		l.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected the second part to wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.release(false)
+	<-acquired
+}
+
+func TestRetryDelay(t *testing.T) {
+	for _, delay := range []time.Duration{-time.Second, 0, time.Second, maxRetryDelay, time.Duration(1<<63 - 1)} {
+		wait := jitter(delay)
+		if delay <= 0 && wait != 0 {
+			t.Errorf("expected no wait for a delay of %s, got %s", delay, wait)
+		}
+		if delay > 0 && (wait < delay/2 || wait > delay) {
+			t.Errorf("expected a wait between %s and %s, got %s", delay/2, delay, wait)
+		}
+	}
+
+	// Doubling stops at the maximum, however many retries there are
+	delay := DefaultRetryDelay
+	for i := 0; i < 100; i++ {
+		delay = nextRetryDelay(delay)
+	}
+	if delay != maxRetryDelay {
+		t.Errorf("expected the delay to stop at %s, got %s", maxRetryDelay, delay)
+	}
+
+	// A longer delay chosen by the user isn't shortened
+	if delay := nextRetryDelay(time.Hour); delay != time.Hour {
+		t.Errorf("expected a delay of %s to be kept, got %s", time.Hour, delay)
+	}
+}
+
+func TestAdaptiveUploadConcurrency(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	var lock sync.Mutex
+	var inFlight, maxInFlight int
+	// The number of parts in flight as each request started
+	var started []int
+	slowDowns := map[string]int{}
+	var completed tcqueue.CompleteArtifactRequest
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			var requests []string
+			for i := 1; i <= 6; i++ {
+				requests = append(requests, fmt.Sprintf(`{"url": "%s/s3/part%d", "method": "PUT", "headers": {}}`, ts.URL, i))
+			}
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [` + strings.Join(requests, ",") + `]}`))
+		case strings.HasPrefix(r.URL.Path, "/s3/"):
+			ioutil.ReadAll(r.Body)
+			part := strings.TrimPrefix(r.URL.Path, "/s3/")
+
+			lock.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			started = append(started, inFlight)
+			slowDown := slowDowns[part] > 0
+			if slowDown {
+				slowDowns[part]--
+			}
+			lock.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			lock.Lock()
+			inFlight--
+			lock.Unlock()
+
+			if slowDown {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("<Error><Code>SlowDown</Code></Error>"))
+				return
+			}
+			w.Header().Set("etag", part)
+		case r.Method == "PUT":
+			json.NewDecoder(r.Body).Decode(&completed)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+	client.RetryDelay = time.Millisecond
+	if err := client.SetInternalSizes(DefaultChunkSize, 5*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetUploadConcurrency(4); err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("a"), 6*5*1024*1024)
+
+	reset := func() {
+		inFlight, maxInFlight, started = 0, 0, nil
+		completed = tcqueue.CompleteArtifactRequest{}
+	}
+
+	t.Run("ramps up and retries parts after slowing down", func(t *testing.T) {
+		reset()
+		if err := client.SetInitialUploadConcurrency(1); err != nil {
+			t.Fatal(err)
+		}
+		defer client.SetInitialUploadConcurrency(0)
+		slowDowns["part3"] = 2
+
+		// The bytes of the retried part are only counted once
+		var maxProgress int64
+		client.OnUploadProgress = func(transfered, total int64) {
+			lock.Lock()
+			defer lock.Unlock()
+			if transfered > maxProgress {
+				maxProgress = transfered
+			}
+		}
+		defer func() { client.OnUploadProgress = nil }()
+
+		if err := client.UploadBytes(fakeTaskID, "0", "public/adaptive", data, false, true); err != nil {
+			t.Fatal(err)
+		}
+
+		if maxProgress != int64(len(data)) {
+			t.Errorf("expected progress to reach %d without passing it, got %d", len(data), maxProgress)
+		}
+
+		if started[0] != 1 || started[1] != 1 {
+			t.Errorf("expected the first parts to be uploaded one at a time, got %v", started)
+		}
+		if maxInFlight > 4 {
+			t.Errorf("expected at most 4 parts in flight, got %d", maxInFlight)
+		}
+		if len(started) != 8 {
+			t.Errorf("expected 6 parts and 2 retries, got %d requests", len(started))
+		}
+		expected := []string{"part1", "part2", "part3", "part4", "part5", "part6"}
+		if strings.Join(completed.Etags, ",") != strings.Join(expected, ",") {
+			t.Errorf("expected etags %q, got %q", expected, completed.Etags)
+		}
+	})
+
+	t.Run("not retried without an initial concurrency", func(t *testing.T) {
+		reset()
+		slowDowns["part3"] = 1
+		if err := client.UploadBytes(fakeTaskID, "0", "public/adaptive", data, false, true); err == nil {
+			t.Fatal("expected the upload to fail")
+		}
+	})
+
+	if err := client.SetInitialUploadConcurrency(-1); err == nil {
+		t.Error("expected a negative initial concurrency to be invalid")
+	}
+}
@@ -0,0 +1,56 @@
+package artifact
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// defaultContentTypes are the content types of the file extensions of common
+// CI artifacts.  Most of these are text, which http.DetectContentType can't
+// tell apart, and logs would otherwise not be displayed inline by browsers
+var defaultContentTypes = map[string]string{
+	".log":  "text/plain; charset=utf-8",
+	".txt":  "text/plain; charset=utf-8",
+	".json": "application/json",
+	".xml":  "application/xml",
+	".html": "text/html; charset=utf-8",
+}
+
+// SetContentTypeByExtension sets the content types used for uploads of files
+// with the given extensions, like ".log", in addition to the defaults for
+// common CI artifacts.  Extensions are matched without regard to case.  An
+// empty content type removes an extension, so that the content type of its
+// files is determined from their content instead.  The content type of files
+// without a known extension is determined from their content, and a Client's
+// ContentType takes precedence over both
+func (c *Client) SetContentTypeByExtension(types map[string]string) {
+	// The map is replaced rather than changed, since copies of the Client made
+	// for operations which are in progress share it
+	merged := make(map[string]string, len(c.contentTypes)+len(types))
+	for ext, contentType := range c.contentTypes {
+		merged[ext] = contentType
+	}
+	for ext, contentType := range types {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if contentType == "" {
+			delete(merged, ext)
+		} else {
+			merged[ext] = contentType
+		}
+	}
+	c.contentTypes = merged
+}
+
+// Determine the content type of an input from the extension of its name.  An
+// empty string is returned for inputs without a name, like in memory readers,
+// and for unknown extensions
+func (c *Client) contentTypeByExtension(input interface{}) string {
+	n, ok := input.(namer)
+	if !ok {
+		return ""
+	}
+	return c.contentTypes[strings.ToLower(filepath.Ext(n.Name()))]
+}
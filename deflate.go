@@ -0,0 +1,31 @@
+package artifact
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/zlib"
+	"io"
+)
+
+// The deflate content-encoding is meant to be deflate data in a zlib wrapper,
+// but some servers send raw deflate data instead.  We look at the first two
+// bytes to decide which we have.  A zlib header uses compression method 8
+// (deflate) and, read as a big endian number, is a multiple of 31.  Raw
+// deflate data practically never looks like that, so anything else is
+// decoded as raw deflate
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		// Errors are returned as they are so that they can be recognized as
+		// decoding errors
+		zr, err := zlib.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr, nil
+	}
+
+	return flate.NewReader(br), nil
+}
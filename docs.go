@@ -34,7 +34,12 @@
 // was chosen.  This library does not do any management of the input and output
 // objects.  They must be created outside of this library and any cleanup must
 // occur in calling code.  The most common output option is likely an
-// ioutil.TempFile() instance.
+// ioutil.TempFile() instance.  Small artifacts which are already in memory can
+// be uploaded with UploadBytes(), which needs neither an input nor an output.
+// When the size and hash of an artifact are already known, UploadStream()
+// streams the input directly to the upload without needing an output.  Upload()
+// also uploads single part uploads without gzip encoding directly from the
+// input, so the output may be nil for them.
 //
 // The output must be empty.  For methods which require io.Seeker implementing
 // interfaces (e.g. io.ReadWriteSeeker), a check that the output is actually
@@ -51,7 +56,9 @@
 // content encoding of 'gzip'.  In both uploading and downloading, the gzip
 // encoding and decoding is done independently of any gzip encoding by the
 // calling code.  This could result in double gzip encoding if a gzip file is
-// passed into Upload() with the gzip argument set to true.
+// passed into Upload() with the gzip argument set to true, so Upload() returns
+// ErrDoubleGzip for inputs which start with the gzip magic number unless the
+// Client's AllowDoubleGzip option is set.
 //
 // Command line application
 //
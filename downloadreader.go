@@ -0,0 +1,74 @@
+package artifact
+
+import (
+	"io"
+)
+
+// DownloadReader returns a reader of the named artifact from a specific run of
+// a task, for streaming it somewhere without staging it in a file first.  The
+// artifact is downloaded as it's read, with its content-encoding decoded, and
+// is checked like it is by Download.  Because the artifact can only be checked
+// once all of it has been read, the final read returns ErrCorrupt instead of
+// io.EOF when it doesn't match its x-amz-meta-* headers, as does Close.
+// Other errors, like ErrErr for an error artifact whose details are read
+// instead of content, are returned the same way.  Callers must read until an
+// error before trusting what they've read, and must always call Close
+func (c *Client) DownloadReader(taskID, runID, name string) (io.ReadCloser, error) {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	r := &downloadReader{
+		PipeReader: pr,
+		output:     &pipeOutput{pw: pw},
+		done:       make(chan struct{}),
+	}
+
+	go func() {
+		defer close(r.done)
+		_, r.err = c.DownloadWithResult(taskID, runID, name, r.output)
+		// A nil error closes the pipe with io.EOF
+		_ = pw.CloseWithError(r.err)
+	}()
+
+	return r, nil
+}
+
+// A downloadReader is the reading end of a pipe which a download is written
+// into.  The download's error is only known once it has finished, so Close
+// waits for that
+type downloadReader struct {
+	*io.PipeReader
+	output *pipeOutput
+	done   chan struct{}
+	err    error
+}
+
+// Close stops the download if it's still running and returns its error.  A
+// download which fails because it was stopped this way isn't an error, since
+// the caller chose not to read the rest of the artifact
+func (r *downloadReader) Close() error {
+	_ = r.PipeReader.Close()
+	<-r.done
+
+	if r.output.closed {
+		return nil
+	}
+	return r.err
+}
+
+// A pipeOutput records whether a download was stopped by the reading end of
+// its pipe being closed
+type pipeOutput struct {
+	pw     *io.PipeWriter
+	closed bool
+}
+
+func (p *pipeOutput) Write(b []byte) (int, error) {
+	n, err := p.pw.Write(b)
+	if err == io.ErrClosedPipe {
+		p.closed = true
+	}
+	return n, err
+}
@@ -0,0 +1,96 @@
+package artifact
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDownloadReader(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	data := []byte("streamed without a file")
+	large := bytes.Repeat([]byte("large"), 1024*1024)
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/task/"):
+			name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/s3/"+name)
+			w.WriteHeader(303)
+		case r.URL.Path == "/s3/good":
+			w.Header().Set("x-amz-meta-content-length", sl(data))
+			w.Header().Set("x-amz-meta-content-sha256", hb(data))
+			w.Write(data)
+		case r.URL.Path == "/s3/corrupt":
+			w.Header().Set("x-amz-meta-content-length", sl(data))
+			w.Header().Set("x-amz-meta-content-sha256", hb([]byte("something else")))
+			w.Write(data)
+		case r.URL.Path == "/s3/large":
+			w.Header().Set("x-amz-meta-content-length", sl(large))
+			w.Header().Set("x-amz-meta-content-sha256", hb(large))
+			w.Write(large)
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	t.Run("valid artifact", func(t *testing.T) {
+		r, err := client.DownloadReader(fakeTaskID, "0", "good")
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, data) {
+			t.Fatalf("expected %q, got %q", data, actual)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("corrupt artifact", func(t *testing.T) {
+		r, err := client.DownloadReader(fakeTaskID, "0", "corrupt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(r); err != ErrCorrupt {
+			t.Fatalf("expected ErrCorrupt from reading, got %v", err)
+		}
+		if err := r.Close(); err != ErrCorrupt {
+			t.Fatalf("expected ErrCorrupt from closing, got %v", err)
+		}
+	})
+
+	t.Run("closed before the end", func(t *testing.T) {
+		r, err := client.DownloadReader(fakeTaskID, "0", "large")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1024)
+		if _, err := r.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatalf("expected no error closing early, got %v", err)
+		}
+	})
+
+	t.Run("invalid artifact", func(t *testing.T) {
+		if _, err := client.DownloadReader(fakeTaskID, "0", ""); err == nil {
+			t.Fatal("expected an error for an empty name")
+		}
+	})
+}
@@ -1,5 +1,9 @@
 package artifact
 
+import (
+	"fmt"
+)
+
 // ErrHTTPS is returned when a non-https url is involved in a redirect
 var ErrHTTPS = newError(nil, "only resources served over https are allowed")
 
@@ -22,6 +26,67 @@ var ErrBadRedirect = newError(nil, "malformed redirect")
 // was able to be checked for its size and it contained more than 0 bytes
 var ErrBadOutputWriter = newError(nil, "output writer is not empty")
 
+// ErrDoubleGzip is returned when an upload with gzip encoding is requested for
+// an input which is already gzip encoded.  Downloads of such an artifact would
+// still be gzip encoded after being decoded.  Set AllowDoubleGzip on the
+// Client if this really is intended
+var ErrDoubleGzip = newError(nil, "input is already gzip encoded")
+
+// ErrUnauthorized is returned when the Queue or S3 refuses a request because
+// the credentials used are missing, invalid or lack the scopes needed
+var ErrUnauthorized = newError(nil, "not authorized")
+
+// ErrSignedURLExpired is returned when a signed URL is refused because it has
+// expired
+var ErrSignedURLExpired = newError(nil, "signed url has expired")
+
+// ErrClockSkew is returned when a request is refused because the local clock
+// is too far from the clock of the service it was sent to, which makes signed
+// URLs look expired or invalid.  Synchronizing the local clock, for example
+// with NTP, fixes this
+var ErrClockSkew = newError(nil, "local clock is skewed, synchronize it with NTP")
+
+// ErrFileChanged is returned when the input of an upload changes size while
+// it's being prepared, or its copy in the output changes before it's hashed,
+// so that what would be uploaded isn't what was hashed.  This commonly happens
+// to logs which are still being written.  The upload can be retried once the
+// input has stopped changing, or Client.SnapshotInputSize can be set to
+// upload inputs as they were when the upload started
+var ErrFileChanged = newError(nil, "file changed while preparing upload")
+
+// ErrTimeout is returned when an upload or download doesn't finish within the
+// Client's OperationTimeout
+var ErrTimeout = newError(nil, "operation timed out")
+
+// ErrCancelled is returned when an upload or download is stopped because the
+// Client's Context was cancelled
+var ErrCancelled = newError(nil, "operation cancelled")
+
+// ContentLengthError is returned when the body of a request has a different
+// number of bytes than its Content-Length header, for example when the headers
+// of an upload request don't describe the part being uploaded.  Since the body
+// was read to its end, sending it again would fail the same way, so unlike a
+// body which stops part way through, this isn't retryable
+type ContentLengthError struct {
+	Method        string
+	URL           string
+	ContentLength int64
+	BodyLength    int64
+}
+
+func (e *ContentLengthError) Error() string {
+	return fmt.Sprintf("%s request to %s has a body of %d bytes but a Content-Length of %d", e.Method, e.URL, e.BodyLength, e.ContentLength)
+}
+
+// ErrTooLarge is returned when the content of an upload is larger than the
+// Client's MaxArtifactSize
+var ErrTooLarge = newError(nil, "artifact is larger than the maximum artifact size")
+
+// ErrCircuitOpen is returned by uploads and downloads which are not started
+// because too many of the Client's recent requests failed.  See
+// Client.SetCircuitBreaker
+var ErrCircuitOpen = newError(nil, "too many recent requests failed, not starting")
+
 // ErrBadSize is returned when a part size or chunk size is invalid
 var ErrBadSize = newError(nil, "invalid part or chunk size")
 
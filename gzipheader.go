@@ -0,0 +1,47 @@
+package artifact
+
+import (
+	gziplib "compress/gzip"
+	"time"
+)
+
+// GzipHeader contains the fields of the header written at the start of gzip
+// encoded uploads.  Fixing every field means that the same input always
+// produces the same bytes, which is needed for reproducible uploads.  With the
+// default compression level used by this library, the header is laid out as
+// described in RFC 1952:
+//
+//	1f 8b     magic number
+//	08        compression method (deflate)
+//	FLG       0x08 when Name is set, plus 0x10 when Comment is set
+//	MTIME     4 bytes, little endian seconds since the Unix epoch of ModTime,
+//	          or 0 if ModTime is not after the epoch
+//	00        extra flags
+//	OS        the OS byte
+//	Name      zero terminated, only when set
+//	Comment   zero terminated, only when set
+//
+// Name and Comment must only contain characters in the Latin-1 character set
+type GzipHeader struct {
+	ModTime time.Time
+	OS      byte
+	Name    string
+	Comment string
+}
+
+// DefaultGzipHeader is the GzipHeader used by a new Client.  The OS byte is
+// 255, which means unknown, and the name and comment are left empty so that
+// only the ModTime and OS bytes are set
+var DefaultGzipHeader = GzipHeader{
+	ModTime: time.Date(2000, time.January, 0, 0, 0, 0, 0, time.UTC),
+	OS:      255,
+}
+
+// Set the header fields of a gzip.Writer.  This must be done before anything
+// is written to it
+func (h GzipHeader) apply(w *gziplib.Writer) {
+	w.ModTime = h.ModTime
+	w.OS = h.OS
+	w.Name = h.Name
+	w.Comment = h.Comment
+}
@@ -0,0 +1,73 @@
+package artifact
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+)
+
+// A HashAlgorithm is the hash function used to prepare and verify artifacts.
+// The Name is used to find the headers which contain the expected hashes of a
+// downloaded resource.  For example, the SHA256 algorithm is verified using the
+// X-Amz-Meta-Content-Sha256 and X-Amz-Meta-Transfer-Sha256 headers.
+//
+// The Queue only supports sha256 for blob artifacts.  The createArtifact call
+// has only contentSha256 and transferSha256 fields and the headers it sets on
+// the uploaded objects are the sha256 ones.  This means that uploads to the
+// Queue must use SHA256 and that other algorithms can only be used to verify
+// downloads from services which set the matching headers
+type HashAlgorithm struct {
+	Name string
+	New  func() hash.Hash
+}
+
+// SHA256 is the default HashAlgorithm and the only one the Queue supports
+var SHA256 = HashAlgorithm{Name: "sha256", New: sha256.New}
+
+// SHA512 is a HashAlgorithm which verifies using X-Amz-Meta-*-Sha512 headers
+var SHA512 = HashAlgorithm{Name: "sha512", New: sha512.New}
+
+// The CRC32C of each part is sent to S3 when Client.EnableCRC32C is set, so
+// that S3 can reject a corrupted part itself
+const crc32cHeader = "x-amz-checksum-crc32c"
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// The CRC32C is computed in the same pass as the other hashes.  It's cheap
+// enough that it's always computed, even when it isn't sent
+func newCRC32C() hash.Hash32 {
+	return crc32.New(crc32cTable)
+}
+
+// S3 expects the base64 encoding of the big-endian bytes of the checksum
+func encodeCRC32C(sum uint32) string {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, sum)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// The MD5 of each part is sent to S3 when Client.EnableContentMD5 is set, so
+// that S3 can reject a corrupted part itself
+const contentMD5Header = "Content-MD5"
+
+// Unlike the CRC32C, the MD5 costs about as much to compute as the sha256
+// hashes, so it's only computed when it will be sent.  Otherwise, a hash which
+// ignores what is written to it is used, whose sum is nil
+func newContentMD5(enabled bool) hash.Hash {
+	if enabled {
+		return md5.New()
+	}
+	return noHash{}
+}
+
+type noHash struct{}
+
+func (noHash) Write(p []byte) (int, error) { return len(p), nil }
+func (noHash) Sum(b []byte) []byte         { return b }
+func (noHash) Reset()                      {}
+func (noHash) Size() int                   { return 0 }
+func (noHash) BlockSize() int              { return 1 }
@@ -0,0 +1,61 @@
+package artifact
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// HTTP2Mode is whether uploads and downloads are made with HTTP/2
+type HTTP2Mode int
+
+const (
+	// HTTP2Auto leaves it to the net/http package to negotiate HTTP/2 with
+	// servers which support it, which depends on the version of Go
+	HTTP2Auto HTTP2Mode = iota
+	// HTTP2Enabled configures HTTP/2 with the golang.org/x/net/http2 package,
+	// so that it's used with every server which supports it
+	HTTP2Enabled
+	// HTTP2Disabled makes every request with HTTP/1.1
+	HTTP2Disabled
+)
+
+// SetHTTP2 sets whether uploads and downloads are made with HTTP/2.  HTTP/2
+// sends concurrent requests to the same host over a single connection, which
+// saves opening connections for many small artifacts.  Its flow control can
+// limit the throughput of large uploads and downloads, though, and the parts
+// of a multipart upload which are uploaded concurrently share the connection
+// instead of each having their own.  The default is HTTP2Auto.  Queue calls
+// are made by the tcqueue.Queue passed to New, so they aren't affected.  This
+// should be called before the Client is used, since connections which are
+// already open keep the protocol they were opened with
+func (c *Client) SetHTTP2(mode HTTP2Mode) error {
+	if mode < HTTP2Auto || mode > HTTP2Disabled {
+		return newErrorf(nil, "unknown HTTP/2 mode %d", mode)
+	}
+
+	for _, t := range c.transports() {
+		// Configuring HTTP/2 sets both of these, so they're cleared to undo a
+		// previous mode.  Nothing else sets them
+		t.TLSNextProto = nil
+		t.TLSClientConfig = nil
+		switch mode {
+		case HTTP2Enabled:
+			if err := http2.ConfigureTransport(t); err != nil {
+				return newErrorf(err, "configuring HTTP/2")
+			}
+		case HTTP2Disabled:
+			// A non-nil, empty map stops net/http from negotiating HTTP/2
+			t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+	}
+
+	c.http2 = mode
+	return nil
+}
+
+// GetHTTP2 returns whether uploads and downloads are made with HTTP/2
+func (c *Client) GetHTTP2() HTTP2Mode {
+	return c.http2
+}
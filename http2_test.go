@@ -0,0 +1,77 @@
+package artifact
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTP2(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	data := []byte("served over TLS")
+	var proto string
+
+	blobs := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto = r.Proto
+		w.Header().Set("x-amz-meta-content-length", sl(data))
+		w.Header().Set("x-amz-meta-content-sha256", hb(data))
+		w.Write(data)
+	}))
+	blobs.EnableHTTP2 = true
+	blobs.StartTLS()
+	defer blobs.Close()
+
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/task/") {
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", blobs.URL+"/blob")
+			w.WriteHeader(303)
+			return
+		}
+		w.WriteHeader(404)
+	})
+	defer ts.Close()
+
+	if err := New(q).SetHTTP2(HTTP2Mode(42)); err == nil {
+		t.Fatal("expected an unknown mode to be rejected")
+	}
+
+	for _, tc := range []struct {
+		mode  HTTP2Mode
+		proto string
+	}{
+		{HTTP2Enabled, "HTTP/2.0"},
+		{HTTP2Disabled, "HTTP/1.1"},
+	} {
+		client := New(q)
+		client.AllowInsecure = true
+		if err := client.SetHTTP2(tc.mode); err != nil {
+			t.Fatal(err)
+		}
+		if mode := client.GetHTTP2(); mode != tc.mode {
+			t.Fatalf("expected mode %d, got %d", tc.mode, mode)
+		}
+
+		// Trust the test server's certificate
+		roots := blobs.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+		for _, transport := range client.transports() {
+			if transport.TLSClientConfig == nil {
+				transport.TLSClientConfig = &tls.Config{}
+			}
+			transport.TLSClientConfig.RootCAs = roots
+		}
+
+		proto = ""
+		var output bytes.Buffer
+		if err := client.Download(fakeTaskID, "0", "public/tls", &output); err != nil {
+			t.Fatal(err)
+		}
+		if proto != tc.proto {
+			t.Errorf("mode %d: expected the download to use %s, got %s", tc.mode, tc.proto, proto)
+		}
+	}
+}
@@ -2,36 +2,242 @@ package artifact
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	tcclient "github.com/taskcluster/taskcluster-client-go"
 	"github.com/taskcluster/taskcluster-client-go/tcqueue"
 )
 
-// TODO implement an in memory 'file'
-// TODO implement 'redirect' and 'error' artifact types?
-
 // Client knows how to upload and download blob artifacts
 type Client struct {
-	agent                   client
-	queue                   *tcqueue.Queue
-	chunkSize               int
-	multipartPartChunkCount int
-	AllowInsecure           bool
+	agent                    client
+	queue                    *tcqueue.Queue
+	chunkSize                int
+	multipartPartChunkCount  int
+	uploadConcurrency        int
+	initialUploadConcurrency int
+	hashAlgorithm            HashAlgorithm
+	metrics                  Metrics
+	redirectPolicy           RedirectPolicy
+	connectionPool           *ConnectionPool
+	contentTypes             map[string]string
+	circuit                  *circuitBreaker
+	scratchFactory           func() (ReadWriteSeekCloser, error)
+	clock                    clock
+	http2                    HTTP2Mode
+	AllowInsecure            bool
+	// AllowDoubleGzip allows gzip encoded uploads of inputs which are already
+	// gzip encoded.  Otherwise, Upload returns ErrDoubleGzip for them
+	AllowDoubleGzip bool
+	// ContentType is the content type of artifacts uploaded with Upload.  When
+	// empty, the content type is determined from the extension of files, as
+	// set with SetContentTypeByExtension, or else from the start of the input
+	ContentType string
+	// Expires is when artifacts created by this Client expire.  When zero,
+	// artifacts expire one day after they are created
+	Expires time.Time
+	// UserAgent is sent as the User-Agent header of each request this library
+	// makes, unless the request already has one set
+	UserAgent string
+	// RequestTimeout is how long a single request can go without transfering
+	// any bytes before it is aborted.  This is not a limit on the total
+	// duration of a request, since large artifacts can take a long time to
+	// transfer.  Requests which are aborted this way are retryable.  The
+	// default of zero means that requests never time out
+	RequestTimeout time.Duration
+	// OperationTimeout is how long a whole upload or download, including
+	// preparing an upload and its Queue calls, may take before it's aborted
+	// with ErrTimeout.  Requests which are in progress are cancelled, but the
+	// Queue calls can't be, so an upload can time out after its artifact was
+	// created but before it was completed.  A download which times out leaves
+	// whatever was written in its output, as described by its DownloadResult.
+	// Files which UploadFile staged the upload in are removed as usual.  The
+	// default of zero means that operations never time out
+	OperationTimeout time.Duration
+	// Context, when set, stops uploads and downloads once it's done.  Like
+	// with OperationTimeout, requests which are in progress are aborted and
+	// the operation returns ErrCancelled, or ErrTimeout if the Context had a
+	// deadline which passed.  An upload which is cancelled while it's being
+	// prepared stops once it has been prepared, without creating its artifact
+	Context context.Context
+	// GzipHeader is the header written at the start of gzip encoded uploads.
+	// The default fixes every field, so the same input always results in the
+	// same upload
+	GzipHeader GzipHeader
+	// UploadCompleted is called, when set, after each blob artifact upload has
+	// been completed with the Queue.  It receives the etags of the uploaded
+	// parts, which were sent to the Queue to complete the upload, so that they
+	// can be checked against S3 independently of this library
+	UploadCompleted func(taskID, runID, name string, result UploadResult)
+	// UploadFailed is called, when set, when a blob artifact upload fails
+	// after its artifact was created.  Its result describes the parts which
+	// were attempted, showing how far the upload got, and err is why it
+	// failed
+	UploadFailed func(taskID, runID, name string, result UploadResult, err error)
+	// MaxRetries is the number of times that a createArtifact or
+	// completeArtifact Queue call which fails with a server error or without a
+	// response is retried.  RetryDelay is how long to wait before the first
+	// retry, and it doubles for each following retry, up to five minutes
+	MaxRetries int
+	RetryDelay time.Duration
+	// OnUploadProgress and OnDownloadProgress are called, when set, as the
+	// bodies of uploads and downloads are transfered.  They receive the number
+	// of bytes transfered so far and the total number of bytes, which is -1
+	// when it isn't known.  Bytes are counted as they are transfered, so these
+	// are the compressed sizes of gzip encoded artifacts.  The parts of
+	// multipart uploads can be uploaded concurrently, so OnUploadProgress can
+	// be called concurrently.  The bytes of a request which fails are taken
+	// back, so the progress of an upload can go down when a part is retried,
+	// but it never passes the total
+	OnUploadProgress   func(transfered, total int64)
+	OnDownloadProgress func(transfered, total int64)
+	// OnChunk is called, when set, with each chunk of a blob artifact as it is
+	// uploaded or downloaded, and the offset of the chunk in the artifact.
+	// These are the bytes as they are transfered, so they are gzip encoded for
+	// gzip encoded artifacts.  The chunk is the buffer the bytes were read
+	// into, which is only valid until OnChunk returns and must be copied to be
+	// kept.  Chunks are reported before they are verified, and the chunks of a
+	// part which is retried are reported again.  The parts of multipart
+	// uploads can be uploaded concurrently, so OnChunk can be called
+	// concurrently
+	OnChunk func(offset int64, data []byte)
+	// TempDir is the directory in which UploadFile creates the temporary
+	// files used to stage uploads, unless a factory for the staging storage
+	// was set with SetScratchFactory.  When empty, os.TempDir() is used
+	TempDir string
+	// TraceID is sent in the TraceIDHeader of every request to S3 and the
+	// Queue's artifact redirects, and is included in their call summaries and
+	// log lines.  When empty, the requests for the parts of an upload share a
+	// trace ID generated for that upload, and other requests each get a new
+	// one.  The Queue API calls made through the taskcluster client don't
+	// carry a trace ID
+	TraceID string
+	// AllowNonEmptyOutput skips the checks that the outputs passed to Upload
+	// and the Download methods are empty, which otherwise return
+	// ErrBadOutputWriter.  WARNING: when this is set, the caller guarantees
+	// that each output is positioned and sized correctly.  Upload writes the
+	// prepared upload starting at the output's current position but uploads
+	// from its start, and downloads write starting at the output's current
+	// position without truncating it.  An output which isn't positioned at its
+	// start, or which has stale data past what is written, results in corrupt
+	// uploads or downloaded files.  Leave this unset unless you're reusing a
+	// pre-allocated output which you've truncated yourself
+	AllowNonEmptyOutput bool
+	// EnableCRC32C sends the CRC32C of each uploaded part in the
+	// x-amz-checksum-crc32c header, so that S3 rejects parts which were
+	// corrupted on the way there.  This is in addition to the sha256 hashes
+	// which the Queue has S3 store.  S3 must accept the header on the requests
+	// which the Queue signs, so this is off by default
+	EnableCRC32C bool
+	// EnableContentMD5 computes the MD5 of each part while preparing uploads
+	// and sends it in the Content-MD5 header, so that S3 and stores which are
+	// compatible with it reject parts which were corrupted on the way there.
+	// Computing the MD5 takes about as long as computing the sha256 hashes.
+	// It isn't sent for uploads with UploadPrepared or UploadStream, whose
+	// parts aren't read before they're uploaded
+	EnableContentMD5 bool
+	// SnapshotInputSize makes uploads read only as many bytes of their input
+	// as it had when the upload started, ignoring anything appended to it
+	// afterwards.  This allows logs which are still being written to be
+	// uploaded as they were at that moment.  Otherwise, an input which changes
+	// size while it's being prepared results in ErrFileChanged
+	SnapshotInputSize bool
+	// UploadStateFile is where the state of a blob artifact upload is saved
+	// as it's uploaded, so that it can be finished with ResumeUpload if it's
+	// interrupted.  The file is removed once the artifact is completed.  Since
+	// there's a single file, a Client with this set should only run one
+	// upload at a time
+	UploadStateFile string
+	// MaxArtifactSize is the largest artifact, in bytes of content, which can
+	// be uploaded.  Larger inputs are rejected with ErrTooLarge before any of
+	// them is read, which protects against uploading a runaway log by
+	// accident.  The default of zero means that there is no limit beyond the
+	// limits S3 places on uploads
+	MaxArtifactSize int64
+	// DryRun makes uploads prepare their input and create their artifact with
+	// the Queue, which checks the credentials and the sizes and hashes of the
+	// upload, but not upload any parts or complete the artifact.  The artifact
+	// is left created but incomplete, so the Queue only accepts a later upload
+	// of the same content under its name
+	DryRun bool
+	// UploadPlanned is called, when set, after each blob artifact is created
+	// while DryRun is set.  Its result describes the parts of the upload and
+	// the requests which would have uploaded them, and has no etags
+	UploadPlanned func(taskID, runID, name string, result UploadResult)
+	// PinLatestRun makes downloads from the latest run of a task resolve
+	// which run that is with ResolveLatestRun, then download from that run.
+	// The run is reported in the RunID of the DownloadResult, and a run which
+	// is created during the download can't change which artifact is
+	// downloaded.  This costs an extra Queue call for each download
+	PinLatestRun            bool
+	minThroughput           int64
+	throughputWindow        time.Duration
 	clientForBlindRedirects *http.Client
 }
 
+// UploadResult describes a completed blob artifact upload
+type UploadResult struct {
+	// Etags contains the etag returned by S3 for each part of the upload, in
+	// order.  Single part uploads have a single etag
+	Etags []string
+	// Request is the createArtifact request sent to the Queue, which contains
+	// the sizes and hashes of the uploaded content
+	Request tcqueue.BlobArtifactRequest
+	// Response is the Queue's response to the createArtifact call, which
+	// contains the requests which were run to upload the parts
+	Response tcqueue.BlobArtifactResponse
+	// Parts describes each request to upload a part, including retries, in
+	// the order they finished.  Parts which weren't attempted, for example
+	// because an earlier part failed, aren't included
+	Parts []PartSummary
+}
+
+// PartSummary describes the request which uploaded a part of an artifact
+type PartSummary struct {
+	// Part is the index of the part, which is 0 for single part uploads
+	Part int
+	// Start and Size are the offset and the number of bytes of the part
+	Start int64
+	Size  int64
+	// Sent and Sha256 are the number of bytes sent and their hash, made with
+	// the Client's hash algorithm, which differ from the part's when the
+	// request failed part way through
+	Sent   int64
+	Sha256 string
+	// StatusCode is zero if no response was received
+	StatusCode int
+	TraceID    string
+	// Etag is empty unless the part was uploaded
+	Etag     string
+	Duration time.Duration
+	// Err is why the part failed to upload, or nil
+	Err error
+}
+
+// Version is the version of this library
+const Version = "0.0.1"
+
+// DefaultUserAgent is the User-Agent header value used by a new Client
+const DefaultUserAgent = "taskcluster-lib-artifact-go/" + Version
+
 // DefaultChunkSize is 128KB
 const DefaultChunkSize int = 128 * 1024
 
-// DefaultPartSize is 100MB
-const DefaultPartSize int = 100 * 1024 * 1024 / DefaultChunkSize
+// DefaultPartSize is 100MB.  It must be a multiple of DefaultChunkSize
+const DefaultPartSize int = 100 * 1024 * 1024
 
 // So in the ideal world, what we'd do is change this library's agent to
 // support content-sha256-secure redirect checking and have it happen for all
@@ -43,10 +249,24 @@ const DefaultPartSize int = 100 * 1024 * 1024 / DefaultChunkSize
 // for the timebeing is have a second http client for running these types of
 // requests
 
-// New creates a Client for use
-func New(queue *tcqueue.Queue) *Client {
+// New creates a Client for use, configured by any options given.  New panics
+// with the error that the matching setter would have returned if an option is
+// invalid, so options made from input, like sizes given as flags, should be
+// given to NewWithOptions instead
+func New(queue *tcqueue.Queue, opts ...Option) *Client {
+	c, err := NewWithOptions(queue, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// NewWithOptions creates a Client like New, but returns the error that the
+// matching setter would have returned if an option is invalid
+func NewWithOptions(queue *tcqueue.Queue, opts ...Option) (*Client, error) {
 	a := newAgent()
 	transport := &http.Transport{
+		Proxy:              http.ProxyFromEnvironment,
 		MaxIdleConns:       10,
 		IdleConnTimeout:    30 * time.Second,
 		DisableCompression: true,
@@ -54,13 +274,75 @@ func New(queue *tcqueue.Queue) *Client {
 	_client := &http.Client{
 		Transport: transport,
 	}
-	return &Client{
+	c := &Client{
 		agent:                   a,
 		queue:                   queue,
 		chunkSize:               DefaultChunkSize,
-		multipartPartChunkCount: DefaultPartSize,
+		multipartPartChunkCount: DefaultPartSize / DefaultChunkSize,
+		uploadConcurrency:       1,
+		hashAlgorithm:           SHA256,
+		metrics:                 noopMetrics{},
+		clock:                   systemClock{},
+		contentTypes:            defaultContentTypes,
+		UserAgent:               DefaultUserAgent,
+		GzipHeader:              DefaultGzipHeader,
+		MaxRetries:              DefaultMaxRetries,
+		RetryDelay:              DefaultRetryDelay,
 		clientForBlindRedirects: _client,
 	}
+	if err := c.applyOptions(opts); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run a request using the agent, passing along the per-request settings which
+// callers are able to change on the Client
+func (c *Client) run(request request, inputReader io.Reader, outputWriter io.Writer, verify bool) (callSummary, bool, error) {
+	return c.runAgent(c.agent, request, inputReader, outputWriter, verify)
+}
+
+// Run a request like run, but with a copy of the agent which has been set up
+// for a specific call, for example to write the response without reversing
+// its content-encoding or to report progress
+func (c *Client) runAgent(a client, request request, inputReader io.Reader, outputWriter io.Writer, verify bool) (callSummary, bool, error) {
+	a.userAgent = c.UserAgent
+	a.requestTimeout = c.RequestTimeout
+	a.minThroughput = c.minThroughput
+	a.throughputWindow = c.throughputWindow
+	a.hashAlgorithm = c.hashAlgorithm
+	a.clock = c.clock
+	a.metrics = c.metrics
+	if a.traceID == "" {
+		a.traceID = c.TraceID
+	}
+	if a.redirectPolicy == nil {
+		a.redirectPolicy = c.redirectPolicy
+	}
+	cs, retryable, err := a.run(request, inputReader, c.chunkSize, outputWriter, verify)
+	if err == nil || retryable {
+		c.circuit.record(err != nil)
+	}
+	return cs, retryable, err
+}
+
+// Determine when an artifact created now should expire
+func (c *Client) expires() time.Time {
+	if c.Expires.IsZero() {
+		return c.clock.Now().UTC().AddDate(0, 0, 1)
+	}
+	return c.Expires.UTC()
+}
+
+// Close releases the idle connections which the Client keeps open to be
+// reused by later requests.  The Client should not be used after it has been
+// closed
+func (c *Client) Close() {
+	// http.Client only has CloseIdleConnections since Go 1.12, so the
+	// transports are closed directly
+	for _, t := range c.transports() {
+		t.CloseIdleConnections()
+	}
 }
 
 // SetInternalSizes sets the chunkSize and partSize .  The chunk size is the
@@ -70,22 +352,37 @@ func New(queue *tcqueue.Queue) *Client {
 // sake of simplicity, the part size must be a multiple of the chunk size so
 // that we don't have to worry about each individual read or write being split
 // across more than one part.  Both are changed in a single call because the
-// partSize must always be a multiple of the chunkSize
+// partSize must always be a multiple of the chunkSize.  Buffers pooled for the
+// previous chunk size are dropped when it changes.  Invalid sizes return an
+// error which has ErrBadSize as its cause
 func (c *Client) SetInternalSizes(chunkSize, partSize int) error {
+	if err := checkInternalSizes(chunkSize, partSize); err != nil {
+		return err
+	}
+
+	if chunkSize != c.chunkSize {
+		dropBuffers(c.chunkSize)
+	}
+
+	c.chunkSize = chunkSize
+	c.multipartPartChunkCount = partSize / chunkSize
+	return nil
+}
+
+// Check that a chunk size and part size can be used to prepare uploads
+func checkInternalSizes(chunkSize, partSize int) error {
 	if partSize < 5*1024*1024 {
-		return newErrorf(nil, "part size %d is not minimum of 5MB", partSize)
+		return newErrorf(ErrBadSize, "part size %d is not minimum of 5MB", partSize)
 	}
 
 	if chunkSize < 1024 {
-		return newErrorf(nil, "chunk size %d is not minimum of 1KB", chunkSize)
+		return newErrorf(ErrBadSize, "chunk size %d is not minimum of 1KB", chunkSize)
 	}
 
 	if partSize%chunkSize != 0 {
-		return newErrorf(nil, "part size %d is not divisible by chunk size %d", partSize, chunkSize)
+		return newErrorf(ErrBadSize, "part size %d is not divisible by chunk size %d", partSize, chunkSize)
 	}
 
-	c.chunkSize = chunkSize
-	c.multipartPartChunkCount = partSize / chunkSize
 	return nil
 }
 
@@ -95,10 +392,200 @@ func (c *Client) GetInternalSizes() (int, int) {
 	return c.chunkSize, c.multipartPartChunkCount * c.chunkSize
 }
 
+// SetUploadConcurrency sets the number of parts of a multipart upload which
+// are uploaded at the same time.  Parts are only uploaded concurrently when
+// the output passed to Upload is an io.ReaderAt, as an *os.File is, since
+// otherwise each part would need to seek the shared output.  The default is
+// to upload one part at a time.  Unless a connection pool has been set with
+// SetConnectionPool, the number of idle connections kept for each host is
+// raised to match
+func (c *Client) SetUploadConcurrency(n int) error {
+	if n < 1 {
+		return newErrorf(nil, "upload concurrency %d is not minimum of 1", n)
+	}
+	c.uploadConcurrency = n
+	c.applyConnectionPool()
+	return nil
+}
+
+// GetUploadConcurrency returns the number of parts of a multipart upload which
+// are uploaded at the same time
+func (c *Client) GetUploadConcurrency() int {
+	return c.uploadConcurrency
+}
+
+// SetInitialUploadConcurrency makes multipart uploads start with n parts in
+// flight instead of the full upload concurrency.  Each part which is uploaded
+// lets one more part be in flight, up to the upload concurrency, and each
+// 503 Slow Down response from S3 halves the number.  Parts which S3 asked to
+// slow down for are retried up to MaxRetries times after a jittered delay,
+// starting at RetryDelay and doubling for each retry.  This ramps up to the
+// full concurrency without the bursts of requests which S3 throttles.  The
+// default of zero uploads parts at the full concurrency straight away and
+// doesn't retry them
+func (c *Client) SetInitialUploadConcurrency(n int) error {
+	if n < 0 {
+		return newErrorf(nil, "initial upload concurrency %d must not be negative", n)
+	}
+	c.initialUploadConcurrency = n
+	return nil
+}
+
+// GetInitialUploadConcurrency returns the number of parts which multipart
+// uploads start with in flight, or zero if they start at the full upload
+// concurrency
+func (c *Client) GetInitialUploadConcurrency() int {
+	return c.initialUploadConcurrency
+}
+
+// ConnectionPool configures the idle connections which a Client keeps open to
+// be reused by later requests.  The fields are those of http.Transport
+type ConnectionPool struct {
+	// MaxIdleConns is the most idle connections kept open to all hosts.  Zero
+	// means no limit
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the most idle connections kept open to each
+	// host.  Zero means http.DefaultMaxIdleConnsPerHost
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept open.  Zero means
+	// no limit
+	IdleConnTimeout time.Duration
+}
+
+// SetConnectionPool sets how many idle connections are kept open, and for how
+// long, by the transports used for uploads and downloads.  By default, at most
+// 10 idle connections are kept for 30 seconds, and the number kept for each
+// host is raised to the upload concurrency.  The parts of a multipart upload
+// all go to the same S3 host, so with fewer idle connections per host than
+// parts uploaded at the same time, connections are closed after each part and
+// new ones opened for the next.  A pool set with this method is used as is,
+// whatever the upload concurrency, so it should allow at least that many idle
+// connections per host.  Passing nil restores the default
+func (c *Client) SetConnectionPool(pool *ConnectionPool) error {
+	if pool != nil {
+		if pool.MaxIdleConns < 0 || pool.MaxIdleConnsPerHost < 0 {
+			return newErrorf(nil, "idle connection limits %d and %d must not be negative", pool.MaxIdleConns, pool.MaxIdleConnsPerHost)
+		}
+		if pool.IdleConnTimeout < 0 {
+			return newErrorf(nil, "idle connection timeout %s must not be negative", pool.IdleConnTimeout)
+		}
+		copied := *pool
+		pool = &copied
+	}
+	c.connectionPool = pool
+	c.applyConnectionPool()
+	return nil
+}
+
+// GetConnectionPool returns the connection pool settings used for uploads and
+// downloads, including the defaults
+func (c *Client) GetConnectionPool() ConnectionPool {
+	if c.connectionPool != nil {
+		return *c.connectionPool
+	}
+
+	pool := ConnectionPool{
+		MaxIdleConns:        10,
+		MaxIdleConnsPerHost: http.DefaultMaxIdleConnsPerHost,
+		IdleConnTimeout:     30 * time.Second,
+	}
+	if pool.MaxIdleConnsPerHost < c.uploadConcurrency {
+		pool.MaxIdleConnsPerHost = c.uploadConcurrency
+	}
+	if pool.MaxIdleConns < pool.MaxIdleConnsPerHost {
+		pool.MaxIdleConns = pool.MaxIdleConnsPerHost
+	}
+	return pool
+}
+
+// The transports used for uploads and downloads
+func (c *Client) transports() []*http.Transport {
+	transports := []*http.Transport{c.agent.transport}
+	if t, ok := c.clientForBlindRedirects.Transport.(*http.Transport); ok {
+		transports = append(transports, t)
+	}
+	return transports
+}
+
+// Apply the connection pool settings to the transports
+func (c *Client) applyConnectionPool() {
+	pool := c.GetConnectionPool()
+	for _, t := range c.transports() {
+		t.MaxIdleConns = pool.MaxIdleConns
+		t.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+		t.IdleConnTimeout = pool.IdleConnTimeout
+	}
+}
+
+// SetProxy sets the function which determines the proxy to use for each
+// request this library makes to download and upload artifacts.  By default,
+// http.ProxyFromEnvironment is used, which uses the HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY environment variables.  A nil function means no proxy is used.
+// Queue calls are made by the tcqueue.Queue passed to New, so they aren't
+// affected.  Inside a task, the Queue can be reached through the
+// taskcluster-proxy by using TASKCLUSTER_PROXY_URL as the Queue's root URL
+func (c *Client) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
+	c.agent.transport.Proxy = proxy
+	if t, ok := c.clientForBlindRedirects.Transport.(*http.Transport); ok {
+		t.Proxy = proxy
+	}
+}
+
+// SetMetrics sets the hook which receives measurements of uploads, downloads,
+// retries and corrupt responses.  Passing nil stops reporting measurements
+func (c *Client) SetMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+	c.metrics = m
+}
+
+// SetHashAlgorithm sets the hash algorithm used to prepare uploads and verify
+// downloads.  The default is SHA256.  See the HashAlgorithm documentation for
+// the limits on which algorithms the Queue supports
+func (c *Client) SetHashAlgorithm(h HashAlgorithm) error {
+	if h.Name == "" || h.New == nil {
+		return newError(nil, "hash algorithm must have a name and a constructor")
+	}
+	c.hashAlgorithm = h
+	return nil
+}
+
+// GetHashAlgorithm returns the hash algorithm used to prepare uploads and
+// verify downloads
+func (c *Client) GetHashAlgorithm() HashAlgorithm {
+	return c.hashAlgorithm
+}
+
+// SetMinThroughput sets the slowest rate, in bytes per second, at which a
+// request or response body may be transfered.  The rate is measured over each
+// window of the given duration, and requests which transfer fewer bytes than
+// the minimum in a window are aborted.  Requests aborted this way are
+// retryable.  This catches connections which stay open while trickling data,
+// which would otherwise be indistinguishable from a healthy slow transfer.  A
+// bytesPerSec value of 0 disables the check
+func (c *Client) SetMinThroughput(bytesPerSec int64, window time.Duration) error {
+	if bytesPerSec < 0 {
+		return newErrorf(nil, "minimum throughput %d must not be negative", bytesPerSec)
+	}
+
+	if bytesPerSec > 0 && window <= 0 {
+		return newErrorf(nil, "throughput window %s must be positive", window)
+	}
+
+	c.minThroughput = bytesPerSec
+	c.throughputWindow = window
+	return nil
+}
+
 // CreateError creates an Error artifact.
 func (c *Client) CreateError(taskID, runID, name, reason, message string) error {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return err
+	}
+
 	errorreq := &tcqueue.ErrorArtifactRequest{
-		Expires:     tcclient.Time(time.Now().UTC().AddDate(0, 0, 1)),
+		Expires:     tcclient.Time(c.expires()),
 		Message:     message,
 		Reason:      reason,
 		StorageType: "error",
@@ -113,7 +600,7 @@ func (c *Client) CreateError(taskID, runID, name, reason, message string) error
 
 	_, err = c.queue.CreateArtifact(taskID, runID, name, &pareq)
 	if err != nil {
-		return newErrorf(err, "making createArtifact queue call during error creation of %s/%s/%s", taskID, runID, name)
+		return queueCallError(err, "making createArtifact queue call during error creation of %s/%s/%s", taskID, runID, name)
 	}
 
 	return nil
@@ -121,12 +608,16 @@ func (c *Client) CreateError(taskID, runID, name, reason, message string) error
 
 // CreateReference creates a Reference artifact.
 func (c *Client) CreateReference(taskID, runID, name, url string) error {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return err
+	}
+
 	refreq := &tcqueue.RedirectArtifactRequest{
 		// What?!? Why does a 302 redirect have a content-type???
 		// Since this doesn't really make any sense, we're just going to
 		// make up one which is safe
 		ContentType: "application/octet-stream",
-		Expires:     tcclient.Time(time.Now().UTC().AddDate(0, 0, 1)),
+		Expires:     tcclient.Time(c.expires()),
 		StorageType: "reference",
 		URL:         url,
 	}
@@ -140,7 +631,7 @@ func (c *Client) CreateReference(taskID, runID, name, url string) error {
 
 	_, err = c.queue.CreateArtifact(taskID, runID, name, &pareq)
 	if err != nil {
-		return newErrorf(err, "making createArtifact queue call during reference creation of %s/%s/%s", taskID, runID, name)
+		return queueCallError(err, "making createArtifact queue call during reference creation of %s/%s/%s", taskID, runID, name)
 	}
 
 	return nil
@@ -152,20 +643,129 @@ func (c *Client) CreateReference(taskID, runID, name, url string) error {
 // to be able to Read, Write and Seek because we'll pass over the file one time
 // to copy it to the output, then seek back to the beginning and read it in
 // again for the upload.  When this artifact is downloaded with this library,
-// the resulting output will be written as a once encoded gzip file
+// the resulting output will be written as a once encoded gzip file.
+//
+// Single part uploads without gzip encoding are uploaded straight from the
+// input, since its bytes are the bytes which are uploaded.  So are multipart
+// uploads without gzip encoding when the input is an io.ReaderAt, as an
+// *os.File is, since each part can be hashed and uploaded from its own offset
+// of the input.  The output isn't used for these uploads, so it may be nil
 func (c *Client) Upload(taskID, runID, name string, input io.ReadSeeker, output io.ReadWriteSeeker, gzip, multipart bool) error {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return err
+	}
+
+	op, done, err := c.startOperation(taskID + "/" + runID + "/" + name)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	u, contentType, source, err := op.prepareUpload(input, output, gzip, multipart, taskID+"/"+runID+"/"+name)
+	if err != nil {
+		return err
+	}
+
+	concurrency, partBody := op.partBodies(source)
+
+	return op.operationError(op.uploadPrepared(taskID, runID, name, findName(input), u, contentType, concurrency, partBody))
+}
+
+// UploadMany uploads the same input as an artifact under each of the given
+// names, for example under both a versioned and a "latest" name.  It works
+// like Upload, except that the input is hashed and optionally gzip encoded
+// into the output only once.  Each artifact is still created, uploaded and
+// completed separately, since the Queue's upload requests are specific to an
+// artifact.  Names are uploaded in order and the first failure stops the
+// remaining uploads, so the artifacts before it will have been created
+func (c *Client) UploadMany(taskID, runID string, names []string, input io.ReadSeeker, output io.ReadWriteSeeker, gzip, multipart bool) error {
+	if len(names) == 0 {
+		return newErrorf(nil, "no artifact names given for upload of %s to %s/%s", findName(input), taskID, runID)
+	}
+	for _, name := range names {
+		if err := validateArtifact(taskID, runID, name); err != nil {
+			return err
+		}
+	}
+
+	op, done, err := c.startOperation(taskID + "/" + runID + "/" + strings.Join(names, ","))
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	u, contentType, source, err := op.prepareUpload(input, output, gzip, multipart, taskID+"/"+runID+"/"+strings.Join(names, ","))
+	if err != nil {
+		return err
+	}
+
+	concurrency, partBody := op.partBodies(source)
+
+	for _, name := range names {
+		if err := op.uploadPrepared(taskID, runID, name, findName(input), u, contentType, concurrency, partBody); err != nil {
+			return op.operationError(err)
+		}
+	}
+
+	return nil
+}
+
+// Prepare the upload of input to dest, which describes where it's uploaded to
+// for error messages.  The content type is determined and the upload is
+// hashed and, unless it's uploaded straight from the input, written to the
+// output.  The io.ReadSeeker returned is the one which the parts of the
+// upload are read from
+func (c *Client) prepareUpload(input io.ReadSeeker, output io.ReadWriteSeeker, gzip, multipart bool, dest string) (upload, string, io.ReadSeeker, error) {
+	// The Queue only accepts sha256 hashes for blob artifacts, so there's no
+	// point in preparing the upload with any other algorithm
+	if c.hashAlgorithm.Name != SHA256.Name {
+		return upload{}, "", nil, newErrorf(nil, "the Queue does not support %s hashes, cannot upload %s", c.hashAlgorithm.Name, dest)
+	}
+
+	// Uploads without gzip encoding are uploaded straight from the input when
+	// that's possible, since the bytes staged in the output would be the same.
+	// Parts are read from their own offsets of the input, so a multipart
+	// upload needs an io.ReaderAt.  A snapshot of the input isn't one
+	_, inputAt := input.(io.ReaderAt)
+	direct := !gzip && (!multipart || inputAt && !c.SnapshotInputSize)
 
 	// Let's check if the output has data already.  The idea here is that if we
 	// seek to the end of the io.ReadWriteSeeker and the new position is not 0,
 	// we know that there's data.  It's safe to not seek back to 0 from the
 	// io.SeekStart because we just asserted that there's 0 bytes in the
 	// io.ReadWriteSeeker, so we know that it's position is 0
-	outSize, err := output.Seek(0, io.SeekEnd)
+	if output == nil {
+		if !direct {
+			return upload{}, "", nil, newErrorf(nil, "an output is needed for gzip or multipart upload of %s to %s", findName(input), dest)
+		}
+	} else if !c.AllowNonEmptyOutput {
+		outSize, err := output.Seek(0, io.SeekEnd)
+		if err != nil {
+			return upload{}, "", nil, newErrorf(err, "seeking output %s to start for upload", findName(input))
+		}
+		if outSize != 0 {
+			return upload{}, "", nil, ErrBadOutputWriter
+		}
+	}
+
+	// Inputs which are too large for S3 are rejected before they're prepared.
+	// Gzip encoding can change the size, so the prepared upload is checked
+	// again before it's sent
+	inSize, err := input.Seek(0, io.SeekEnd)
 	if err != nil {
-		return newErrorf(err, "seeking output %s to start for upload", findName(input))
+		return upload{}, "", nil, newErrorf(err, "seeking input %s to end to determine its size", findName(input))
+	}
+	if _, err = input.Seek(0, io.SeekStart); err != nil {
+		return upload{}, "", nil, newErrorf(err, "seeking input %s back to start after determining its size", findName(input))
+	}
+	if err = checkUploadSize(inSize, multipart, int64(c.chunkSize*c.multipartPartChunkCount)); err != nil {
+		return upload{}, "", nil, newErrorf(err, "cannot upload %s to %s", findName(input), dest)
+	}
+	if err = c.checkMaxArtifactSize(inSize, "input "+findName(input)+" for "+dest); err != nil {
+		return upload{}, "", nil, err
 	}
-	if outSize != 0 {
-		return ErrBadOutputWriter
+	if c.SnapshotInputSize {
+		input = newSnapshotReader(input, inSize)
 	}
 
 	// TODO: Decide if we should do this or let the caller figure out the content
@@ -173,31 +773,276 @@ func (c *Client) Upload(taskID, runID, name string, input io.ReadSeeker, output
 	// I'm really tempted to leave it in and not add another parameter
 	//
 	// Let's determine the content type of the file.  The mimetype sniffer only looks at
-	// the first 512 bytes, so let's read those and then seek the input back to 0
-	mimeBuf := make([]byte, 512)
-	_, err = input.Read(mimeBuf)
-	// We check for graceful EOF to handle the case of a file which has no contents
-	if err != nil && err != io.EOF {
-		return newErrorf(err, "reading 512 bytes from %s to determine mime type", findName(input))
+	// the first 512 bytes, so let's read those and then seek the input back to 0.
+	// The content type describes the content rather than the bytes which are
+	// transfered, so this has to happen before a gzip encoded upload is encoded
+	mimeBuf, err := readMimeBuf(input)
+	if err != nil {
+		return upload{}, "", nil, newErrorf(err, "reading 512 bytes from %s to determine mime type", findName(input))
 	}
-	_, err = output.Seek(0, io.SeekStart)
+	_, err = input.Seek(0, io.SeekStart)
 	if err != nil {
-		return newErrorf(err, "seeking %s back to start after determining mime type", findName(input))
+		return upload{}, "", nil, newErrorf(err, "seeking %s back to start after determining mime type", findName(input))
+	}
+	contentType := c.ContentType
+	if contentType == "" {
+		contentType = c.contentTypeByExtension(input)
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(mimeBuf)
+	}
+
+	// Gzip encoding an input which starts with the gzip magic number results
+	// in an artifact which is still gzip encoded once downloaded, which is
+	// rarely what was intended
+	if gzip && len(mimeBuf) >= 2 && mimeBuf[0] == 0x1f && mimeBuf[1] == 0x8b {
+		if !c.AllowDoubleGzip {
+			return upload{}, "", nil, ErrDoubleGzip
+		}
+		logger.Printf("WARNING: %s is already gzip encoded and will be gzip encoded a second time", findName(input))
 	}
-	contentType := http.DetectContentType(mimeBuf)
 
 	var u upload
 
+	// Only the hashes and size of the input are needed, since the input is
+	// uploaded as it is
+	if direct && multipart {
+		u, err = readerAtMultipartUpload(input, input.(io.ReaderAt), inSize, c.chunkSize, c.multipartPartChunkCount, c.hashAlgorithm.New, c.EnableContentMD5)
+		if err != nil {
+			return upload{}, "", nil, newErrorf(err, "preparing multipart upload of %s to %s", findName(input), dest)
+		}
+		if err = checkInputUnchanged(input, inSize, u.Size); err != nil {
+			return upload{}, "", nil, err
+		}
+		if u.TransferSize == 0 {
+			logger.Printf("%s is empty, using a single part upload", findName(input))
+			u.Parts = nil
+		}
+		return u, contentType, input, nil
+	}
+	if direct {
+		u, err = singlePartUpload(input, ioutil.Discard, false, c.chunkSize, c.hashAlgorithm.New, c.GzipHeader, c.EnableContentMD5)
+		if err != nil {
+			return upload{}, "", nil, newErrorf(err, "preparing single-part upload of %s to %s", findName(input), dest)
+		}
+		if err = checkInputUnchanged(input, inSize, u.Size); err != nil {
+			return upload{}, "", nil, err
+		}
+		return u, contentType, input, nil
+	}
+
 	if multipart {
-		u, err = multipartUpload(input, output, gzip, c.chunkSize, c.multipartPartChunkCount)
+		u, err = multipartUpload(input, output, gzip, c.chunkSize, c.multipartPartChunkCount, c.hashAlgorithm.New, c.GzipHeader, c.EnableContentMD5)
 		if err != nil {
-			return newErrorf(err, "preparing multipart upload of %s to %s/%s/%s", findName(input), taskID, runID, name)
+			return upload{}, "", nil, newErrorf(err, "preparing multipart upload of %s to %s", findName(input), dest)
 		}
 	} else {
-		u, err = singlePartUpload(input, output, gzip, c.chunkSize)
+		u, err = singlePartUpload(input, output, gzip, c.chunkSize, c.hashAlgorithm.New, c.GzipHeader, c.EnableContentMD5)
 		if err != nil {
-			return newErrorf(err, "preparing single-part upload of %s to %s/%s/%s", findName(input), taskID, runID, name)
+			return upload{}, "", nil, newErrorf(err, "preparing single-part upload of %s to %s", findName(input), dest)
+		}
+	}
+
+	if err = checkInputUnchanged(input, inSize, u.Size); err != nil {
+		return upload{}, "", nil, err
+	}
+
+	// An empty artifact has no parts, so it can only be uploaded as a single
+	// part.  The single part version of the upload is identical except for the
+	// part information
+	if multipart && u.TransferSize == 0 {
+		logger.Printf("%s is empty, using a single part upload", findName(input))
+		u.Parts = nil
+	}
+
+	return u, contentType, output, nil
+}
+
+// Check that an input whose size was taken before it was prepared for upload
+// still had that size when it was read
+func checkInputUnchanged(input io.Reader, before, after int64) error {
+	if before != after {
+		logger.Printf("%s was %d bytes before it was prepared for upload and %d bytes after", findName(input), before, after)
+		return ErrFileChanged
+	}
+	return nil
+}
+
+// Determine how many parts can be uploaded from output at the same time and
+// return a function which creates the body of each part.  When the output is
+// an io.ReaderAt, as an *os.File is, each part's body reads from its own
+// offset without seeking the output, so it's safe for parts to be uploaded
+// concurrently.  Bodies which seek the output share its position in the
+// stream, so they must be uploaded one at a time
+func (c *Client) partBodies(output io.ReadSeeker) (int, func(start, size int64) (io.Reader, error)) {
+	outputAt, isReaderAt := output.(io.ReaderAt)
+
+	concurrency := c.uploadConcurrency
+	if concurrency > 1 && !isReaderAt {
+		logger.Printf("output %s is not an io.ReaderAt, uploading parts one at a time", findName(output))
+		concurrency = 1
+	}
+
+	return concurrency, func(start, size int64) (io.Reader, error) {
+		if isReaderAt {
+			return newBodyAt(outputAt, start, size)
+		}
+		return newBody(output, start, size)
+	}
+}
+
+// PreparedUpload describes an artifact which has already been prepared for
+// upload, for example by a pipeline which hashed the artifact while producing
+// it.  The transfer fields describe the bytes which are uploaded, which are
+// the gzip encoded bytes when the ContentEncoding is "gzip".  When there are
+// Parts, the artifact is uploaded as a multipart upload.  Each part except the
+// last must be exactly the Client's part size, and the last part must be no
+// larger than it.  All hashes must be made with the Client's hash algorithm.
+// Prepare creates one the same way Upload prepares its input
+type PreparedUpload struct {
+	ContentSha256   []byte
+	ContentLength   int64
+	TransferSha256  []byte
+	TransferLength  int64
+	ContentEncoding string
+	Parts           []PreparedPart
+}
+
+// PreparedPart describes a single part of a PreparedUpload
+type PreparedPart struct {
+	Sha256 []byte
+	Size   int64
+}
+
+// Check that a PreparedUpload is consistent and convert it to the upload
+// struct which is used internally.  Part boundaries are checked against
+// partSize so that the parts line up with those which Upload would create
+func (p PreparedUpload) upload(partSize int64, hashSize int) (upload, error) {
+	u := upload{
+		Sha256:          p.ContentSha256,
+		Size:            p.ContentLength,
+		TransferSha256:  p.TransferSha256,
+		TransferSize:    p.TransferLength,
+		ContentEncoding: p.ContentEncoding,
+	}
+
+	switch p.ContentEncoding {
+	case "identity":
+		if p.ContentLength != p.TransferLength || !bytes.Equal(p.ContentSha256, p.TransferSha256) {
+			return upload{}, newError(nil, "identity encoded uploads must have the same content and transfer hashes and lengths")
+		}
+	case "gzip":
+	default:
+		return upload{}, newErrorf(nil, "unsupported content encoding %q", p.ContentEncoding)
+	}
+
+	if p.ContentLength < 0 || p.TransferLength < 0 {
+		return upload{}, newErrorf(nil, "content length %d and transfer length %d must not be negative", p.ContentLength, p.TransferLength)
+	}
+
+	if len(p.ContentSha256) != hashSize || len(p.TransferSha256) != hashSize {
+		return upload{}, newErrorf(nil, "content and transfer hashes must be %d bytes", hashSize)
+	}
+
+	if len(p.Parts) == 0 {
+		return u, nil
+	}
+
+	u.Parts = make([]part, len(p.Parts))
+
+	var start int64
+	for i, pp := range p.Parts {
+		if len(pp.Sha256) != hashSize {
+			return upload{}, newErrorf(nil, "hash of part %d must be %d bytes", i, hashSize)
 		}
+		if pp.Size <= 0 || pp.Size > partSize {
+			return upload{}, newErrorf(nil, "part %d has size %d, which is not between 1 and the part size of %d", i, pp.Size, partSize)
+		}
+		if i < len(p.Parts)-1 && pp.Size != partSize {
+			return upload{}, newErrorf(nil, "part %d has size %d, but only the last part can be smaller than the part size of %d", i, pp.Size, partSize)
+		}
+		u.Parts[i] = part{Sha256: pp.Sha256, Size: pp.Size, Start: start}
+		start += pp.Size
+	}
+
+	if start != p.TransferLength {
+		return upload{}, newErrorf(nil, "parts add up to %d bytes, not the transfer length of %d bytes", start, p.TransferLength)
+	}
+
+	return u, nil
+}
+
+// UploadPrepared uploads an artifact which has already been prepared for
+// upload.  This skips the pass over the input which Upload makes to copy and
+// hash it, so the output must already contain exactly the bytes described by
+// prepared, starting at its beginning.  The description is checked for
+// consistency, but the output is not read to verify it.  When contentType is
+// empty, application/octet-stream is used
+func (c *Client) UploadPrepared(taskID, runID, name string, prepared PreparedUpload, output io.ReadSeeker, contentType string) error {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return err
+	}
+
+	if c.hashAlgorithm.Name != SHA256.Name {
+		return newErrorf(nil, "the Queue does not support %s hashes, cannot upload %s/%s/%s", c.hashAlgorithm.Name, taskID, runID, name)
+	}
+
+	if output == nil {
+		return newErrorf(nil, "an output is needed to upload a prepared artifact to %s/%s/%s", taskID, runID, name)
+	}
+
+	u, err := prepared.upload(int64(c.chunkSize*c.multipartPartChunkCount), c.hashAlgorithm.New().Size())
+	if err != nil {
+		return newErrorf(err, "invalid prepared upload of %s to %s/%s/%s", findName(output), taskID, runID, name)
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	op, done, err := c.startOperation(taskID + "/" + runID + "/" + name)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	concurrency, partBody := op.partBodies(output)
+
+	return op.operationError(op.uploadPrepared(taskID, runID, name, findName(output), u, contentType, concurrency, partBody))
+}
+
+// Read the bytes which http.DetectContentType considers, which are the first
+// 512 bytes of the input.  A single Read is allowed to return fewer bytes than
+// requested even when there are more, so we read until the buffer is full or
+// the input ends.  Only the bytes which were read are returned, since trailing
+// zero bytes would make any input look like binary data
+func readMimeBuf(input io.Reader) ([]byte, error) {
+	mimeBuf := make([]byte, 512)
+	n, err := io.ReadFull(input, mimeBuf)
+	// We check for graceful EOF to handle the case of a file which has fewer
+	// than 512 bytes or no contents at all
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return mimeBuf[:n], nil
+}
+
+// Create a blob artifact for a prepared upload, upload each of the parts the
+// Queue asks for and then complete the artifact.  The partBody function
+// returns the request body for the bytes of the upload starting at start.  It
+// is not called for empty artifacts, which are uploaded without a request body
+func (c *Client) uploadPrepared(taskID, runID, name, inputName string, u upload, contentType string, concurrency int, partBody func(start, size int64) (io.Reader, error)) error {
+	// The Queue calls can't be aborted, so an operation which ran out of time
+	// or was cancelled while it was being prepared must not create the artifact
+	if err := c.agent.stopped(); err != nil {
+		return err
+	}
+
+	if err := checkUploadSize(u.TransferSize, u.Parts != nil, int64(c.chunkSize*c.multipartPartChunkCount)); err != nil {
+		return newErrorf(err, "cannot upload %s to %s/%s/%s", inputName, taskID, runID, name)
+	}
+	if err := c.checkMaxArtifactSize(u.Size, inputName+" for "+taskID+"/"+runID+"/"+name); err != nil {
+		return err
 	}
 
 	bareq := &tcqueue.BlobArtifactRequest{
@@ -207,11 +1052,11 @@ func (c *Client) Upload(taskID, runID, name string, input io.ReadSeeker, output
 		TransferLength:  u.TransferSize,
 		TransferSha256:  hex.EncodeToString(u.TransferSha256),
 		ContentType:     contentType,
-		Expires:         tcclient.Time(time.Now().UTC().AddDate(0, 0, 1)),
+		Expires:         tcclient.Time(c.expires()),
 		StorageType:     "blob",
 	}
 
-	if multipart {
+	if u.Parts != nil {
 		// We don't match the API's structure exactly, so let's do that
 		parts := make([]tcqueue.MultipartPart, len(u.Parts))
 		for i := 0; i < len(u.Parts); i++ {
@@ -221,104 +1066,529 @@ func (c *Client) Upload(taskID, runID, name string, input io.ReadSeeker, output
 		bareq.Parts = parts
 	}
 
+	bares, err := c.createBlobArtifact(taskID, runID, name, inputName, bareq)
+	if err != nil {
+		return err
+	}
+
+	if c.DryRun {
+		logger.Printf("Dry run of %s to %s/%s/%s, not uploading its %d bytes in %d requests", inputName, taskID, runID, name, u.TransferSize, len(bares.Requests))
+		if c.UploadPlanned != nil {
+			c.UploadPlanned(taskID, runID, name, UploadResult{Request: *bareq, Response: bares})
+		}
+		return nil
+	}
+
+	crcs := []uint32{u.TransferCRC32C}
+	md5s := [][]byte{u.TransferMD5}
+	if u.Parts != nil {
+		crcs = make([]uint32, len(u.Parts))
+		md5s = make([][]byte, len(u.Parts))
+		for i, part := range u.Parts {
+			crcs[i] = part.CRC32C
+			md5s[i] = part.MD5
+		}
+	}
+
+	state := &uploadStateFile{
+		path: c.UploadStateFile,
+		state: UploadState{
+			TaskID:   taskID,
+			RunID:    runID,
+			Name:     name,
+			Request:  *bareq,
+			Response: bares,
+			Etags:    make([]string, len(bares.Requests)),
+			CRC32C:   crcs,
+			MD5:      md5s,
+		},
+	}
+	if err := state.save(); err != nil {
+		return err
+	}
+
+	if err := c.uploadParts(inputName, u, state, concurrency, partBody); err != nil {
+		return c.uploadFailed(state, err)
+	}
+
+	if err := c.completeUpload(inputName, state); err != nil {
+		return c.uploadFailed(state, err)
+	}
+
+	return nil
+}
+
+// Make the createArtifact call for a blob artifact, returning the requests
+// which upload its parts
+func (c *Client) createBlobArtifact(taskID, runID, name, inputName string, bareq *tcqueue.BlobArtifactRequest) (tcqueue.BlobArtifactResponse, error) {
+	var bares tcqueue.BlobArtifactResponse
+
 	cap, err := json.Marshal(&bareq)
 	if err != nil {
-		return newErrorf(err, "serializing json request body for createArtifact queue call during upload of %s to %s/%s/%s", findName(input), taskID, runID, name)
+		return bares, newErrorf(err, "serializing json request body for createArtifact queue call during upload of %s to %s/%s/%s", inputName, taskID, runID, name)
 	}
 
 	pareq := tcqueue.PostArtifactRequest(json.RawMessage(cap))
 
-	resp, err := c.queue.CreateArtifact(taskID, runID, name, &pareq)
+	// The artifact request is the same for each attempt, so the Queue will
+	// treat a retry of a call which did succeed as the same call
+	var resp *tcqueue.PostArtifactResponse
+	err = c.retryQueueCall("createArtifact of "+taskID+"/"+runID+"/"+name, func() error {
+		var err error
+		resp, err = c.queue.CreateArtifact(taskID, runID, name, &pareq)
+		return err
+	})
 	if err != nil {
-		return newErrorf(err, "making createArtifact queue call during upload of %s to %s/%s/%s", findName(input), taskID, runID, name)
+		return bares, queueCallError(err, "making createArtifact queue call during upload of %s to %s/%s/%s", inputName, taskID, runID, name)
 	}
 
-	var bares tcqueue.BlobArtifactResponse
-
 	err = json.Unmarshal(*resp, &bares)
 	if err != nil {
-		return newErrorf(err, "parsing json response body for createArtifact queue call during upload of %s to %s/%s/%s", findName(input), taskID, runID, name)
-	}
-
-	etags := make([]string, len(bares.Requests))
-
-	// There's a bit of a difficulty that's going to happen when we start
-	// supporting concurrency here.  The underlying ReadSeeker is going to be
-	// changing the position in the stream for the other readers.  We're going to
-	// have to figure out something to prevent the file from being read from
-	// totally random places.  To support this concurrency without passing files
-	// (e.g.  using ReadSeekers) we could do something like the following:
-	//   1. Create a mutex for file reads
-	//   2. Each read to the file will lock the mutex
-	//   3. Each read to the file will seek to the correct position
-	//   4. Each read to the file will read the number of bytes needed
-	//   5. Each reader of the file will keep track of the next place it needs to
-	//      read from (e.g. where it seek'ed to + the number of bytes that it read)
-	//   6. Each read to the file will unlock the mutex
-	// Another option would be to pass in a factory method instead of raw
-	// ReadSeekers and have the factory return a ReadSeeker for each
-	// request body.  Maybe we really need a ReaderAtSeekCloser...
-	for i, r := range bares.Requests {
-		var req request
-		req, err = newRequestFromStringMap(r.URL, r.Method, r.Headers)
+		return bares, newErrorf(err, "parsing json response body for createArtifact queue call during upload of %s to %s/%s/%s", inputName, taskID, runID, name)
+	}
+
+	// Each request uploads the part with the same index, so a Queue which
+	// returned a different number of requests would have us upload the wrong
+	// bytes, or fail part way through the upload
+	requests := 1
+	if bareq.Parts != nil {
+		requests = len(bareq.Parts)
+	}
+	if len(bares.Requests) != requests {
+		return bares, newErrorf(nil, "createArtifact queue call during upload of %s to %s/%s/%s returned %d requests for %d parts", inputName, taskID, runID, name, len(bares.Requests), requests)
+	}
+
+	return bares, nil
+}
+
+// Upload each part of an artifact which doesn't have an etag in the upload's
+// state yet, recording the etag of each part as it's uploaded
+func (c *Client) uploadParts(inputName string, u upload, state *uploadStateFile, concurrency int, partBody func(start, size int64) (io.Reader, error)) error {
+	taskID, runID, name := state.state.TaskID, state.state.RunID, state.state.Name
+
+	// All parts report their progress to the same total and share a trace ID
+	a := c.agent
+	a.artifact = taskID + "/" + runID + "/" + name
+	a.traceID = c.TraceID
+	if a.traceID == "" {
+		a.traceID = newTraceID()
+	}
+	logger.Printf("Uploading %s to %s/%s/%s with trace ID %s", inputName, taskID, runID, name, a.traceID)
+	if c.OnUploadProgress != nil {
+		a.uploadProgress = newTransferProgress(u.TransferSize, c.OnUploadProgress)
+	}
+	a.onChunk = c.OnChunk
+
+	// The status code of the response is returned, so that parts which S3
+	// asked us to slow down for can be retried
+	uploadPart := func(i int, r tcqueue.HTTPRequest) (int, error) {
+		req, err := newRequestFromStringMap(r.URL, r.Method, r.Headers)
 		if err != nil {
-			return newErrorf(err, "creating request %s to %s for upload of %s to %s/%s/%s", r.Method, r.URL, findName(input), taskID, runID, name)
+			return 0, newErrorf(err, "creating request %s to %s for upload of %s to %s/%s/%s", r.Method, r.URL, inputName, taskID, runID, name)
 		}
 
-		var b *body
-
 		var start int64
 		var end int64
 
+		var crc uint32
+		var md5 []byte
+
 		if u.Parts == nil {
 			start = 0
 			end = u.TransferSize
+			crc = u.TransferCRC32C
+			md5 = u.TransferMD5
 		} else {
 			start = u.Parts[i].Start
 			end = u.Parts[i].Size
+			crc = u.Parts[i].CRC32C
+			md5 = u.Parts[i].MD5
 		}
 
-		b, err = newBody(output, start, end)
-		if err != nil {
-			return newErrorf(err, "creating body for bytes %d to %d for upload of %s to %s/%s/%s", start, end, findName(input), taskID, runID, name)
+		if c.EnableCRC32C {
+			req.Header.Set(crc32cHeader, encodeCRC32C(crc))
+		}
+		// The MD5 is only known when it was computed while preparing the upload
+		if c.EnableContentMD5 && md5 != nil {
+			req.Header.Set(contentMD5Header, base64.StdEncoding.EncodeToString(md5))
 		}
 
-		// In this case, we're going to store the output of the request in memory
+		// A body cannot be empty, so an empty artifact is uploaded without a
+		// request body.  The request will be sent with a Content-Length of 0
+		var reqBody io.Reader
+
+		if end > 0 {
+			reqBody, err = partBody(start, end)
+			if err != nil {
+				return 0, newErrorf(err, "creating body for bytes %d to %d for upload of %s to %s/%s/%s", start, end, inputName, taskID, runID, name)
+			}
+		}
+
+		// In this case, we're going to store the output of the request in memory
 		// because we're pretty sure in this method that it's going to be an S3
 		// error message and we'd like to print that
 		var outputBuf bytes.Buffer
 
-		var cs callSummary
-		cs, _, err = c.agent.run(req, b, c.chunkSize, &outputBuf, false)
+		// Each part reports its chunks from where it starts in the artifact
+		pa := a
+		pa.chunkOffset = start
+
+		began := time.Now()
+		cs, _, err := c.runAgent(pa, req, reqBody, &outputBuf, false)
+		summary := PartSummary{
+			Part:       i,
+			Start:      start,
+			Size:       end,
+			Sent:       cs.RequestLength,
+			Sha256:     cs.RequestSha256,
+			StatusCode: cs.StatusCode,
+			TraceID:    cs.TraceID,
+			Duration:   time.Since(began),
+		}
 		if err != nil {
-			logger.Printf("%s\n%v", cs, &outputBuf)
-			return newErrorf(err, "reading bytes %d to %d of %s for %s to %s to upload to %s/%s/%s", start, end, findName(input), r.Method, r.URL, taskID, runID, name)
+			a.logf("%s\n%v", cs, &outputBuf)
+			if _, ok := err.(*ContentLengthError); !ok && err != ErrUnauthorized && err != ErrSignedURLExpired && err != ErrClockSkew {
+				err = newErrorf(err, "reading bytes %d to %d of %s for %s to %s to upload to %s/%s/%s", start, end, inputName, r.Method, r.URL, taskID, runID, name)
+			}
+			summary.Err = err
+			state.addPart(summary)
+			return cs.StatusCode, err
 		}
 
-		outputBuf.Reset()
+		summary.Etag = cs.ResponseHeader.Get("etag")
+		state.addPart(summary)
+		return cs.StatusCode, state.setEtag(i, summary.Etag)
+	}
+
+	// With an initial upload concurrency, the number of parts in flight is
+	// adapted to how S3 responds, and parts which S3 asked us to slow down for
+	// are retried.  Otherwise, every worker uploads parts straight away.
+	// Single part uploads are never retried, since the body of a streamed
+	// upload can only be read once
+	var limiter *concurrencyLimiter
+	if c.initialUploadConcurrency > 0 && len(u.Parts) > 1 {
+		limiter = newConcurrencyLimiter(c.initialUploadConcurrency, concurrency)
+	}
+
+	uploadPartWithRetries := func(i int) error {
+		delay := c.RetryDelay
+		for attempt := 0; ; attempt++ {
+			if limiter == nil {
+				_, err := uploadPart(i, state.state.Response.Requests[i])
+				return err
+			}
+
+			limiter.acquire()
+			status, err := uploadPart(i, state.state.Response.Requests[i])
+			slowDown := status == http.StatusServiceUnavailable
+			limiter.release(slowDown)
+
+			if err == nil || !slowDown || attempt >= c.MaxRetries {
+				return err
+			}
+
+			c.metrics.IncRetry()
+			wait := jitter(delay)
+			a.logf("S3 asked to slow down, reduced upload concurrency to %d and retrying part %d in %s (retry %d of %d)", limiter.current(), i, wait, attempt+1, c.MaxRetries)
+			if err := c.sleep(wait); err != nil {
+				return err
+			}
+			delay = nextRetryDelay(delay)
+		}
+	}
 
-		etags[i] = cs.ResponseHeader.Get("etag")
+	// We only report the first error, since once one part has failed the upload
+	// as a whole has failed and the remaining parts aren't started
+	var partErr error
+	var partErrLock sync.Mutex
+	failed := func() bool {
+		partErrLock.Lock()
+		defer partErrLock.Unlock()
+		return partErr != nil
 	}
 
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if failed() {
+					continue
+				}
+				if err := uploadPartWithRetries(i); err != nil {
+					partErrLock.Lock()
+					if partErr == nil {
+						partErr = err
+					}
+					partErrLock.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i, etag := range state.state.Etags {
+		if etag == "" {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return partErr
+}
+
+// Complete an artifact once all of its parts have been uploaded.  Once it's
+// completed, the upload's state is no longer needed
+func (c *Client) completeUpload(inputName string, state *uploadStateFile) error {
+	taskID, runID, name := state.state.TaskID, state.state.RunID, state.state.Name
+	etags := state.state.Etags
+
 	careq := tcqueue.CompleteArtifactRequest{
 		Etags: etags,
 	}
 
-	err = c.queue.CompleteArtifact(taskID, runID, name, &careq)
+	// If a completeArtifact call succeeded but we didn't receive the response,
+	// the retry will be told that the artifact is already complete.  Since the
+	// parts have all been uploaded, that's a success
+	err := c.retryQueueCall("completeArtifact of "+taskID+"/"+runID+"/"+name, func() error {
+		err := c.queue.CompleteArtifact(taskID, runID, name, &careq)
+		if queueStatusCode(err) == http.StatusConflict {
+			logger.Printf("artifact %s/%s/%s was already completed", taskID, runID, name)
+			return nil
+		}
+		return err
+	})
 	if err != nil {
-		return newErrorf(err, "completing artifact upload of %s to %s/%s/%s", findName(input), taskID, runID, name)
+		return queueCallError(err, "completing artifact upload of %s to %s/%s/%s", inputName, taskID, runID, name)
 	}
 
 	logger.Printf("Etags: %#v", etags)
+
+	state.remove()
+
+	if c.UploadCompleted != nil {
+		c.UploadCompleted(taskID, runID, name, state.result())
+	}
+
 	return nil
 
 }
 
+// Report an upload which failed after its artifact was created, returning
+// its error.  The parts which were attempted are logged, so that it's clear
+// how far the upload got
+func (c *Client) uploadFailed(state *uploadStateFile, err error) error {
+	taskID, runID, name := state.state.TaskID, state.state.RunID, state.state.Name
+	result := state.result()
+
+	var uploaded int
+	for _, etag := range result.Etags {
+		if etag != "" {
+			uploaded++
+		}
+	}
+	logger.Printf("Upload to %s/%s/%s failed with %d of %d parts uploaded: %v", taskID, runID, name, uploaded, len(result.Etags), err)
+	for _, p := range result.Parts {
+		if p.Err != nil {
+			logger.Printf("Part %d (bytes %d to %d) failed after %s with %d bytes sent: %v", p.Part, p.Start, p.Start+p.Size, p.Duration, p.Sent, p.Err)
+		}
+	}
+
+	if c.UploadFailed != nil {
+		c.UploadFailed(taskID, runID, name, result, err)
+	}
+
+	return err
+}
+
+// UploadStream uploads an artifact by streaming input directly as the body of
+// a single part, identity encoded upload.  Unlike Upload, the input isn't
+// copied to an output first, so no temporary file is needed and the artifact
+// is read only once.  Because the Queue needs to know the size and hash of
+// the artifact before the upload starts, they must be provided by the caller.
+// Exactly size bytes are read from input while hashing them.  If the input is
+// shorter than size or the bytes don't match contentHash, the upload is
+// aborted before the artifact is completed.
+// The contentHash must be made with the Client's hash algorithm.  When
+// contentType is empty, application/octet-stream is used
+func (c *Client) UploadStream(taskID, runID, name string, input io.Reader, size int64, contentHash []byte, contentType string) error {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return err
+	}
+
+	if c.hashAlgorithm.Name != SHA256.Name {
+		return newErrorf(nil, "the Queue does not support %s hashes, cannot upload %s/%s/%s", c.hashAlgorithm.Name, taskID, runID, name)
+	}
+
+	if size < 0 {
+		return newErrorf(nil, "size %d of %s/%s/%s must not be negative", size, taskID, runID, name)
+	}
+
+	if len(contentHash) != c.hashAlgorithm.New().Size() {
+		return newErrorf(nil, "hash of %s/%s/%s is %d bytes, not %d bytes", taskID, runID, name, len(contentHash), c.hashAlgorithm.New().Size())
+	}
+
+	// Empty artifacts are uploaded without reading the input, so the hash is
+	// checked here instead
+	if size == 0 {
+		if emptyHash := c.hashAlgorithm.New().Sum(nil); !bytes.Equal(contentHash, emptyHash) {
+			return newErrorf(ErrCorrupt, "hash %x of empty %s/%s/%s should be %x", contentHash, taskID, runID, name, emptyHash)
+		}
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	u := upload{
+		Sha256:          contentHash,
+		Size:            size,
+		TransferSha256:  contentHash,
+		TransferSize:    size,
+		ContentEncoding: "identity",
+	}
+
+	partBody := func(start, size int64) (io.Reader, error) {
+		return newVerifyingReader(input, size, contentHash, c.hashAlgorithm.New()), nil
+	}
+
+	op, done, err := c.startOperation(taskID + "/" + runID + "/" + name)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	return op.operationError(op.uploadPrepared(taskID, runID, name, findName(input), u, contentType, 1, partBody))
+}
+
+// SetScratchFactory sets the function which UploadFile calls to create the
+// storage that uploads are staged in.  The storage it returns must be empty,
+// and is closed once the upload is finished, so storage which shouldn't
+// outlive the upload must be released by Close.  This allows small artifacts
+// to be staged in memory, or sensitive ones in encrypted storage.  Storage
+// which implements io.ReaderAt lets the parts of multipart uploads be uploaded
+// concurrently.  Passing nil restores the default, which is a temporary file
+// in the Client's TempDir that is removed when it's closed
+func (c *Client) SetScratchFactory(factory func() (ReadWriteSeekCloser, error)) {
+	c.scratchFactory = factory
+}
+
+// A temporary file used to stage an upload, which is removed when it's closed
+type tempScratch struct {
+	*os.File
+}
+
+func (f tempScratch) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// UploadFile uploads the file at filename as an artifact.  This works like
+// Upload, except that the input is opened from filename and the output is
+// scratch storage created by the factory set with SetScratchFactory, or else
+// a temporary file in the Client's TempDir, which is closed once the upload is
+// finished.  The scratch storage is created before anything else is done, so
+// an unwritable TempDir fails the upload before any work is wasted
+func (c *Client) UploadFile(taskID, runID, name, filename string, gzip, multipart bool) error {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return err
+	}
+
+	var output ReadWriteSeekCloser
+	if c.scratchFactory != nil {
+		scratch, err := c.scratchFactory()
+		if err != nil {
+			return newErrorf(err, "creating scratch storage to stage upload of %s to %s/%s/%s", filename, taskID, runID, name)
+		}
+		output = scratch
+	} else {
+		dir := c.TempDir
+		if dir == "" {
+			dir = os.TempDir()
+		}
+
+		f, err := ioutil.TempFile(dir, "tc-artifact")
+		if err != nil {
+			return newErrorf(err, "temporary directory %s is not writable, cannot stage upload of %s to %s/%s/%s", dir, filename, taskID, runID, name)
+		}
+		output = tempScratch{f}
+	}
+	defer output.Close()
+
+	input, err := os.Open(filename)
+	if err != nil {
+		return newErrorf(err, "opening %s for upload to %s/%s/%s", filename, taskID, runID, name)
+	}
+	defer input.Close()
+
+	return c.Upload(taskID, runID, name, input, output, gzip, multipart)
+}
+
+// MaxUploadBytesSize is the largest artifact which can be uploaded with
+// UploadBytes.  The artifact is prepared entirely in memory, which can need up
+// to twice as much memory as the artifact's size, so larger artifacts should
+// be uploaded from a file with Upload
+const MaxUploadBytesSize = 100 * 1024 * 1024
+
+// UploadBytes uploads an artifact whose contents are already in memory.  This
+// works like Upload, except that both the input and output are kept in memory
+// so that no files need to be created.  This is intended for small artifacts,
+// like a JSON manifest, and data larger than MaxUploadBytesSize is rejected
+func (c *Client) UploadBytes(taskID, runID, name string, data []byte, gzip, multipart bool) error {
+	if len(data) > MaxUploadBytesSize {
+		return newErrorf(nil, "%d bytes is larger than the in memory upload maximum of %d bytes for %s/%s/%s", len(data), MaxUploadBytesSize, taskID, runID, name)
+	}
+
+	return c.Upload(taskID, runID, name, bytes.NewReader(data), &bytesReadWriteSeeker{}, gzip, multipart)
+}
+
 type stater interface {
 	Stat() (os.FileInfo, error)
 }
 
-// TODO Support downloading non-blob artifacts
+// Check that a download's output is empty, as far as that can be determined.
+// If we can stat the output, we check that its size is 0 bytes.  If we can
+// seek the output, we seek 0 bytes from the end, which gives its size.  These
+// are extra safety checks, so we only fail when a call succeeds and shows that
+// the output isn't empty.  A call which fails means the size or position of
+// the output is unknown, and the download proceeds.  This is how outputs like
+// os.Stdout are handled, which implement io.Seeker but always fail to seek.
+// Each output of a multiOutput is checked
+func checkOutputEmpty(output io.Writer) error {
+	if m, ok := output.(*multiOutput); ok {
+		for _, o := range m.outputs {
+			if err := checkOutputEmpty(o); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if s, ok := output.(stater); ok {
+		fi, err := s.Stat()
+		if err != nil {
+			logger.Printf("could not stat output %s, not checking its size: %v", findName(output), err)
+		} else if fi.Size() != 0 {
+			return ErrBadOutputWriter
+		}
+	}
+
+	if s, ok := output.(io.Seeker); ok {
+		size, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			logger.Printf("could not seek output %s, its position is unknown: %v", findName(output), err)
+		} else if size != 0 {
+			return ErrBadOutputWriter
+		}
+	}
+
+	return nil
+}
 
 // DownloadURL downloads a URL to the specified output.  Because we generate
 // different URLs based on whether we're asking for latest or not DownloadURL
@@ -340,89 +1610,152 @@ type stater interface {
 // return a non-nil error, ErrErr.  Reference, s3 and azure storage types
 // blindly follow redirects and write the response to output.  Blob artifacts
 // handle redirections and validation appropriately.
-func (c *Client) DownloadURL(u string, output io.Writer) (err error) {
+func (c *Client) DownloadURL(u string, output io.Writer) error {
+	_, err := c.DownloadURLWithResult(u, output)
+	return err
+}
 
-	// If we can stat the output, let's see that the size is 0 bytes.  This is an
-	// extra safety check, so we're only going to fail if *can* stat the output
-	// and that response indicates an invalid value.
-	if s, ok := output.(stater); ok {
-		var fi os.FileInfo
-		fi, err = s.Stat()
-		// We don't care about errors calling Stat().  We'll just ignore the call
-		// and continue.  This is an extra check, not a mandatory one
-		if err == nil && fi.Size() != 0 {
-			return ErrBadOutputWriter
-		}
+// DownloadResult describes what a download wrote to its output.  When a
+// download fails, the output might contain part of the artifact or the body of
+// an error response, and this can be used to decide whether it's worth keeping
+type DownloadResult struct {
+	// BytesWritten is the number of bytes written to the output
+	BytesWritten int64
+	// ErrorBody is true when the output contains the body of an error
+	// response, or the message of an error artifact, instead of the
+	// artifact's content
+	ErrorBody bool
+	// RunID is the run of the task which the artifact was downloaded from.
+	// It's empty when the run isn't known, which it isn't for downloads of
+	// URLs, or of the latest run unless the Client has PinLatestRun set
+	RunID string
+	// ArtifactMeta describes the downloaded artifact, as far as it was
+	// determined before the download finished or failed
+	ArtifactMeta
+}
+
+// DownloadURLWithResult works like DownloadURL, but also describes what was
+// written to the output.  The result is returned whether or not the download
+// succeeded
+func (c *Client) DownloadURLWithResult(u string, output io.Writer) (DownloadResult, error) {
+	op, done, err := c.startOperation("")
+	if err != nil {
+		return DownloadResult{}, err
 	}
+	defer done()
 
-	// If we can seek the output, let's do that and ensure it's 0 bytes. If we
-	// encounter an error doing the Seek, we ignore this check.  We only fail if
-	// the .Seek() method succeeded but the response was invalid.  This is to be
-	// able to handle things like os.Stdout, which implement this interface but
-	// which will always return an error when called.  If we can seek the output,
-	// let's seek 0 bytes from the end and determine the new offset which is the
-	// file's size
-	if s, ok := output.(io.Seeker); ok {
-		var size int64
-		size, err = s.Seek(0, io.SeekEnd)
-		if err == nil && size != 0 {
-			return ErrBadOutputWriter
+	result, err := op.downloadURL(u, output, false)
+	return result, op.operationError(err)
+}
+
+// DownloadRawURL works like DownloadURLWithResult, except that the response
+// of a blob artifact is written to the output exactly as it is stored, even
+// when it has a content-encoding like gzip.  This is useful for copying an
+// artifact to another store without changing it.  The ContentEncoding of the
+// result says how the output is encoded.  Since the output isn't decoded, only
+// the stored bytes are verified, not the decoded content
+func (c *Client) DownloadRawURL(u string, output io.Writer) (DownloadResult, error) {
+	op, done, err := c.startOperation("")
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	defer done()
+
+	result, err := op.downloadURL(u, output, true)
+	return result, op.operationError(err)
+}
+
+// DownloadRaw downloads the named artifact from a specific run of a task
+// without reversing its content-encoding.  See DownloadRawURL
+func (c *Client) DownloadRaw(taskID, runID, name string, output io.Writer) (DownloadResult, error) {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return DownloadResult{}, err
+	}
+
+	result, err := c.downloadSigned(taskID+"/"+runID+"/"+name, func() (string, error) {
+		url, err := c.queue.GetArtifact_SignedURL(taskID, runID, name, time.Duration(3)*time.Hour)
+		if err != nil {
+			return "", newErrorf(err, "creating signed URL for %s/%s/%s", taskID, runID, name)
+		}
+		return url.String(), nil
+	}, output, true)
+	result.RunID = runID
+	return result, err
+}
+
+func (c *Client) downloadURL(u string, outputWriter io.Writer, raw bool) (result DownloadResult, err error) {
+
+	// The output isn't checked when the caller has taken responsibility for it
+	// with AllowNonEmptyOutput
+	if !c.AllowNonEmptyOutput {
+		if err = checkOutputEmpty(outputWriter); err != nil {
+			return result, err
 		}
 	}
 
-	r := newRequest(u, "GET", &http.Header{})
+	// We count what's written to the output so that callers can decide what to
+	// do with a partially written output when the download fails
+	output := &countingWriter{w: outputWriter}
+	defer func() {
+		result.BytesWritten = output.count
+	}()
 
 	var redirectBuf bytes.Buffer
 
 	var cs callSummary
-	cs, _, err = c.agent.run(r, nil, c.chunkSize, &redirectBuf, false)
-
 	var storageType string
-	if cs.ResponseHeader != nil {
-		storageType = cs.ResponseHeader.Get("x-taskcluster-artifact-storage-type")
-	}
-
-	if err != nil && storageType != "error" {
-		logger.Printf("%s\n%v", cs, &redirectBuf)
-		return newErrorf(err, "running redirect request for %s", u)
+	cs, storageType, err = c.runRedirect(u, &redirectBuf)
+	result.ArtifactMeta = newArtifactMeta(storageType, nil)
+	if err != nil {
+		return result, err
 	}
 
-	logger.Printf("Storage Type: %s", storageType)
-
 	// We have enough information at this point to determine if we have an error
 	// artifact type and how to handle it if so
 	if storageType == "error" {
+		result.ErrorBody = true
 		_, err = io.Copy(output, &redirectBuf)
 		if err != nil {
-			return newErrorf(err, "copying redirect buffer to output writer")
+			return result, newErrorf(err, "copying redirect buffer to output writer")
 		}
 		logger.Print("error artifact written")
-		return ErrErr
+		return result, ErrErr
 	}
 
 	location := cs.ResponseHeader.Get("Location")
 
 	if location == "" {
-		return ErrBadRedirect
+		return result, ErrBadRedirect
 	}
 
 	var resourceURL *url.URL
 	resourceURL, err = url.Parse(location)
 	if err != nil {
-		return newErrorf(err, "parsing Location header value %s for %s", location, u)
+		return result, newErrorf(err, "parsing Location header value %s for %s", location, u)
 	}
 
 	if !c.AllowInsecure && resourceURL.Scheme != "https" {
-		return ErrHTTPS
+		return result, ErrHTTPS
 	}
 
 	// For the reference, s3 and azure, there's nothing to check or verify.
 	if storageType == "reference" || storageType == "s3" || storageType == "azure" {
-		logger.Printf("following blind redirect of %s artifact", storageType)
+		c.agent.logf("following blind redirect of %s artifact", storageType)
+		var req *http.Request
+		req, err = http.NewRequest("GET", location, nil)
+		if err != nil {
+			return result, newErrorf(err, "creating request for %s", location)
+		}
+		if c.UserAgent != "" {
+			req.Header.Set("User-Agent", c.UserAgent)
+		}
+		if c.agent.operation != nil {
+			req = req.WithContext(c.agent.operation)
+		}
 		var resp *http.Response
-		resp, err = http.Get(location)
+		resp, err = c.clientForBlindRedirects.Do(req)
 		if err != nil {
-			return newErrorf(err, "fetching %s", location)
+			return result, newErrorf(err, "fetching %s", location)
 		}
 		// if we have an error closing the body, we should return the error, but only
 		// if no other error has already been set
@@ -432,36 +1765,173 @@ func (c *Client) DownloadURL(u string, output io.Writer) (err error) {
 				err = closeErr
 			}
 		}()
-		_, err = io.Copy(output, resp.Body)
+		result.ErrorBody = resp.StatusCode >= 400
+		result.ArtifactMeta = newArtifactMeta(storageType, resp.Header)
+		var body io.Reader = resp.Body
+		if c.OnDownloadProgress != nil {
+			body = progressReader{body, newTransferProgress(resp.ContentLength, c.OnDownloadProgress).add}
+		}
+		_, err = io.Copy(output, body)
 		if err != nil {
-			return newErrorf(err, "copying %s response body to output", location)
+			return result, newErrorf(err, "copying %s response body to output", location)
 		}
-		return nil
+		return result, nil
 	}
 
 	if cs.StatusCode < 300 || cs.StatusCode >= 400 {
-		return ErrExpectedRedirect
+		return result, ErrExpectedRedirect
 	}
 
 	// Make sure we release the memory stored in the redirect buffer
 	redirectBuf.Reset()
 
 	// Now let's make the required request
-	r = newRequest(location, "GET", &http.Header{})
+	r := newRequest(location, "GET", &http.Header{})
 
 	// Now we're going to request the artifact for real.  We're going to write directly
 	// to the outputWriter.  This does mean, unfortunately, that the outputWriter will
 	// contain the potatoes.
-	cs, _, err = c.agent.run(r, nil, c.chunkSize, output, true)
+	a := c.agent
+	a.raw = raw
+	if c.OnDownloadProgress != nil {
+		a.downloadProgress = newTransferProgress(-1, c.OnDownloadProgress)
+	}
+	a.onChunk = c.OnChunk
+
+	cs, _, err = c.runAgent(a, r, nil, output, true)
+	if cs.ResponseHeader != nil {
+		result.ArtifactMeta = newArtifactMeta(storageType, *cs.ResponseHeader)
+	}
+	if cs.ContentEncoding != "" {
+		result.ContentEncoding = cs.ContentEncoding
+	}
 	if err != nil {
-		return
+		// The body of a response with an error status is written to the output
+		// in place of the artifact
+		result.ErrorBody = cs.StatusCode >= 400
+		return result, err
 	}
 
 	if cs.StatusCode >= 300 {
-		return ErrUnexpectedRedirect
+		return result, ErrUnexpectedRedirect
 	}
 
-	return nil
+	return result, nil
+}
+
+// Run the request to the Queue which redirects to where an artifact is
+// stored, returning the storage type of the artifact.  The response body of
+// the redirect is written to redirectBuf.  For error artifacts, this is where
+// the details of the error are, so a failed request is not an error when the
+// artifact is an error artifact
+func (c *Client) runRedirect(u string, redirectBuf *bytes.Buffer) (callSummary, string, error) {
+	r := newRequest(u, "GET", &http.Header{})
+
+	// We need the Queue's redirect itself, so it's never followed, whatever the
+	// redirect policy
+	a := c.agent
+	a.redirectPolicy = checkRedirect
+
+	cs, _, err := c.runAgent(a, r, nil, redirectBuf, false)
+
+	var storageType string
+	if cs.ResponseHeader != nil {
+		storageType = cs.ResponseHeader.Get("x-taskcluster-artifact-storage-type")
+	}
+
+	if err != nil && storageType != "error" {
+		a.logf("%s\n%v", cs, redirectBuf)
+		if err == ErrUnauthorized || err == ErrSignedURLExpired || err == ErrClockSkew {
+			return cs, storageType, err
+		}
+		return cs, storageType, newErrorf(err, "running redirect request for %s", u)
+	}
+
+	a.logf("Storage Type: %s", storageType)
+
+	return cs, storageType, nil
+}
+
+// StorageType returns the storage type of the named artifact from a specific
+// run of a task.  This is the 'storageType' which was set when the artifact
+// was created, for example "blob", "reference" or "error".  Only the request
+// to the Queue is made, so the artifact itself is not downloaded.  This lets
+// callers decide how to handle an artifact before committing to a possibly
+// large download
+func (c *Client) StorageType(taskID, runID, name string) (string, error) {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return "", err
+	}
+
+	u, err := c.queue.GetArtifact_SignedURL(taskID, runID, name, time.Duration(3)*time.Hour)
+	if err != nil {
+		return "", newErrorf(err, "creating signed URL for %s/%s/%s", taskID, runID, name)
+	}
+
+	var redirectBuf bytes.Buffer
+
+	_, storageType, err := c.runRedirect(u.String(), &redirectBuf)
+	if err != nil {
+		return "", err
+	}
+
+	if storageType == "" {
+		return "", newErrorf(nil, "no storage type returned for %s/%s/%s", taskID, runID, name)
+	}
+
+	return storageType, nil
+}
+
+// ErrorArtifact contains the details of an error artifact.  These are the
+// reason and message passed to CreateError when the artifact was created
+type ErrorArtifact struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e *ErrorArtifact) Error() string {
+	return fmt.Sprintf("artifact is an error (%s): %s", e.Reason, e.Message)
+}
+
+// ResolveReference returns the URL which the named artifact from a specific
+// run of a task refers to, without fetching it.  This lets callers decide
+// whether and how to follow the reference themselves.  If the artifact is an
+// error artifact, the returned error is an *ErrorArtifact with the reason and
+// message of the error.  Artifacts of any other storage type result in an
+// error
+func (c *Client) ResolveReference(taskID, runID, name string) (string, error) {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return "", err
+	}
+
+	u, err := c.queue.GetArtifact_SignedURL(taskID, runID, name, time.Duration(3)*time.Hour)
+	if err != nil {
+		return "", newErrorf(err, "creating signed URL for %s/%s/%s", taskID, runID, name)
+	}
+
+	var redirectBuf bytes.Buffer
+
+	cs, storageType, err := c.runRedirect(u.String(), &redirectBuf)
+	if err != nil {
+		return "", err
+	}
+
+	switch storageType {
+	case "error":
+		var errArt ErrorArtifact
+		if err = json.Unmarshal(redirectBuf.Bytes(), &errArt); err != nil {
+			return "", newErrorf(err, "parsing error artifact details of %s/%s/%s", taskID, runID, name)
+		}
+		return "", &errArt
+	case "reference":
+		location := cs.ResponseHeader.Get("Location")
+		if location == "" {
+			return "", ErrBadRedirect
+		}
+		return location, nil
+	default:
+		return "", newErrorf(nil, "%s/%s/%s has storage type %s, not reference", taskID, runID, name, storageType)
+	}
 }
 
 // Download will download the named artifact from a specific run of a task.  If
@@ -471,8 +1941,33 @@ func (c *Client) DownloadURL(u string, output io.Writer) (err error) {
 // the callers responsibility to delete the contents of the output on failure
 // if needed.  If the output also implements the io.Seeker interface, a check
 // that the output is already empty will occur.  The most common output option
-// is likely an ioutil.TempFile() instance.
-func (c *Client) Download(taskID, runID, name string, output io.Writer) error {
+// is likely an ioutil.TempFile() instance.  If the download is refused because
+// its signed URL has expired, it is started again with a newly signed URL as
+// long as the error response can be discarded from the output, which is the
+// case for an *os.File.
+//
+// When more outputs are given, the artifact is downloaded and verified once
+// and written to all of them as it's downloaded, so that an artifact which is
+// needed in more than one place isn't downloaded again.  Each output is
+// checked like a single output is, and the download fails as soon as writing
+// to any of them fails
+func (c *Client) Download(taskID, runID, name string, output io.Writer, outputs ...io.Writer) error {
+	if len(outputs) > 0 {
+		output = newMultiOutput(append([]io.Writer{output}, outputs...))
+	}
+	_, err := c.DownloadWithResult(taskID, runID, name, output)
+	return err
+}
+
+// DownloadWithResult works like Download, but also describes what was written
+// to the output and the downloaded artifact, like its content type and length,
+// without another request.  The result is returned whether or not the download
+// succeeded
+func (c *Client) DownloadWithResult(taskID, runID, name string, output io.Writer) (DownloadResult, error) {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return DownloadResult{}, err
+	}
+
 	// We need to build the URL because we're going to need to get the redirect's
 	// headers.  That's not possible with the q.GetArtifact() method.  Ideally,
 	// we'd have a q.GetArtifact_BuildURL method which would allow us to do
@@ -480,13 +1975,231 @@ func (c *Client) Download(taskID, runID, name string, output io.Writer) error {
 	// with "public/"
 
 	// TODO: How long should this signed url really be valid for?
-	url, err := c.queue.GetArtifact_SignedURL(taskID, runID, name, time.Duration(3)*time.Hour)
+	result, err := c.downloadSigned(taskID+"/"+runID+"/"+name, func() (string, error) {
+		url, err := c.queue.GetArtifact_SignedURL(taskID, runID, name, time.Duration(3)*time.Hour)
+		if err != nil {
+			return "", newErrorf(err, "creating signed URL for %s/%s/%s", taskID, runID, name)
+		}
+		return url.String(), nil
+	}, output, false)
+	result.RunID = runID
+	return result, err
+}
+
+// Verify downloads the named artifact from a specific run of a task without
+// keeping it, to check that it exists and is intact.  The artifact's size and
+// hashes are checked as they are for Download, and true is returned only if
+// they are correct.  ErrCorrupt is returned for an artifact which fails these
+// checks and ErrErr for an error artifact.  Only blob artifacts can be
+// verified, since other storage types have no hashes to check
+func (c *Client) Verify(taskID, runID, name string) (bool, error) {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return false, err
+	}
+
+	u, err := c.queue.GetArtifact_SignedURL(taskID, runID, name, time.Duration(3)*time.Hour)
+	if err != nil {
+		return false, newErrorf(err, "creating signed URL for %s/%s/%s", taskID, runID, name)
+	}
+
+	return c.VerifyURL(u.String())
+}
+
+// VerifyLatest works like Verify, but checks the named artifact from the
+// latest run of a task
+func (c *Client) VerifyLatest(taskID, name string) (bool, error) {
+	if err := validateTaskID(taskID); err != nil {
+		return false, err
+	}
+	if err := validateName(name); err != nil {
+		return false, err
+	}
+
+	u, err := c.queue.GetLatestArtifact_SignedURL(taskID, name, time.Duration(3)*time.Hour)
+	if err != nil {
+		return false, newErrorf(err, "creating signed URL for %s/latest/%s", taskID, name)
+	}
+
+	return c.VerifyURL(u.String())
+}
+
+// VerifyURL works like Verify, but checks the artifact at a Queue URL, which
+// is likely a signed URL
+func (c *Client) VerifyURL(u string) (bool, error) {
+	op, done, err := c.startOperation("")
+	if err != nil {
+		return false, err
+	}
+	defer done()
+
+	ok, err := op.verifyURL(u)
+	return ok, op.operationError(err)
+}
+
+func (c *Client) verifyURL(u string) (bool, error) {
+	var redirectBuf bytes.Buffer
+
+	cs, storageType, err := c.runRedirect(u, &redirectBuf)
+	if err != nil {
+		return false, err
+	}
+
+	switch storageType {
+	case "blob":
+	case "error":
+		return false, ErrErr
+	default:
+		return false, newErrorf(nil, "cannot verify %s artifact %s, only blob artifacts have hashes", storageType, u)
+	}
+
+	if cs.StatusCode < 300 || cs.StatusCode >= 400 {
+		return false, ErrExpectedRedirect
+	}
+
+	location := cs.ResponseHeader.Get("Location")
+	if location == "" {
+		return false, ErrBadRedirect
+	}
+
+	resourceURL, err := url.Parse(location)
 	if err != nil {
-		return newErrorf(err, "creating signed URL for %s/%s/%s", taskID, runID, name)
+		return false, newErrorf(err, "parsing Location header value %s for %s", location, u)
 	}
 
-	return c.DownloadURL(url.String(), output)
+	if !c.AllowInsecure && resourceURL.Scheme != "https" {
+		return false, ErrHTTPS
+	}
 
+	// There's no output, so the response is only hashed and counted
+	cs, _, err = c.run(newRequest(location, "GET", &http.Header{}), nil, nil, true)
+	if err != nil {
+		return false, err
+	}
+
+	if cs.StatusCode >= 300 {
+		return false, ErrUnexpectedRedirect
+	}
+
+	return true, nil
+}
+
+// ArtifactInfo describes an artifact of a task
+type ArtifactInfo struct {
+	Name        string
+	StorageType string
+	ContentType string
+	Expires     time.Time
+}
+
+// ListArtifacts returns information about each of the artifacts of a specific
+// run of a task.  All pages of the Queue's response are requested, so the
+// returned slice contains every artifact of the run
+func (c *Client) ListArtifacts(taskID, runID string) ([]ArtifactInfo, error) {
+	return listArtifacts(func(continuationToken string) (*tcqueue.ListArtifactsResponse, error) {
+		resp, err := c.queue.ListArtifacts(taskID, runID, continuationToken, "")
+		if err != nil {
+			return nil, queueCallError(err, "listing artifacts of %s/%s", taskID, runID)
+		}
+		return resp, nil
+	})
+}
+
+// ListLatestArtifacts returns information about each of the artifacts of the
+// latest run of a task.  All pages of the Queue's response are requested, so
+// the returned slice contains every artifact of the run
+func (c *Client) ListLatestArtifacts(taskID string) ([]ArtifactInfo, error) {
+	return listArtifacts(func(continuationToken string) (*tcqueue.ListArtifactsResponse, error) {
+		resp, err := c.queue.ListLatestArtifacts(taskID, continuationToken, "")
+		if err != nil {
+			return nil, queueCallError(err, "listing artifacts of %s/latest", taskID)
+		}
+		return resp, nil
+	})
+}
+
+// Request every page of an artifact listing, following continuation tokens
+// until the Queue stops sending them
+func listArtifacts(list func(continuationToken string) (*tcqueue.ListArtifactsResponse, error)) ([]ArtifactInfo, error) {
+	artifacts := []ArtifactInfo{}
+	continuationToken := ""
+	for {
+		resp, err := list(continuationToken)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range resp.Artifacts {
+			artifacts = append(artifacts, ArtifactInfo{
+				Name:        a.Name,
+				StorageType: a.StorageType,
+				ContentType: a.ContentType,
+				Expires:     time.Time(a.Expires),
+			})
+		}
+		if resp.ContinuationToken == "" {
+			return artifacts, nil
+		}
+		continuationToken = resp.ContinuationToken
+	}
+}
+
+// DownloadAll downloads each artifact of a specific run of a task whose name
+// starts with prefix into destDir.  Each artifact is written to the path of
+// its name relative to destDir, creating directories as needed, so
+// public/logs/live.log with a destDir of out is written to
+// out/public/logs/live.log.  Error artifacts are skipped.  The first failed
+// download stops the remaining downloads, and the file it was writing to is
+// removed
+func (c *Client) DownloadAll(taskID, runID, prefix, destDir string) error {
+	artifacts, err := c.ListArtifacts(taskID, runID)
+	if err != nil {
+		return err
+	}
+
+	absDestDir, err := filepath.Abs(destDir)
+	if err != nil {
+		return newErrorf(err, "finding absolute path of %s", destDir)
+	}
+
+	for _, a := range artifacts {
+		if !strings.HasPrefix(a.Name, prefix) {
+			continue
+		}
+
+		if a.StorageType == "error" {
+			logger.Printf("skipping error artifact %s/%s/%s", taskID, runID, a.Name)
+			continue
+		}
+
+		// We don't want an artifact name to be able to write outside of the
+		// destination directory
+		filename := filepath.Join(absDestDir, filepath.FromSlash(a.Name))
+		if !strings.HasPrefix(filename, absDestDir+string(filepath.Separator)) {
+			return newErrorf(nil, "artifact name %s would be written outside of %s", a.Name, destDir)
+		}
+
+		if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return newErrorf(err, "creating directory for %s", filename)
+		}
+
+		var output *os.File
+		output, err = os.Create(filename)
+		if err != nil {
+			return newErrorf(err, "creating %s", filename)
+		}
+
+		err = c.Download(taskID, runID, a.Name, output)
+		closeErr := output.Close()
+		if err == nil && closeErr != nil {
+			err = newErrorf(closeErr, "closing %s", filename)
+		}
+		if err != nil {
+			// error not important, we're already returning an error
+			_ = os.Remove(filename)
+			return err
+		}
+	}
+
+	return nil
 }
 
 // DownloadLatest will download the named artifact from the latest run of a
@@ -498,6 +2211,24 @@ func (c *Client) Download(taskID, runID, name string, output io.Writer) error {
 // interface, a check that the output is already empty will occur.  The most
 // common output option is likely an ioutil.TempFile() instance.
 func (c *Client) DownloadLatest(taskID, name string, output io.Writer) error {
+	_, err := c.DownloadLatestWithResult(taskID, name, output)
+	return err
+}
+
+// DownloadLatestWithResult works like DownloadLatest, but also describes what
+// was written to the output and the downloaded artifact.  The result is
+// returned whether or not the download succeeded.  The run which was
+// downloaded from is only known when the Client has PinLatestRun set
+func (c *Client) DownloadLatestWithResult(taskID, name string, output io.Writer) (DownloadResult, error) {
+	if c.PinLatestRun {
+		runID, err := c.ResolveLatestRun(taskID)
+		if err != nil {
+			return DownloadResult{}, err
+		}
+		logger.Printf("latest run of %s is run %s", taskID, runID)
+		return c.DownloadWithResult(taskID, runID, name, output)
+	}
+
 	// We need to build the URL because we're going to need to get the redirect's
 	// headers.  That's not possible with the q.GetArtifact() method.  Ideally,
 	// we'd have a q.GetArtifact_BuildURL method which would allow us to do
@@ -505,10 +2236,11 @@ func (c *Client) DownloadLatest(taskID, name string, output io.Writer) error {
 	// with "public/"
 
 	// TODO: How long should this signed url really be valid for?
-	url, err := c.queue.GetLatestArtifact_SignedURL(taskID, name, time.Duration(1)*time.Hour)
-	if err != nil {
-		return newErrorf(err, "creating signed URL for %s/latest/%s", taskID, name)
-	}
-
-	return c.DownloadURL(url.String(), output)
+	return c.downloadSigned(taskID+"/latest/"+name, func() (string, error) {
+		url, err := c.queue.GetLatestArtifact_SignedURL(taskID, name, time.Duration(1)*time.Hour)
+		if err != nil {
+			return "", newErrorf(err, "creating signed URL for %s/latest/%s", taskID, name)
+		}
+		return url.String(), nil
+	}, output, false)
 }
@@ -2,10 +2,26 @@ package artifact
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -101,6 +117,1977 @@ func createInput(size int) *bytes.Reader {
 	return bytes.NewReader(buf.Bytes())
 }
 
+// A taskID for use with the fake Queue.  It is a valid slugid so that it passes
+// validation
+const fakeTaskID = "dSlITZ4yQgmvxxAi4A8fHQ"
+
+// Create a Queue which sends requests to a test server instead of the real
+// Queue.  It is the responsibility of the caller to run the .Close() method on
+// the returned server
+func createFakeQueue(handler http.HandlerFunc) (*tcqueue.Queue, *httptest.Server) {
+	ts := httptest.NewServer(handler)
+	q := tcqueue.New(&tcclient.Credentials{}, ts.URL)
+	q.BaseURL = ts.URL
+	return q, ts
+}
+
+func TestReferenceResolution(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/reference"):
+			w.Header().Set("x-taskcluster-artifact-storage-type", "reference")
+			w.Header().Set("location", "https://example.com/referenced")
+			w.WriteHeader(303)
+		case strings.HasSuffix(r.URL.Path, "/error"):
+			w.Header().Set("x-taskcluster-artifact-storage-type", "error")
+			w.WriteHeader(424)
+			w.Write([]byte(`{"reason": "file-missing-on-worker", "message": "no such file"}`))
+		default:
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", "https://example.com/blob")
+			w.WriteHeader(303)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+
+	t.Run("returns the url of a reference", func(t *testing.T) {
+		u, err := client.ResolveReference(fakeTaskID, "0", "reference")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u != "https://example.com/referenced" {
+			t.Fatalf("unexpected url %s", u)
+		}
+	})
+
+	t.Run("reports when the output is an error message", func(t *testing.T) {
+		u, err := q.GetArtifact_SignedURL(fakeTaskID, "0", "error", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var output bytes.Buffer
+		result, err := client.DownloadURLWithResult(u.String(), &output)
+		if err != ErrErr {
+			t.Fatalf("expected ErrErr, got %v", err)
+		}
+		if !result.ErrorBody || result.StorageType != "error" || result.BytesWritten != int64(output.Len()) || output.Len() == 0 {
+			t.Fatalf("unexpected result %#v for %d bytes of output", result, output.Len())
+		}
+	})
+
+	t.Run("returns the details of an error", func(t *testing.T) {
+		_, err := client.ResolveReference(fakeTaskID, "0", "error")
+		errArt, ok := err.(*ErrorArtifact)
+		if !ok {
+			t.Fatalf("expected an *ErrorArtifact, got %v", err)
+		}
+		if errArt.Reason != "file-missing-on-worker" || errArt.Message != "no such file" {
+			t.Fatalf("unexpected error details %#v", errArt)
+		}
+	})
+
+	t.Run("fails for blobs", func(t *testing.T) {
+		_, err := client.ResolveReference(fakeTaskID, "0", "blob")
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("fails for an invalid artifact", func(t *testing.T) {
+		if _, err := client.ResolveReference("not a slugid", "0", "reference"); err == nil {
+			t.Fatal("expected an error for an invalid taskID")
+		}
+	})
+
+	t.Run("determines the storage type", func(t *testing.T) {
+		for _, storageType := range []string{"reference", "error", "blob"} {
+			actual, err := client.StorageType(fakeTaskID, "0", storageType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != storageType {
+				t.Errorf("expected storage type %s, got %s", storageType, actual)
+			}
+		}
+	})
+
+	t.Run("storage type of an invalid artifact", func(t *testing.T) {
+		if _, err := client.StorageType("not a slugid", "0", "blob"); err == nil {
+			t.Fatal("expected an error for an invalid taskID")
+		}
+	})
+}
+
+func TestArtifactListing(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	contents := map[string][]byte{
+		"public/logs/live.log":       []byte("live log"),
+		"public/logs/nested/raw.log": []byte("raw log"),
+		"public/build/target.zip":    []byte("not really a zip"),
+	}
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/task/"+fakeTaskID+"/runs/0/artifacts":
+			// Two pages of artifacts
+			if r.URL.Query().Get("continuationToken") == "" {
+				w.Write([]byte(`{"artifacts": [
+					{"name": "public/logs/live.log", "storageType": "blob", "contentType": "text/plain", "expires": "2030-01-01T00:00:00.000Z"},
+					{"name": "public/logs/error.log", "storageType": "error", "contentType": "text/plain", "expires": "2030-01-01T00:00:00.000Z"}
+				], "continuationToken": "page2"}`))
+			} else {
+				w.Write([]byte(`{"artifacts": [
+					{"name": "public/logs/nested/raw.log", "storageType": "blob", "contentType": "text/plain", "expires": "2030-01-01T00:00:00.000Z"},
+					{"name": "public/build/target.zip", "storageType": "blob", "contentType": "application/zip", "expires": "2030-01-01T00:00:00.000Z"}
+				]}`))
+			}
+		case strings.HasPrefix(r.URL.Path, "/task/"+fakeTaskID+"/runs/0/artifacts/"):
+			name := strings.TrimPrefix(r.URL.Path, "/task/"+fakeTaskID+"/runs/0/artifacts/")
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/blob/"+name)
+			w.WriteHeader(303)
+		case strings.HasPrefix(r.URL.Path, "/blob/"):
+			b := contents[strings.TrimPrefix(r.URL.Path, "/blob/")]
+			w.Header().Set("x-amz-meta-content-length", sl(b))
+			w.Header().Set("x-amz-meta-content-sha256", hb(b))
+			w.Write(b)
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	t.Run("lists every page", func(t *testing.T) {
+		artifacts, err := client.ListArtifacts(fakeTaskID, "0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(artifacts) != 4 {
+			t.Fatalf("expected 4 artifacts, got %d", len(artifacts))
+		}
+		if artifacts[3].Name != "public/build/target.zip" || artifacts[3].ContentType != "application/zip" || artifacts[3].StorageType != "blob" {
+			t.Fatalf("unexpected artifact %#v", artifacts[3])
+		}
+		if artifacts[3].Expires.Year() != 2030 {
+			t.Fatalf("unexpected expiry %s", artifacts[3].Expires)
+		}
+	})
+
+	t.Run("reports the bytes written", func(t *testing.T) {
+		u, err := q.GetArtifact_SignedURL(fakeTaskID, "0", "public/logs/live.log", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var output bytes.Buffer
+		result, err := client.DownloadURLWithResult(u.String(), &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ErrorBody || result.StorageType != "blob" || result.BytesWritten != int64(len(contents["public/logs/live.log"])) {
+			t.Fatalf("unexpected result %#v", result)
+		}
+	})
+
+	t.Run("downloads all matching artifacts", func(t *testing.T) {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		destDir, err := ioutil.TempDir("testdata", "download-all")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(destDir)
+
+		err = client.DownloadAll(fakeTaskID, "0", "public/logs/", destDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"public/logs/live.log", "public/logs/nested/raw.log"} {
+			b, err := ioutil.ReadFile(filepath.Join(destDir, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, contents[name]) {
+				t.Errorf("unexpected contents of %s: %q", name, b)
+			}
+		}
+
+		for _, name := range []string{"public/logs/error.log", "public/build/target.zip"} {
+			if _, err := os.Stat(filepath.Join(destDir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+				t.Errorf("expected %s not to be downloaded", name)
+			}
+		}
+	})
+
+	t.Run("rejects invalid names without calling the queue", func(t *testing.T) {
+		var output bytes.Buffer
+		err := client.Download(fakeTaskID, "0", "public/logs/\n", &output)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		err = client.Download("taskid", "0", "public/logs/live.log", &output)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if output.Len() != 0 {
+			t.Fatalf("expected no output, got %q", output.Bytes())
+		}
+	})
+}
+
+func TestVerify(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	content := []byte("intact artifact")
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write(content)
+	zw.Close()
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/artifacts/error"):
+			w.Header().Set("x-taskcluster-artifact-storage-type", "error")
+			w.WriteHeader(424)
+		case strings.HasPrefix(r.URL.Path, "/task/"):
+			name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/blob/"+name)
+			w.WriteHeader(303)
+		case r.URL.Path == "/blob/intact":
+			w.Header().Set("x-amz-meta-content-length", sl(content))
+			w.Header().Set("x-amz-meta-content-sha256", hb(content))
+			w.Header().Set("content-type", "text/plain")
+			w.Write(content)
+		case r.URL.Path == "/blob/gzipped":
+			w.Header().Set("x-amz-meta-content-length", sl(content))
+			w.Header().Set("x-amz-meta-content-sha256", hb(content))
+			w.Header().Set("x-amz-meta-transfer-length", sl(gzipped.Bytes()))
+			w.Header().Set("x-amz-meta-transfer-sha256", hb(gzipped.Bytes()))
+			w.Header().Set("content-encoding", "gzip")
+			w.Write(gzipped.Bytes())
+		case r.URL.Path == "/blob/corrupt":
+			w.Header().Set("x-amz-meta-content-length", sl(content))
+			w.Header().Set("x-amz-meta-content-sha256", hb([]byte("something else")))
+			w.Write(content)
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	t.Run("intact artifact", func(t *testing.T) {
+		ok, err := client.Verify(fakeTaskID, "0", "intact")
+		if err != nil || !ok {
+			t.Fatalf("expected artifact to be verified, got %t %v", ok, err)
+		}
+	})
+
+	t.Run("intact artifact from latest run", func(t *testing.T) {
+		ok, err := client.VerifyLatest(fakeTaskID, "intact")
+		if err != nil || !ok {
+			t.Fatalf("expected artifact to be verified, got %t %v", ok, err)
+		}
+	})
+
+	t.Run("invalid artifact from latest run", func(t *testing.T) {
+		if _, err := client.VerifyLatest("not a slugid", "intact"); err == nil {
+			t.Fatal("expected an error for an invalid taskID")
+		}
+		if _, err := client.VerifyLatest(fakeTaskID, ""); err == nil {
+			t.Fatal("expected an error for an empty name")
+		}
+	})
+
+	t.Run("corrupt artifact", func(t *testing.T) {
+		ok, err := client.Verify(fakeTaskID, "0", "corrupt")
+		if err != ErrCorrupt || ok {
+			t.Fatalf("expected ErrCorrupt, got %t %v", ok, err)
+		}
+	})
+
+	t.Run("error artifact", func(t *testing.T) {
+		ok, err := client.Verify(fakeTaskID, "0", "error")
+		if err != ErrErr || ok {
+			t.Fatalf("expected ErrErr, got %t %v", ok, err)
+		}
+	})
+
+	t.Run("download with result", func(t *testing.T) {
+		var output bytes.Buffer
+		result, err := client.DownloadWithResult(fakeTaskID, "0", "intact", &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.StorageType != "blob" || result.BytesWritten != int64(len(content)) || !bytes.Equal(output.Bytes(), content) {
+			t.Fatalf("unexpected result %#v", result)
+		}
+		if result.ContentType != "text/plain" || result.ContentLength != int64(len(content)) || result.ContentEncoding != "identity" {
+			t.Fatalf("unexpected artifact metadata %#v", result.ArtifactMeta)
+		}
+	})
+
+	t.Run("gzip encoded download with result", func(t *testing.T) {
+		var output bytes.Buffer
+		result, err := client.DownloadWithResult(fakeTaskID, "0", "gzipped", &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ContentEncoding != "gzip" || !bytes.Equal(output.Bytes(), content) {
+			t.Fatalf("unexpected result %#v", result)
+		}
+
+		output.Reset()
+		result, err = client.DownloadRaw(fakeTaskID, "0", "gzipped", &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ContentEncoding != "gzip" || !bytes.Equal(output.Bytes(), gzipped.Bytes()) {
+			t.Fatalf("unexpected raw result %#v", result)
+		}
+	})
+}
+
+func TestProxy(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	content := []byte("proxied artifact")
+
+	// The proxy answers every request itself, as though it were the server
+	// named in the request
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.Host)
+		switch r.URL.Host {
+		case "queue.example":
+			if strings.HasSuffix(r.URL.Path, "/reference") {
+				w.Header().Set("x-taskcluster-artifact-storage-type", "reference")
+				w.Header().Set("location", "http://reference.example/artifact")
+			} else {
+				w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+				w.Header().Set("location", "http://blob.example/artifact")
+			}
+			w.WriteHeader(303)
+		case "blob.example", "reference.example":
+			w.Header().Set("x-amz-meta-content-length", sl(content))
+			w.Header().Set("x-amz-meta-content-sha256", hb(content))
+			w.Write(content)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := New(nil)
+	client.AllowInsecure = true
+	client.SetProxy(http.ProxyURL(proxyURL))
+
+	for _, name := range []string{"blob", "reference"} {
+		proxied = nil
+		var output bytes.Buffer
+		err := client.DownloadURL("http://queue.example/task/"+fakeTaskID+"/runs/0/artifacts/"+name, &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(output.Bytes(), content) {
+			t.Errorf("unexpected %s output %q", name, output.Bytes())
+		}
+		if len(proxied) != 2 || proxied[0] != "queue.example" || proxied[1] != name+".example" {
+			t.Errorf("expected %s requests to go through the proxy, got %v", name, proxied)
+		}
+	}
+}
+
+func TestEmptyUpload(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	var created tcqueue.BlobArtifactRequest
+	var completed tcqueue.CompleteArtifactRequest
+
+	artifactPath := "/task/" + fakeTaskID + "/runs/0/artifacts/public/empty"
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == artifactPath:
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/empty", "method": "PUT", "headers": {"content-length": "0"}}
+			]}`))
+		case r.Method == "PUT" && r.URL.Path == "/s3/empty":
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil || len(b) != 0 || r.ContentLength != 0 {
+				w.WriteHeader(400)
+				return
+			}
+			w.Header().Set("etag", "emptyetag")
+		case r.Method == "PUT" && r.URL.Path == artifactPath:
+			if err := json.NewDecoder(r.Body).Decode(&completed); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	var result UploadResult
+	client.UploadCompleted = func(taskID, runID, name string, r UploadResult) {
+		result = r
+	}
+
+	for _, multipart := range []bool{false, true} {
+		created = tcqueue.BlobArtifactRequest{}
+		completed = tcqueue.CompleteArtifactRequest{}
+		result = UploadResult{}
+
+		var output bytesReadWriteSeeker
+		err := client.Upload(fakeTaskID, "0", "public/empty", bytes.NewReader([]byte{}), &output, false, multipart)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if created.ContentLength != 0 || created.ContentSha256 != emptySha256 {
+			t.Errorf("unexpected content details for multipart=%t: %#v", multipart, created)
+		}
+		if len(created.Parts) != 0 {
+			t.Errorf("expected a single part upload for multipart=%t, got %d parts", multipart, len(created.Parts))
+		}
+		if len(completed.Etags) != 1 || completed.Etags[0] != "emptyetag" {
+			t.Errorf("unexpected etags for multipart=%t: %#v", multipart, completed.Etags)
+		}
+		if len(result.Etags) != 1 || result.Etags[0] != "emptyetag" || len(result.Response.Requests) != 1 || result.Request.ContentSha256 != emptySha256 {
+			t.Errorf("unexpected upload result for multipart=%t: %#v", multipart, result)
+		}
+	}
+}
+
+func TestStreamingUpload(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	var created tcqueue.BlobArtifactRequest
+	var uploaded []byte
+	completed := false
+
+	artifactPath := "/task/" + fakeTaskID + "/runs/0/artifacts/public/streamed"
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == artifactPath:
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/streamed", "method": "PUT", "headers": {"content-length": "` + strconv.FormatInt(created.TransferLength, 10) + `"}}
+			]}`))
+		case r.Method == "PUT" && r.URL.Path == "/s3/streamed":
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil || int64(len(b)) != r.ContentLength {
+				w.WriteHeader(400)
+				return
+			}
+			uploaded = b
+			w.Header().Set("etag", "streamedetag")
+		case r.Method == "PUT" && r.URL.Path == artifactPath:
+			completed = true
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	data := []byte("streamed straight from the input")
+	hash := sha256.Sum256(data)
+
+	t.Run("uploads the input", func(t *testing.T) {
+		uploaded, completed = nil, false
+
+		err := client.UploadStream(fakeTaskID, "0", "public/streamed", bytes.NewReader(data), int64(len(data)), hash[:], "text/plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(uploaded, data) {
+			t.Errorf("expected %q to be uploaded, got %q", data, uploaded)
+		}
+		if created.ContentSha256 != hex.EncodeToString(hash[:]) || created.ContentLength != int64(len(data)) || created.ContentType != "text/plain" {
+			t.Errorf("unexpected artifact request %#v", created)
+		}
+		if !completed {
+			t.Error("expected artifact to be completed")
+		}
+	})
+
+	t.Run("aborts when the hash is wrong", func(t *testing.T) {
+		uploaded, completed = nil, false
+
+		wrong := sha256.Sum256([]byte("something else"))
+		err := client.UploadStream(fakeTaskID, "0", "public/streamed", bytes.NewReader(data), int64(len(data)), wrong[:], "")
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if uploaded != nil || completed {
+			t.Error("expected upload to be aborted")
+		}
+	})
+
+	t.Run("aborts when the input is short", func(t *testing.T) {
+		uploaded, completed = nil, false
+
+		err := client.UploadStream(fakeTaskID, "0", "public/streamed", bytes.NewReader(data[1:]), int64(len(data)), hash[:], "")
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if uploaded != nil || completed {
+			t.Error("expected upload to be aborted")
+		}
+	})
+}
+
+func TestProgress(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	content := bytes.Repeat([]byte("progress"), 64*1024)
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/progress", "method": "PUT", "headers": {}}
+			]}`))
+		case r.Method == "PUT" && r.URL.Path == "/s3/progress":
+			ioutil.ReadAll(r.Body)
+			w.Header().Set("etag", "progressetag")
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/task/"):
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/blob/progress")
+			w.WriteHeader(303)
+		case r.Method == "GET" && r.URL.Path == "/blob/progress":
+			w.Header().Set("content-length", sl(content))
+			w.Header().Set("x-amz-meta-content-length", sl(content))
+			w.Header().Set("x-amz-meta-content-sha256", hb(content))
+			w.Write(content)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	// Check that the reports count up to the total
+	checkReports := func(t *testing.T, reports [][2]int64, expectedTotal int64) {
+		if len(reports) == 0 {
+			t.Fatal("expected progress to be reported")
+		}
+		var last int64
+		for _, report := range reports {
+			if report[0] <= last {
+				t.Fatalf("progress went from %d to %d", last, report[0])
+			}
+			if report[1] != expectedTotal {
+				t.Fatalf("expected total of %d, got %d", expectedTotal, report[1])
+			}
+			last = report[0]
+		}
+		if last != expectedTotal {
+			t.Fatalf("expected progress to reach %d, got %d", expectedTotal, last)
+		}
+	}
+
+	t.Run("upload", func(t *testing.T) {
+		var reports [][2]int64
+		client.OnUploadProgress = func(transfered, total int64) {
+			reports = append(reports, [2]int64{transfered, total})
+		}
+		defer func() { client.OnUploadProgress = nil }()
+
+		err := client.UploadBytes(fakeTaskID, "0", "public/progress", content, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkReports(t, reports, int64(len(content)))
+	})
+
+	t.Run("download", func(t *testing.T) {
+		var reports [][2]int64
+		client.OnDownloadProgress = func(transfered, total int64) {
+			reports = append(reports, [2]int64{transfered, total})
+		}
+		defer func() { client.OnDownloadProgress = nil }()
+
+		var output bytes.Buffer
+		err := client.Download(fakeTaskID, "0", "public/progress", &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkReports(t, reports, int64(len(content)))
+	})
+}
+
+func TestOnChunk(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	content := make([]byte, 6*1024*1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/part0", "method": "PUT", "headers": {}},
+				{"url": "` + ts.URL + `/s3/part1", "method": "PUT", "headers": {}}
+			]}`))
+		case r.Method == "PUT":
+			ioutil.ReadAll(r.Body)
+			w.Header().Set("etag", r.URL.Path)
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/task/"):
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/blob/chunks")
+			w.WriteHeader(303)
+		case r.Method == "GET" && r.URL.Path == "/blob/chunks":
+			w.Header().Set("x-amz-meta-content-length", sl(content))
+			w.Header().Set("x-amz-meta-content-sha256", hb(content))
+			w.Write(content)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+	if err := client.SetInternalSizes(DefaultChunkSize, 5*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetUploadConcurrency(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Put the chunks back together, checking that none of them overlap
+	var lock sync.Mutex
+	var reassembled []byte
+	var chunked int
+	client.OnChunk = func(offset int64, data []byte) {
+		lock.Lock()
+		defer lock.Unlock()
+		copy(reassembled[offset:], data)
+		chunked += len(data)
+	}
+
+	t.Run("upload", func(t *testing.T) {
+		reassembled, chunked = make([]byte, len(content)), 0
+		if err := client.UploadBytes(fakeTaskID, "0", "public/chunks", content, false, true); err != nil {
+			t.Fatal(err)
+		}
+		if chunked != len(content) || !bytes.Equal(reassembled, content) {
+			t.Fatalf("expected chunks of the %d bytes uploaded, got %d bytes", len(content), chunked)
+		}
+	})
+
+	t.Run("download", func(t *testing.T) {
+		reassembled, chunked = make([]byte, len(content)), 0
+		var output bytes.Buffer
+		if err := client.Download(fakeTaskID, "0", "public/chunks", &output); err != nil {
+			t.Fatal(err)
+		}
+		if chunked != len(content) || !bytes.Equal(reassembled, content) {
+			t.Fatalf("expected chunks of the %d bytes downloaded, got %d bytes", len(content), chunked)
+		}
+	})
+}
+
+func TestUnauthorizedQueueCalls(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"code": "InsufficientScopes", "message": "missing scopes"}`))
+	})
+	defer ts.Close()
+
+	client := New(q)
+
+	if err := client.CreateError(fakeTaskID, "0", "public/refused", "file-missing-on-worker", "missing"); err != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized creating an error artifact, got %v", err)
+	}
+
+	if err := client.UploadBytes(fakeTaskID, "0", "public/refused", []byte("refused"), false, false); err != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized uploading, got %v", err)
+	}
+
+	if _, err := client.ListArtifacts(fakeTaskID, "0"); err != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized listing artifacts, got %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	content := []byte("kept alive")
+
+	closed := make(chan struct{}, 1)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-amz-meta-content-length", sl(content))
+		w.Header().Set("x-amz-meta-content-sha256", hb(content))
+		w.Write(content)
+	}))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	client := New(nil)
+
+	if _, _, err := client.run(newRequest(ts.URL, "GET", &http.Header{}), nil, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the idle connection to be closed")
+	}
+}
+
+func TestQueueCallRetries(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	var createStatuses, completeStatuses []int
+	var createCalls, completeCalls int
+
+	artifactPath := "/task/" + fakeTaskID + "/runs/0/artifacts/public/retried"
+
+	// Each call to the Queue responds with the next status in the list, or
+	// succeeds once the list is exhausted
+	nextStatus := func(statuses []int, calls *int) int {
+		*calls++
+		if *calls <= len(statuses) {
+			return statuses[*calls-1]
+		}
+		return 200
+	}
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == artifactPath:
+			if sc := nextStatus(createStatuses, &createCalls); sc != 200 {
+				w.WriteHeader(sc)
+				return
+			}
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/retried", "method": "PUT", "headers": {}}
+			]}`))
+		case r.Method == "PUT" && r.URL.Path == "/s3/retried":
+			w.Header().Set("etag", "retriedetag")
+		case r.Method == "PUT" && r.URL.Path == artifactPath:
+			w.WriteHeader(nextStatus(completeStatuses, &completeCalls))
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+	client.MaxRetries = 2
+	client.RetryDelay = time.Millisecond
+
+	upload := func(creates, completes []int) error {
+		createStatuses, completeStatuses = creates, completes
+		createCalls, completeCalls = 0, 0
+		return client.UploadBytes(fakeTaskID, "0", "public/retried", []byte("retried"), false, false)
+	}
+
+	t.Run("retries server errors", func(t *testing.T) {
+		if err := upload([]int{500, 503}, []int{500}); err != nil {
+			t.Fatal(err)
+		}
+		if createCalls != 3 || completeCalls != 2 {
+			t.Fatalf("expected 3 createArtifact and 2 completeArtifact calls, got %d and %d", createCalls, completeCalls)
+		}
+	})
+
+	t.Run("gives up after the maximum retries", func(t *testing.T) {
+		if err := upload([]int{500, 500, 500}, nil); err == nil {
+			t.Fatal("expected an error")
+		}
+		if createCalls != 3 || completeCalls != 0 {
+			t.Fatalf("expected 3 createArtifact and 0 completeArtifact calls, got %d and %d", createCalls, completeCalls)
+		}
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		if err := upload([]int{400}, nil); err == nil {
+			t.Fatal("expected an error")
+		}
+		if createCalls != 1 {
+			t.Fatalf("expected 1 createArtifact call, got %d", createCalls)
+		}
+	})
+
+	t.Run("treats an already completed artifact as success", func(t *testing.T) {
+		if err := upload(nil, []int{500, 409}); err != nil {
+			t.Fatal(err)
+		}
+		if completeCalls != 2 {
+			t.Fatalf("expected 2 completeArtifact calls, got %d", completeCalls)
+		}
+	})
+}
+
+// A shortReader returns at most n bytes from each call to Read
+type shortReader struct {
+	r io.Reader
+	n int
+}
+
+func (s shortReader) Read(p []byte) (int, error) {
+	if len(p) > s.n {
+		p = p[:s.n]
+	}
+	return s.r.Read(p)
+}
+
+func TestMimeSniffing(t *testing.T) {
+	html := []byte("<!DOCTYPE html><html><body>" + strings.Repeat("content ", 100) + "</body></html>")
+
+	inputs := []struct {
+		expected string
+		input    []byte
+	}{
+		{"text/plain; charset=utf-8", []byte("a tiny text file")},
+		{"text/html; charset=utf-8", html},
+		{"text/plain; charset=utf-8", []byte("")},
+	}
+
+	for _, tt := range inputs {
+		expected, input := tt.expected, tt.input
+		full, err := readMimeBuf(bytes.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		short, err := readMimeBuf(shortReader{bytes.NewReader(input), 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(full, short) {
+			t.Errorf("short reads gave %q instead of %q", short, full)
+		}
+		if actual := http.DetectContentType(short); actual != expected {
+			t.Errorf("expected %s, got %s", expected, actual)
+		}
+		if len(short) > 512 || (len(input) <= 512 && len(short) != len(input)) {
+			t.Errorf("read %d bytes of a %d byte input", len(short), len(input))
+		}
+	}
+}
+
+func TestUploadContentType(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	var created tcqueue.BlobArtifactRequest
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/report", "method": "PUT", "headers": {}}
+			]}`))
+		case r.URL.Path == "/s3/report":
+			ioutil.ReadAll(r.Body)
+			w.Header().Set("etag", "reportetag")
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	report := []byte(`{"passed": true}`)
+
+	t.Run("determined from the input", func(t *testing.T) {
+		if err := client.UploadBytes(fakeTaskID, "0", "public/report.json", report, false, false); err != nil {
+			t.Fatal(err)
+		}
+		if created.ContentType != "text/plain; charset=utf-8" {
+			t.Errorf("expected sniffed content type, got %s", created.ContentType)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		client.ContentType = "application/json"
+		defer func() { client.ContentType = "" }()
+
+		if err := client.UploadBytes(fakeTaskID, "0", "public/report.json", report, false, false); err != nil {
+			t.Fatal(err)
+		}
+		if created.ContentType != "application/json" {
+			t.Errorf("expected configured content type, got %s", created.ContentType)
+		}
+	})
+	t.Run("determined before gzip encoding", func(t *testing.T) {
+		page := []byte("<!DOCTYPE html><html><body>" + strings.Repeat("<p>passed</p>", 100) + "</body></html>")
+		var output bytesReadWriteSeeker
+		if err := client.Upload(fakeTaskID, "0", "public/report", bytes.NewReader(page), &output, true, false); err != nil {
+			t.Fatal(err)
+		}
+		if created.ContentEncoding != "gzip" {
+			t.Fatalf("expected a gzip encoded upload, got %q", created.ContentEncoding)
+		}
+		if created.ContentType != "text/html; charset=utf-8" {
+			t.Errorf("expected the content type of the decoded content, got %s", created.ContentType)
+		}
+	})
+
+	dir, err := ioutil.TempDir("", "content-type")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	uploadFile := func(t *testing.T, filename string, data []byte) string {
+		path := filepath.Join(dir, filename)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.UploadFile(fakeTaskID, "0", "public/"+filename, path, false, false); err != nil {
+			t.Fatal(err)
+		}
+		return created.ContentType
+	}
+
+	t.Run("determined from the file extension", func(t *testing.T) {
+		for filename, expected := range map[string]string{
+			"report.json": "application/json",
+			"build.LOG":   "text/plain; charset=utf-8",
+			"report.dat":  "text/plain; charset=utf-8",
+			"page.xml":    "application/xml",
+		} {
+			if contentType := uploadFile(t, filename, report); contentType != expected {
+				t.Errorf("expected %s to have content type %s, got %s", filename, expected, contentType)
+			}
+		}
+	})
+
+	t.Run("extensions set on the client", func(t *testing.T) {
+		custom := New(q)
+		custom.AllowInsecure = true
+		custom.SetContentTypeByExtension(map[string]string{
+			"dat":   "application/x-report",
+			".json": "",
+		})
+
+		for filename, expected := range map[string]string{
+			"custom.dat":  "application/x-report",
+			"custom.json": "text/plain; charset=utf-8",
+			"custom.log":  "text/plain; charset=utf-8",
+		} {
+			path := filepath.Join(dir, filename)
+			if err := ioutil.WriteFile(path, report, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := custom.UploadFile(fakeTaskID, "0", "public/"+filename, path, false, false); err != nil {
+				t.Fatal(err)
+			}
+			if created.ContentType != expected {
+				t.Errorf("expected %s to have content type %s, got %s", filename, expected, created.ContentType)
+			}
+		}
+
+		// Other clients keep the defaults
+		if contentType := uploadFile(t, "other.json", report); contentType != "application/json" {
+			t.Errorf("expected other clients to keep the default for .json, got %s", contentType)
+		}
+	})
+}
+
+func TestDirectUpload(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	var uploaded []byte
+	var created tcqueue.BlobArtifactRequest
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			json.NewDecoder(r.Body).Decode(&created)
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/direct", "method": "PUT", "headers": {}}
+			]}`))
+		case r.URL.Path == "/s3/direct":
+			uploaded, _ = ioutil.ReadAll(r.Body)
+			w.Header().Set("etag", "directetag")
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	data := []byte("uploaded straight from the input")
+
+	t.Run("without an output", func(t *testing.T) {
+		uploaded = nil
+		if err := client.Upload(fakeTaskID, "0", "public/direct", bytes.NewReader(data), nil, false, false); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(uploaded, data) {
+			t.Errorf("expected %q to be uploaded, got %q", data, uploaded)
+		}
+	})
+
+	t.Run("leaves the output untouched", func(t *testing.T) {
+		uploaded = nil
+		var output bytesReadWriteSeeker
+		if err := client.Upload(fakeTaskID, "0", "public/direct", bytes.NewReader(data), &output, false, false); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(uploaded, data) {
+			t.Errorf("expected %q to be uploaded, got %q", data, uploaded)
+		}
+		if size, _ := output.Seek(0, io.SeekEnd); size != 0 {
+			t.Errorf("expected output to be empty, has %d bytes", size)
+		}
+	})
+
+	t.Run("multipart from an io.ReaderAt", func(t *testing.T) {
+		uploaded, created = nil, tcqueue.BlobArtifactRequest{}
+		if err := client.Upload(fakeTaskID, "0", "public/direct", bytes.NewReader(data), nil, false, true); err != nil {
+			t.Fatal(err)
+		}
+		if len(created.Parts) != 1 || created.Parts[0].Sha256 != hb(data) {
+			t.Errorf("expected a multipart upload of a single part, got parts %#v", created.Parts)
+		}
+		if !bytes.Equal(uploaded, data) {
+			t.Errorf("expected %q to be uploaded, got %q", data, uploaded)
+		}
+	})
+
+	for _, tt := range []struct{ gzip, multipart bool }{{true, false}, {false, true}} {
+		err := client.Upload(fakeTaskID, "0", "public/direct", onlyReadSeeker{bytes.NewReader(data)}, nil, tt.gzip, tt.multipart)
+		if err == nil {
+			t.Errorf("expected an error without an output for gzip=%t multipart=%t", tt.gzip, tt.multipart)
+		}
+	}
+}
+
+func TestUploadMany(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	created := map[string]int{}
+	uploaded := map[string][]byte{}
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			name := r.URL.Path[strings.Index(r.URL.Path, "/artifacts/")+len("/artifacts/"):]
+			created[name]++
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/` + name + `", "method": "PUT", "headers": {}}
+			]}`))
+		case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/s3/"):
+			uploaded[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+			w.Header().Set("etag", r.URL.Path)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	data := []byte("published under two names")
+	names := []string{"public/v1.0/build.txt", "public/latest/build.txt"}
+
+	var output bytesReadWriteSeeker
+	if err := client.UploadMany(fakeTaskID, "0", names, bytes.NewReader(data), &output, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range names {
+		if created[name] != 1 {
+			t.Errorf("expected %s to be created once, got %v", name, created)
+		}
+	}
+
+	if len(uploaded) != len(names) {
+		t.Fatalf("expected %d uploads, got %d", len(names), len(uploaded))
+	}
+	for path, body := range uploaded {
+		if !bytes.Equal(body, output.Bytes()) {
+			t.Errorf("expected %s to upload the gzip encoded output", path)
+		}
+	}
+
+	if err := client.UploadMany(fakeTaskID, "0", nil, bytes.NewReader(data), &bytesReadWriteSeeker{}, true, false); err == nil {
+		t.Error("expected an error without any names")
+	}
+}
+
+func TestUploadTraceID(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	// Parts are uploaded one at a time by default
+	var traceIDs []string
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/part1", "method": "PUT", "headers": {}},
+				{"url": "` + ts.URL + `/s3/part2", "method": "PUT", "headers": {}}
+			]}`))
+		case strings.HasPrefix(r.URL.Path, "/s3/"):
+			traceIDs = append(traceIDs, r.Header.Get(TraceIDHeader))
+			w.Header().Set("etag", r.URL.Path)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+	if err := client.SetInternalSizes(DefaultChunkSize, 5*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("a"), 6*1024*1024)
+
+	t.Run("parts share a generated trace id", func(t *testing.T) {
+		traceIDs = nil
+		if err := client.UploadBytes(fakeTaskID, "0", "public/traced", data, false, true); err != nil {
+			t.Fatal(err)
+		}
+		if len(traceIDs) != 2 || traceIDs[0] == "" || traceIDs[0] != traceIDs[1] {
+			t.Fatalf("expected both parts to have the same trace id, got %q", traceIDs)
+		}
+	})
+
+	t.Run("configured trace id", func(t *testing.T) {
+		traceIDs = nil
+		client.TraceID = "operator-trace"
+		defer func() { client.TraceID = "" }()
+		if err := client.UploadBytes(fakeTaskID, "0", "public/traced", data, false, true); err != nil {
+			t.Fatal(err)
+		}
+		for _, traceID := range traceIDs {
+			if traceID != "operator-trace" {
+				t.Fatalf("expected trace id operator-trace, got %q", traceIDs)
+			}
+		}
+	})
+}
+
+func TestLogArtifact(t *testing.T) {
+	var logs bytes.Buffer
+	SetLogOutput(&logs)
+	defer SetLogOutput(newUnitTestLogWriter(t))
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/part1", "method": "PUT", "headers": {}},
+				{"url": "` + ts.URL + `/s3/part2", "method": "PUT", "headers": {}}
+			]}`))
+		case strings.HasPrefix(r.URL.Path, "/s3/"):
+			w.Header().Set("etag", r.URL.Path)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+	if err := client.SetInternalSizes(DefaultChunkSize, 5*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("a"), 6*1024*1024)
+	if err := client.UploadBytes(fakeTaskID, "0", "public/logged", data, false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log(logs.String())
+	prefix := fakeTaskID + "/0/public/logged: Response PUT " + ts.URL + "/s3/part"
+	if n := strings.Count(logs.String(), prefix); n != 2 {
+		t.Fatalf("expected the log messages of both parts to name the artifact, found %d", n)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	var creates, uploads, completes int
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			creates++
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/part1", "method": "PUT", "headers": {}},
+				{"url": "` + ts.URL + `/s3/part2", "method": "PUT", "headers": {}}
+			]}`))
+		case strings.HasPrefix(r.URL.Path, "/s3/"):
+			uploads++
+			w.Header().Set("etag", r.URL.Path)
+		case r.Method == "PUT":
+			completes++
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+	client.DryRun = true
+	if err := client.SetInternalSizes(DefaultChunkSize, 5*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	var planned []UploadResult
+	client.UploadPlanned = func(taskID, runID, name string, r UploadResult) {
+		planned = append(planned, r)
+	}
+	client.UploadCompleted = func(taskID, runID, name string, r UploadResult) {
+		t.Fatal("dry runs must not complete artifacts")
+	}
+
+	data := bytes.Repeat([]byte("a"), 6*1024*1024)
+	if err := client.UploadBytes(fakeTaskID, "0", "public/dry", data, false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if creates != 1 || uploads != 0 || completes != 0 {
+		t.Fatalf("expected only the artifact to be created, got %d creates, %d uploads and %d completes", creates, uploads, completes)
+	}
+
+	if len(planned) != 1 {
+		t.Fatalf("expected one planned upload, got %d", len(planned))
+	}
+	plan := planned[0]
+	if plan.Etags != nil {
+		t.Errorf("expected no etags, got %q", plan.Etags)
+	}
+	if plan.Request.ContentLength != int64(len(data)) {
+		t.Errorf("expected a content length of %d, got %d", len(data), plan.Request.ContentLength)
+	}
+	if len(plan.Request.Parts) != 2 || plan.Request.Parts[0].Size != 5*1024*1024 || plan.Request.Parts[1].Size != 1024*1024 {
+		t.Errorf("unexpected parts %#v", plan.Request.Parts)
+	}
+	if len(plan.Response.Requests) != 2 {
+		t.Errorf("expected the requests for both parts, got %d", len(plan.Response.Requests))
+	}
+}
+
+func TestUploadFailedParts(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/part1", "method": "PUT", "headers": {}},
+				{"url": "` + ts.URL + `/s3/part2", "method": "PUT", "headers": {}},
+				{"url": "` + ts.URL + `/s3/part3", "method": "PUT", "headers": {}}
+			]}`))
+		case r.URL.Path == "/s3/part3":
+			ioutil.ReadAll(r.Body)
+			w.WriteHeader(400)
+		case strings.HasPrefix(r.URL.Path, "/s3/"):
+			ioutil.ReadAll(r.Body)
+			w.Header().Set("etag", strings.TrimPrefix(r.URL.Path, "/s3/"))
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+	if err := client.SetInternalSizes(DefaultChunkSize, 5*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	var failed *UploadResult
+	var failedErr error
+	client.UploadFailed = func(taskID, runID, name string, r UploadResult, err error) {
+		failed = &r
+		failedErr = err
+	}
+
+	data := bytes.Repeat([]byte("a"), 11*1024*1024)
+	err := client.UploadBytes(fakeTaskID, "0", "public/flaky", data, false, true)
+	if err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+
+	if failed == nil {
+		t.Fatal("expected UploadFailed to be called")
+	}
+	if failedErr != err {
+		t.Errorf("expected UploadFailed to get %v, got %v", err, failedErr)
+	}
+	if len(failed.Etags) != 3 || failed.Etags[0] != "part1" || failed.Etags[1] != "part2" || failed.Etags[2] != "" {
+		t.Errorf("unexpected etags %q", failed.Etags)
+	}
+
+	// Parts are uploaded one at a time by default, so they finish in order
+	if len(failed.Parts) != 3 {
+		t.Fatalf("expected summaries of 3 parts, got %d", len(failed.Parts))
+	}
+	partSize := int64(5 * 1024 * 1024)
+	for i, p := range failed.Parts {
+		size := partSize
+		if i == 2 {
+			size = int64(len(data)) - 2*partSize
+		}
+		part := data[int64(i)*partSize : int64(i)*partSize+size]
+		if p.Part != i || p.Start != int64(i)*partSize || p.Size != size || p.Sent != size || p.Sha256 != hb(part) {
+			t.Errorf("unexpected summary of part %d: %+v", i, p)
+		}
+	}
+	if p := failed.Parts[1]; p.StatusCode != 200 || p.Etag != "part2" || p.Err != nil {
+		t.Errorf("expected part 2 to be uploaded, got %+v", p)
+	}
+	if p := failed.Parts[2]; p.StatusCode != 400 || p.Etag != "" || p.Err == nil {
+		t.Errorf("expected part 3 to fail, got %+v", p)
+	}
+}
+
+func TestMismatchedUploadRequests(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	requests := 0
+	uploads := 0
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			var parts []string
+			for i := 0; i < requests; i++ {
+				parts = append(parts, `{"url": "`+ts.URL+`/s3/part`+strconv.Itoa(i)+`", "method": "PUT", "headers": {}}`)
+			}
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [` + strings.Join(parts, ",") + `]}`))
+		case strings.HasPrefix(r.URL.Path, "/s3/"):
+			uploads++
+			ioutil.ReadAll(r.Body)
+			w.Header().Set("etag", strings.TrimPrefix(r.URL.Path, "/s3/"))
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+	if err := client.SetInternalSizes(DefaultChunkSize, 5*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("a"), 11*1024*1024)
+
+	for _, tc := range []struct {
+		name      string
+		multipart bool
+		requests  int
+	}{
+		{"too few requests for the parts", true, 2},
+		{"too many requests for the parts", true, 4},
+		{"too many requests for a single part", false, 2},
+		{"no requests", false, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requests = tc.requests
+			uploads = 0
+			err := client.UploadBytes(fakeTaskID, "0", "public/mismatched", data, false, tc.multipart)
+			if err == nil {
+				t.Fatal("expected the upload to fail")
+			}
+			if uploads != 0 {
+				t.Errorf("expected no parts to be uploaded, got %d", uploads)
+			}
+		})
+	}
+}
+
+func TestUploadCRC32C(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	// Parts are uploaded one at a time by default
+	var checksums []string
+	var bodies [][]byte
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/part1", "method": "PUT", "headers": {}},
+				{"url": "` + ts.URL + `/s3/part2", "method": "PUT", "headers": {}}
+			]}`))
+		case strings.HasPrefix(r.URL.Path, "/s3/"):
+			body, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, body)
+			checksums = append(checksums, r.Header.Get("x-amz-checksum-crc32c"))
+			w.Header().Set("etag", r.URL.Path)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+	if err := client.SetInternalSizes(DefaultChunkSize, 5*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("crc"), 2*1024*1024)
+
+	t.Run("disabled by default", func(t *testing.T) {
+		checksums, bodies = nil, nil
+		if err := client.UploadBytes(fakeTaskID, "0", "public/crc", data, false, true); err != nil {
+			t.Fatal(err)
+		}
+		for _, checksum := range checksums {
+			if checksum != "" {
+				t.Fatalf("expected no checksums, got %q", checksums)
+			}
+		}
+	})
+
+	t.Run("sent for each part", func(t *testing.T) {
+		checksums, bodies = nil, nil
+		client.EnableCRC32C = true
+		defer func() { client.EnableCRC32C = false }()
+		if err := client.UploadBytes(fakeTaskID, "0", "public/crc", data, false, true); err != nil {
+			t.Fatal(err)
+		}
+		if len(checksums) != 2 {
+			t.Fatalf("expected two parts, got %d", len(checksums))
+		}
+		for i, body := range bodies {
+			if expected := encodeCRC32C(crc32.Checksum(body, crc32cTable)); checksums[i] != expected {
+				t.Errorf("part %d: expected checksum %s, got %s", i, expected, checksums[i])
+			}
+		}
+	})
+}
+
+func TestUploadContentMD5(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	// Parts are uploaded one at a time by default
+	var checksums []string
+	var bodies [][]byte
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			var bareq tcqueue.BlobArtifactRequest
+			json.NewDecoder(r.Body).Decode(&bareq)
+			parts := []string{`{"url": "` + ts.URL + `/s3/part0", "method": "PUT", "headers": {}}`}
+			for i := 1; i < len(bareq.Parts); i++ {
+				parts = append(parts, `{"url": "`+ts.URL+`/s3/part`+strconv.Itoa(i)+`", "method": "PUT", "headers": {}}`)
+			}
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [` + strings.Join(parts, ",") + `]}`))
+		case strings.HasPrefix(r.URL.Path, "/s3/"):
+			body, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, body)
+			checksums = append(checksums, r.Header.Get("Content-MD5"))
+			w.Header().Set("etag", r.URL.Path)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+	if err := client.SetInternalSizes(DefaultChunkSize, 5*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("md5"), 2*1024*1024)
+
+	t.Run("disabled by default", func(t *testing.T) {
+		checksums, bodies = nil, nil
+		if err := client.UploadBytes(fakeTaskID, "0", "public/md5", data, false, true); err != nil {
+			t.Fatal(err)
+		}
+		for _, checksum := range checksums {
+			if checksum != "" {
+				t.Fatalf("expected no checksums, got %q", checksums)
+			}
+		}
+	})
+
+	for _, tc := range []struct {
+		name            string
+		gzip, multipart bool
+		parts           int
+	}{
+		{"sent for each part", false, true, 2},
+		{"sent for each gzip encoded part", true, true, 1},
+		{"sent for single part uploads", false, false, 1},
+		{"sent for gzip encoded single part uploads", true, false, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			checksums, bodies = nil, nil
+			client.EnableContentMD5 = true
+			defer func() { client.EnableContentMD5 = false }()
+			if err := client.UploadBytes(fakeTaskID, "0", "public/md5", data, tc.gzip, tc.multipart); err != nil {
+				t.Fatal(err)
+			}
+			if len(checksums) != tc.parts {
+				t.Fatalf("expected %d parts, got %d", tc.parts, len(checksums))
+			}
+			for i, body := range bodies {
+				sum := md5.Sum(body)
+				if expected := base64.StdEncoding.EncodeToString(sum[:]); checksums[i] != expected {
+					t.Errorf("part %d: expected checksum %s, got %s", i, expected, checksums[i])
+				}
+			}
+		})
+	}
+}
+
+// An unseekableWriter is an io.Seeker whose Seek always fails, like os.Stdout
+type unseekableWriter struct {
+	bytes.Buffer
+	seeks int
+}
+
+func (w *unseekableWriter) Seek(offset int64, whence int) (int64, error) {
+	w.seeks++
+	return 0, fmt.Errorf("illegal seek")
+}
+
+func TestNonEmptyOutput(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	data := []byte("written through a reused output")
+	var uploaded []byte
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/reused", "method": "PUT", "headers": {}}
+			]}`))
+		case r.Method == "PUT" && r.URL.Path == "/s3/reused":
+			uploaded, _ = ioutil.ReadAll(r.Body)
+			w.Header().Set("etag", "reusedetag")
+		case r.Method == "PUT":
+			// completeArtifact
+		case strings.HasPrefix(r.URL.Path, "/task/"):
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/s3/reused")
+			w.WriteHeader(303)
+		default:
+			w.Header().Set("x-amz-meta-content-length", sl(data))
+			w.Header().Set("x-amz-meta-content-sha256", hb(data))
+			w.Write(data)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	// A reused output which the caller has rewound, but not truncated
+	reused := func() *bytesReadWriteSeeker {
+		output := &bytesReadWriteSeeker{}
+		output.Write(bytes.Repeat([]byte("stale"), 100))
+		output.Seek(0, io.SeekStart)
+		return output
+	}
+
+	t.Run("upload rejects non-empty output by default", func(t *testing.T) {
+		err := client.Upload(fakeTaskID, "0", "public/reused", bytes.NewReader(data), reused(), true, false)
+		if err != ErrBadOutputWriter {
+			t.Fatalf("expected ErrBadOutputWriter, got %v", err)
+		}
+	})
+
+	t.Run("download rejects non-empty output by default", func(t *testing.T) {
+		if err := client.Download(fakeTaskID, "0", "public/reused", reused()); err != ErrBadOutputWriter {
+			t.Fatalf("expected ErrBadOutputWriter, got %v", err)
+		}
+	})
+
+	t.Run("download proceeds when the output can't seek", func(t *testing.T) {
+		var output unseekableWriter
+		if err := client.Download(fakeTaskID, "0", "public/reused", &output); err != nil {
+			t.Fatal(err)
+		}
+		if output.seeks == 0 {
+			t.Fatal("expected the output to be sought")
+		}
+		if !bytes.Equal(output.Bytes(), data) {
+			t.Errorf("expected %q to be downloaded, got %q", data, output.Bytes())
+		}
+	})
+
+	client.AllowNonEmptyOutput = true
+
+	t.Run("upload allows non-empty output when configured", func(t *testing.T) {
+		uploaded = nil
+		if err := client.Upload(fakeTaskID, "0", "public/reused", bytes.NewReader(data), reused(), true, false); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(uploaded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("expected %q to be uploaded, got %q", data, decoded)
+		}
+	})
+
+	t.Run("download allows non-empty output when configured", func(t *testing.T) {
+		output := reused()
+		if err := client.Download(fakeTaskID, "0", "public/reused", output); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(output.Bytes(), data) {
+			t.Errorf("expected output to start with %q, got %q", data, output.Bytes())
+		}
+	})
+}
+
+func TestArtifactExpiry(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	var created struct {
+		Expires time.Time `json:"expires"`
+	}
+
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		w.Write([]byte(`{"storageType": "error"}`))
+	})
+	defer ts.Close()
+
+	client := New(q)
+
+	t.Run("defaults to one day", func(t *testing.T) {
+		if err := client.CreateError(fakeTaskID, "0", "public/expiry", "file-missing-on-worker", "missing"); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Until(created.Expires); d < 23*time.Hour || d > 25*time.Hour {
+			t.Errorf("expected artifact to expire in a day, got %s", created.Expires)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		client.Expires = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		if err := client.CreateError(fakeTaskID, "0", "public/expiry", "file-missing-on-worker", "missing"); err != nil {
+			t.Fatal(err)
+		}
+		if !created.Expires.Equal(client.Expires) {
+			t.Errorf("expected artifact to expire at %s, got %s", client.Expires, created.Expires)
+		}
+	})
+}
+
+func TestDoubleGzipDetection(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+	})
+	defer ts.Close()
+
+	client := New(q)
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte("already compressed"))
+	zw.Close()
+
+	t.Run("rejects gzip encoding a gzip input", func(t *testing.T) {
+		err := client.UploadBytes(fakeTaskID, "0", "public/double.gz", gzipped.Bytes(), true, false)
+		if err != ErrDoubleGzip {
+			t.Fatalf("expected ErrDoubleGzip, got %v", err)
+		}
+	})
+
+	t.Run("allows identity encoding a gzip input", func(t *testing.T) {
+		err := client.UploadBytes(fakeTaskID, "0", "public/double.gz", gzipped.Bytes(), false, false)
+		if err == ErrDoubleGzip {
+			t.Fatal("did not expect ErrDoubleGzip")
+		}
+	})
+
+	t.Run("allows double gzip when configured", func(t *testing.T) {
+		client.AllowDoubleGzip = true
+		defer func() { client.AllowDoubleGzip = false }()
+		err := client.UploadBytes(fakeTaskID, "0", "public/double.gz", gzipped.Bytes(), true, false)
+		if err == ErrDoubleGzip {
+			t.Fatal("did not expect ErrDoubleGzip")
+		}
+	})
+}
+
+// Scratch storage in memory which counts how often it's closed
+type closingScratch struct {
+	bytesReadWriteSeeker
+	closed int
+}
+
+func (s *closingScratch) Close() error {
+	s.closed++
+	return nil
+}
+
+func TestUploadFile(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	var uploaded []byte
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/file", "method": "PUT", "headers": {}}
+			]}`))
+		case r.URL.Path == "/s3/file":
+			uploaded, _ = ioutil.ReadAll(r.Body)
+			w.Header().Set("etag", "fileetag")
+		}
+	})
+	defer ts.Close()
+
+	if err := os.MkdirAll("testdata", 0755); err != nil {
+		t.Fatal(err)
+	}
+	tempDir, err := ioutil.TempDir("testdata", "upload-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	filename := filepath.Join(tempDir, "input.txt")
+	if err := ioutil.WriteFile(filename, []byte("file contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stagingDir := filepath.Join(tempDir, "staging")
+	if err := os.Mkdir(stagingDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	client := New(q)
+	client.AllowInsecure = true
+	client.TempDir = stagingDir
+
+	t.Run("uploads the file and removes the staging file", func(t *testing.T) {
+		err := client.UploadFile(fakeTaskID, "0", "public/input.txt", filename, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(uploaded) != "file contents" {
+			t.Errorf("unexpected upload %q", uploaded)
+		}
+		staged, err := ioutil.ReadDir(stagingDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(staged) != 0 {
+			t.Errorf("expected staging directory to be empty, found %d files", len(staged))
+		}
+	})
+
+	t.Run("stages the upload in storage from the scratch factory", func(t *testing.T) {
+		uploaded = nil
+		scratch := &closingScratch{}
+		client.SetScratchFactory(func() (ReadWriteSeekCloser, error) {
+			return scratch, nil
+		})
+		defer client.SetScratchFactory(nil)
+
+		err := client.UploadFile(fakeTaskID, "0", "public/input.txt", filename, true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scratch.closed != 1 {
+			t.Errorf("expected the scratch storage to be closed once, got %d", scratch.closed)
+		}
+		if len(scratch.Bytes()) == 0 || !bytes.Equal(uploaded, scratch.Bytes()) {
+			t.Errorf("expected the upload to be staged in the scratch storage")
+		}
+		staged, err := ioutil.ReadDir(stagingDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(staged) != 0 {
+			t.Errorf("did not expect files in the staging directory, found %d", len(staged))
+		}
+	})
+
+	t.Run("fails fast when the scratch factory fails", func(t *testing.T) {
+		uploaded = nil
+		client.SetScratchFactory(func() (ReadWriteSeekCloser, error) {
+			return nil, fmt.Errorf("no scratch storage left")
+		})
+		defer client.SetScratchFactory(nil)
+
+		err := client.UploadFile(fakeTaskID, "0", "public/input.txt", filename, false, false)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if uploaded != nil {
+			t.Error("did not expect anything to be uploaded")
+		}
+	})
+
+	t.Run("fails fast for a missing temporary directory", func(t *testing.T) {
+		uploaded = nil
+		client.TempDir = filepath.Join(tempDir, "missing")
+		defer func() { client.TempDir = stagingDir }()
+
+		err := client.UploadFile(fakeTaskID, "0", "public/input.txt", filename, false, false)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if uploaded != nil {
+			t.Error("did not expect anything to be uploaded")
+		}
+	})
+}
+
+func TestInternalSizes(t *testing.T) {
+	client := New(nil)
+
+	if cz, ps := client.GetInternalSizes(); cz != DefaultChunkSize || ps != DefaultPartSize {
+		t.Fatalf("expected default sizes %d and %d, got %d and %d", DefaultChunkSize, DefaultPartSize, cz, ps)
+	}
+
+	if err := client.SetInternalSizes(64*1024, 10*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	if cz, ps := client.GetInternalSizes(); cz != 64*1024 || ps != 10*1024*1024 {
+		t.Fatalf("expected sizes to be set, got %d and %d", cz, ps)
+	}
+
+	for _, sizes := range [][2]int{{64 * 1024, 1024 * 1024}, {512, 10 * 1024 * 1024}, {100 * 1024, 10 * 1024 * 1024}} {
+		if err := client.SetInternalSizes(sizes[0], sizes[1]); err == nil {
+			t.Errorf("expected chunk size %d and part size %d to be rejected", sizes[0], sizes[1])
+		} else if err.(artifactError).SuperError() != ErrBadSize {
+			t.Errorf("expected ErrBadSize to be the cause of %v", err)
+		}
+	}
+}
+
+func TestPreparedUploadValidation(t *testing.T) {
+	partSize := int64(5 * 1024 * 1024)
+	hash := sha256.Sum256([]byte("hash"))
+	h := hash[:]
+
+	identity := func(parts ...int64) PreparedUpload {
+		p := PreparedUpload{
+			ContentSha256:   h,
+			TransferSha256:  h,
+			ContentEncoding: "identity",
+		}
+		for _, size := range parts {
+			p.Parts = append(p.Parts, PreparedPart{Sha256: h, Size: size})
+			p.ContentLength += size
+			p.TransferLength += size
+		}
+		return p
+	}
+
+	t.Run("accepts single part uploads", func(t *testing.T) {
+		p := identity()
+		p.ContentLength, p.TransferLength = 10, 10
+		u, err := p.upload(partSize, sha256.Size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Parts != nil || u.TransferSize != 10 {
+			t.Fatalf("unexpected upload %s", u)
+		}
+	})
+
+	t.Run("accepts aligned parts", func(t *testing.T) {
+		u, err := identity(partSize, partSize, 1024).upload(partSize, sha256.Size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(u.Parts) != 3 || u.Parts[2].Start != 2*partSize {
+			t.Fatalf("unexpected parts %s", u)
+		}
+	})
+
+	invalid := map[string]PreparedUpload{
+		"unaligned parts":   identity(partSize, 1024, partSize),
+		"oversized parts":   identity(partSize+1, 1024),
+		"empty parts":       identity(partSize, 0),
+		"mismatched length": func() PreparedUpload { p := identity(partSize, 1024); p.TransferLength++; p.ContentLength++; return p }(),
+		"short hash":        func() PreparedUpload { p := identity(partSize); p.Parts[0].Sha256 = h[:5]; return p }(),
+		"unknown encoding":  func() PreparedUpload { p := identity(partSize); p.ContentEncoding = "br"; return p }(),
+		"identity mismatch": func() PreparedUpload { p := identity(partSize); p.ContentLength++; return p }(),
+	}
+
+	for name, p := range invalid {
+		if _, err := p.upload(partSize, sha256.Size); err == nil {
+			t.Errorf("expected %s to be rejected", name)
+		}
+	}
+}
+
 func TestInterface(t *testing.T) {
 
 	// We need a task specific taskcluster-client-go Queue
@@ -207,6 +2194,42 @@ func TestInterface(t *testing.T) {
 		})
 	})
 
+	t.Run("upload-bytes", func(t *testing.T) {
+		err = client.UploadBytes(taskID, runID, "public/bytes.json", []byte(`{"hello": "world"}`), true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var output bytes.Buffer
+		err = client.Download(taskID, runID, "public/bytes.json", &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if output.String() != `{"hello": "world"}` {
+			t.Fatalf("unexpected artifact contents %s", output.String())
+		}
+	})
+
+	t.Run("upload-blob-concurrently", func(t *testing.T) {
+		if err = client.SetUploadConcurrency(4); err != nil {
+			t.Fatal(err)
+		}
+		defer client.SetUploadConcurrency(1)
+
+		input := createInput(25) // 25MB
+		output, err := ioutil.TempFile("testdata", ".scratch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(output.Name())
+
+		err = client.Upload(taskID, runID, "public/multipart-concurrent", input, output, false, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
 	t.Run("download-blob", func(t *testing.T) {
 		var output bytes.Buffer
 		err := client.Download(taskID, runID, "public/single-part-gzip", &output)
@@ -223,3 +2246,125 @@ func TestInterface(t *testing.T) {
 		}
 	})
 }
+
+func TestConnectionPool(t *testing.T) {
+	client := New(nil)
+
+	transportPool := func() ConnectionPool {
+		tr := client.agent.transport
+		return ConnectionPool{tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout}
+	}
+
+	t.Run("per host limit follows the upload concurrency", func(t *testing.T) {
+		if err := client.SetUploadConcurrency(16); err != nil {
+			t.Fatal(err)
+		}
+		defer client.SetUploadConcurrency(1)
+
+		expected := ConnectionPool{16, 16, 30 * time.Second}
+		if pool := client.GetConnectionPool(); pool != expected {
+			t.Fatalf("expected pool %+v, got %+v", expected, pool)
+		}
+		if pool := transportPool(); pool != expected {
+			t.Fatalf("expected transport pool %+v, got %+v", expected, pool)
+		}
+		blind := client.clientForBlindRedirects.Transport.(*http.Transport)
+		if blind.MaxIdleConnsPerHost != 16 {
+			t.Fatalf("expected blind redirect transport to keep 16 idle connections per host, got %d", blind.MaxIdleConnsPerHost)
+		}
+	})
+
+	t.Run("configured pool is used as is", func(t *testing.T) {
+		expected := ConnectionPool{50, 4, time.Minute}
+		if err := client.SetConnectionPool(&expected); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.SetUploadConcurrency(8); err != nil {
+			t.Fatal(err)
+		}
+		if pool := transportPool(); pool != expected {
+			t.Fatalf("expected transport pool %+v, got %+v", expected, pool)
+		}
+
+		if err := client.SetConnectionPool(nil); err != nil {
+			t.Fatal(err)
+		}
+		if pool := transportPool(); pool.MaxIdleConnsPerHost != 8 {
+			t.Fatalf("expected the default pool to keep 8 idle connections per host, got %+v", pool)
+		}
+	})
+
+	t.Run("invalid pools", func(t *testing.T) {
+		for _, pool := range []ConnectionPool{{-1, 0, 0}, {0, -1, 0}, {0, 0, -time.Second}} {
+			if err := client.SetConnectionPool(&pool); err == nil {
+				t.Errorf("expected an error for pool %+v", pool)
+			}
+		}
+	})
+}
+
+// A growingInput is an input which is appended to after its size is taken,
+// like a log which is still being written
+type growingInput struct {
+	*bytes.Reader
+	initialSize int64
+}
+
+func (g *growingInput) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekEnd {
+		return g.Reader.Seek(g.initialSize+offset, io.SeekStart)
+	}
+	return g.Reader.Seek(offset, whence)
+}
+
+func TestGrowingInput(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	initial := []byte("log lines written before the upload\n")
+	appended := []byte("log lines written during the upload\n")
+
+	var uploaded []byte
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/log", "method": "PUT", "headers": {}}
+			]}`))
+		case r.URL.Path == "/s3/log":
+			uploaded, _ = ioutil.ReadAll(r.Body)
+			w.Header().Set("etag", "logetag")
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	input := func() io.ReadSeeker {
+		return &growingInput{bytes.NewReader(append(append([]byte{}, initial...), appended...)), int64(len(initial))}
+	}
+
+	for _, gzip := range []bool{false, true} {
+		t.Run(fmt.Sprintf("changed input gzip=%t", gzip), func(t *testing.T) {
+			err := client.Upload(fakeTaskID, "0", "public/log", input(), &bytesReadWriteSeeker{}, gzip, false)
+			if err != ErrFileChanged {
+				t.Fatalf("expected ErrFileChanged, got %v", err)
+			}
+		})
+	}
+
+	t.Run("snapshot of input", func(t *testing.T) {
+		client.SnapshotInputSize = true
+		defer func() { client.SnapshotInputSize = false }()
+
+		uploaded = nil
+		if err := client.Upload(fakeTaskID, "0", "public/log", input(), nil, false, false); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(uploaded, initial) {
+			t.Fatalf("expected only the initial bytes to be uploaded, got %q", uploaded)
+		}
+	})
+}
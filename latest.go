@@ -0,0 +1,37 @@
+package artifact
+
+import (
+	"strconv"
+)
+
+// ResolveLatestRun returns the ID of the latest run of a task, which is the
+// run that the Queue serves the artifacts of "latest" from.  A task which has
+// been rerun has a new latest run, so the run which "latest" resolves to can
+// change between two downloads.  Resolving it first and downloading from that
+// run gives downloads which can be reproduced
+func (c *Client) ResolveLatestRun(taskID string) (string, error) {
+	if err := validateTaskID(taskID); err != nil {
+		return "", err
+	}
+
+	resp, err := c.queue.Status(taskID)
+	if err != nil {
+		return "", queueCallError(err, "fetching status of %s to resolve its latest run", taskID)
+	}
+
+	runs := resp.Status.Runs
+	if len(runs) == 0 {
+		return "", newErrorf(nil, "task %s has no runs, so it has no latest run", taskID)
+	}
+
+	// The Queue lists runs in the order they were created, but the latest run
+	// is the one with the highest ID either way
+	latest := runs[0].RunID
+	for _, run := range runs[1:] {
+		if run.RunID > latest {
+			latest = run.RunID
+		}
+	}
+
+	return strconv.FormatInt(latest, 10), nil
+}
@@ -0,0 +1,95 @@
+package artifact
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveLatestRun(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	content := map[string][]byte{
+		"0": []byte("artifact of the first run"),
+		"2": []byte("artifact of the rerun"),
+	}
+	runs := `[{"runId": 0, "state": "failed"}, {"runId": 2, "state": "completed"}, {"runId": 1, "state": "exception"}]`
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/task/"+fakeTaskID+"/status":
+			w.Write([]byte(`{"status": {"taskId": "` + fakeTaskID + `", "runs": ` + runs + `}}`))
+		case strings.HasPrefix(r.URL.Path, "/task/"+fakeTaskID+"/runs/"):
+			runID := strings.Split(r.URL.Path, "/")[4]
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/blob/"+runID)
+			w.WriteHeader(303)
+		case strings.HasPrefix(r.URL.Path, "/task/"+fakeTaskID+"/artifacts/"):
+			// The Queue's own latest run, which was created after the run
+			// which was resolved
+			w.WriteHeader(404)
+		case strings.HasPrefix(r.URL.Path, "/blob/"):
+			data := content[strings.TrimPrefix(r.URL.Path, "/blob/")]
+			w.Header().Set("x-amz-meta-content-length", sl(data))
+			w.Header().Set("x-amz-meta-content-sha256", hb(data))
+			w.Write(data)
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	t.Run("highest run", func(t *testing.T) {
+		runID, err := client.ResolveLatestRun(fakeTaskID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runID != "2" {
+			t.Fatalf("expected run 2, got %s", runID)
+		}
+	})
+
+	t.Run("download from the pinned run", func(t *testing.T) {
+		client.PinLatestRun = true
+		defer func() { client.PinLatestRun = false }()
+
+		var output bytes.Buffer
+		result, err := client.DownloadLatestWithResult(fakeTaskID, "public/pinned", &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.RunID != "2" || !bytes.Equal(output.Bytes(), content["2"]) {
+			t.Fatalf("expected the artifact of run 2, got run %q with %q", result.RunID, output.Bytes())
+		}
+	})
+
+	t.Run("download without pinning", func(t *testing.T) {
+		var output bytes.Buffer
+		result, err := client.DownloadLatestWithResult(fakeTaskID, "public/pinned", &output)
+		if err == nil {
+			t.Fatal("expected the Queue's latest run to be downloaded from")
+		}
+		if result.RunID != "" {
+			t.Fatalf("expected no run, got %q", result.RunID)
+		}
+	})
+
+	t.Run("task without runs", func(t *testing.T) {
+		runs = `[]`
+		if _, err := client.ResolveLatestRun(fakeTaskID); err == nil {
+			t.Fatal("expected an error for a task without runs")
+		}
+	})
+
+	t.Run("invalid taskID", func(t *testing.T) {
+		if _, err := client.ResolveLatestRun("not a slugid"); err == nil {
+			t.Fatal("expected an error for an invalid taskID")
+		}
+	})
+}
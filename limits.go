@@ -0,0 +1,45 @@
+package artifact
+
+// These are the limits S3 places on uploads
+const (
+	// MaxSinglePartSize is the largest single part upload
+	MaxSinglePartSize int64 = 5 * 1024 * 1024 * 1024
+	// MaxMultipartSize is the largest multipart upload
+	MaxMultipartSize int64 = 5 * 1024 * 1024 * 1024 * 1024
+	// MaxPartCount is the largest number of parts in a multipart upload
+	MaxPartCount = 10000
+)
+
+// Check that an upload of size bytes fits within the limits S3 places on
+// uploads.  For multipart uploads, the number of parts of partSize bytes needed
+// must not be larger than MaxPartCount.  The error returned has ErrBadSize as
+// its cause
+func checkUploadSize(size int64, multipart bool, partSize int64) error {
+	if !multipart {
+		if size > MaxSinglePartSize {
+			return newErrorf(ErrBadSize, "%d bytes is larger than the single part upload maximum of %d bytes, use a multipart upload", size, MaxSinglePartSize)
+		}
+		return nil
+	}
+
+	if size > MaxMultipartSize {
+		return newErrorf(ErrBadSize, "%d bytes is larger than the multipart upload maximum of %d bytes", size, MaxMultipartSize)
+	}
+
+	if parts := (size + partSize - 1) / partSize; parts > MaxPartCount {
+		minPartSize := (size + MaxPartCount - 1) / MaxPartCount
+		return newErrorf(ErrBadSize, "%d bytes in parts of %d bytes needs %d parts, more than the maximum of %d, use a part size of at least %d bytes", size, partSize, parts, MaxPartCount, minPartSize)
+	}
+
+	return nil
+}
+
+// Check that an artifact with size bytes of content is no larger than the
+// Client's MaxArtifactSize, logging what was too large when it is
+func (c *Client) checkMaxArtifactSize(size int64, what string) error {
+	if c.MaxArtifactSize > 0 && size > c.MaxArtifactSize {
+		logger.Printf("%s is %d bytes, more than the maximum artifact size of %d bytes", what, size, c.MaxArtifactSize)
+		return ErrTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"io"
+	"strings"
+	"testing"
+)
+
+// A sizedReadSeeker has a size, but no content.  It's enough to check the
+// size of an upload without preparing it
+type sizedReadSeeker struct {
+	size int64
+}
+
+func (s sizedReadSeeker) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (s sizedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekEnd {
+		return s.size + offset, nil
+	}
+	return offset, nil
+}
+
+func TestCheckUploadSize(t *testing.T) {
+	partSize := int64(DefaultPartSize)
+
+	sizes := []struct {
+		size      int64
+		multipart bool
+		valid     bool
+	}{
+		{0, false, true},
+		{MaxSinglePartSize, false, true},
+		{MaxSinglePartSize + 1, false, false},
+		{partSize * MaxPartCount, true, true},
+		{partSize*MaxPartCount + 1, true, false},
+		{MaxMultipartSize + 1, true, false},
+	}
+
+	for _, tt := range sizes {
+		err := checkUploadSize(tt.size, tt.multipart, partSize)
+		if tt.valid && err != nil {
+			t.Errorf("expected %d bytes with multipart=%t to be valid, got %v", tt.size, tt.multipart, err)
+		}
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("expected %d bytes with multipart=%t to be invalid", tt.size, tt.multipart)
+			} else if err.(artifactError).SuperError() != ErrBadSize {
+				t.Errorf("expected ErrBadSize to be the cause of %v", err)
+			}
+		}
+	}
+
+	t.Run("upload suggests a larger part size", func(t *testing.T) {
+		client := New(nil)
+		var output bytesReadWriteSeeker
+
+		err := client.Upload(fakeTaskID, "0", "public/huge", sizedReadSeeker{partSize*MaxPartCount + 1}, &output, false, true)
+		if err == nil || !strings.Contains(err.Error(), "use a part size of at least") {
+			t.Fatalf("expected part count error, got %v", err)
+		}
+	})
+}
+
+func TestMaxArtifactSize(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	// Without a queue, any network call would fail, so these check that large
+	// artifacts are rejected before anything is sent
+	client := New(nil)
+	client.MaxArtifactSize = 1024
+
+	t.Run("upload", func(t *testing.T) {
+		var output bytesReadWriteSeeker
+		if err := client.Upload(fakeTaskID, "0", "public/runaway.log", sizedReadSeeker{1025}, &output, true, false); err != ErrTooLarge {
+			t.Fatalf("expected ErrTooLarge, got %v", err)
+		}
+		if len(output.Bytes()) != 0 {
+			t.Fatalf("expected nothing to be prepared, got %d bytes", len(output.Bytes()))
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		data := strings.Repeat("a", 1025)
+		hash := sha256.Sum256([]byte(data))
+		if err := client.UploadStream(fakeTaskID, "0", "public/runaway.log", strings.NewReader(data), int64(len(data)), hash[:], ""); err != ErrTooLarge {
+			t.Fatalf("expected ErrTooLarge, got %v", err)
+		}
+	})
+
+	t.Run("plan", func(t *testing.T) {
+		for size, fits := range map[int64]bool{1024: true, 1025: false} {
+			plan, err := client.PlanUpload(size, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan.Fits != fits {
+				t.Errorf("expected an upload of %d bytes to fit: %t", size, fits)
+			}
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package artifact
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// An errorRecordingWriter passes writes through to another io.Writer and
+// records the first error it returns.  When io.Copy fails, this tells us
+// whether it was writing the output or reading the input which failed
+type errorRecordingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errorRecordingWriter) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// Determine whether an error writing to a local output is one which retrying
+// won't fix, like a full disk or a read-only filesystem.  Other write errors
+// might be transient, so they're still worth retrying
+func isPermanentWriteError(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	if err == os.ErrClosed {
+		return true
+	}
+	switch err {
+	case syscall.ENOSPC, syscall.EDQUOT, syscall.EFBIG, syscall.EROFS, syscall.EACCES, syscall.EPERM, syscall.EBADF:
+		return true
+	}
+	return false
+}
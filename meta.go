@@ -0,0 +1,157 @@
+package artifact
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArtifactMeta describes an artifact as it is stored, from the headers of the
+// response which its content was requested with
+type ArtifactMeta struct {
+	// StorageType is the storage type of the artifact, if it was determined
+	StorageType string
+	// ContentType is the content type the artifact is served with
+	ContentType string
+	// ContentLength is the length of the artifact's content after reversing
+	// its content-encoding, or -1 when it isn't known.  Blob artifacts always
+	// have a known length.  Other storage types only do when they are served
+	// without a content-encoding and with a Content-Length header
+	ContentLength int64
+	// ContentEncoding is the content-encoding of the stored artifact.  Unless
+	// a download was raw, its output contains the decoded content.  Downloads
+	// of blob artifacts report the encoding which was observed, like gzip, or
+	// identity when the artifact isn't encoded
+	ContentEncoding string
+	// ContentSha256 is the hex encoded SHA256 of the artifact's content after
+	// reversing its content-encoding, or empty when it isn't known.  Only blob
+	// artifacts have one
+	ContentSha256 string
+}
+
+// Determine the metadata of an artifact from the headers of the response to a
+// request for its content
+func newArtifactMeta(storageType string, header http.Header) ArtifactMeta {
+	meta := ArtifactMeta{StorageType: storageType, ContentLength: -1}
+	if header == nil {
+		return meta
+	}
+
+	meta.ContentType = header.Get("content-type")
+	meta.ContentEncoding = header.Get("content-encoding")
+	meta.ContentSha256 = header.Get("x-amz-meta-content-sha256")
+
+	// The Queue stores the length of a blob artifact's decoded content, while
+	// the Content-Length header is that of what's transfered, so it can only
+	// be used when the content isn't encoded
+	length := header.Get("x-amz-meta-content-length")
+	if encodings, err := parseContentEncoding(meta.ContentEncoding); length == "" && err == nil && len(encodings) == 0 {
+		length = header.Get("content-length")
+	}
+	if length != "" {
+		if i, err := strconv.ParseInt(length, 10, 64); err == nil && i >= 0 {
+			meta.ContentLength = i
+		}
+	}
+
+	return meta
+}
+
+// Head returns the metadata of the named artifact from a specific run of a
+// task without downloading it.  After the request to the Queue, the artifact
+// is requested with a range of only its first byte, since the signed URLs of
+// blob artifacts are only valid for GET requests.  This is useful for showing
+// the sizes of artifacts before deciding which to download.  Error artifacts
+// return their metadata along with ErrErr
+func (c *Client) Head(taskID, runID, name string) (ArtifactMeta, error) {
+	if err := validateArtifact(taskID, runID, name); err != nil {
+		return ArtifactMeta{}, err
+	}
+
+	u, err := c.queue.GetArtifact_SignedURL(taskID, runID, name, time.Duration(3)*time.Hour)
+	if err != nil {
+		return ArtifactMeta{}, newErrorf(err, "creating signed URL for %s/%s/%s", taskID, runID, name)
+	}
+
+	var redirectBuf bytes.Buffer
+
+	cs, storageType, err := c.runRedirect(u.String(), &redirectBuf)
+	meta := newArtifactMeta(storageType, nil)
+	if err != nil {
+		return meta, err
+	}
+
+	if storageType == "error" {
+		return meta, ErrErr
+	}
+
+	location := cs.ResponseHeader.Get("Location")
+	if location == "" {
+		return meta, ErrBadRedirect
+	}
+
+	resourceURL, err := url.Parse(location)
+	if err != nil {
+		return meta, newErrorf(err, "parsing Location header value %s for %s/%s/%s", location, taskID, runID, name)
+	}
+
+	if !c.AllowInsecure && resourceURL.Scheme != "https" {
+		return meta, ErrHTTPS
+	}
+
+	header, err := c.headRange(location)
+	if err != nil {
+		return meta, err
+	}
+
+	return newArtifactMeta(storageType, header), nil
+}
+
+// Request the first byte of the resource at location, returning the response
+// headers with a Content-Length of the whole resource, when it's known.  The
+// ranged request fails for empty resources, so those are requested in full
+func (c *Client) headRange(location string) (http.Header, error) {
+	for _, ranged := range []bool{true, false} {
+		req, err := http.NewRequest("GET", location, nil)
+		if err != nil {
+			return nil, newErrorf(err, "creating request for %s", location)
+		}
+		if c.UserAgent != "" {
+			req.Header.Set("User-Agent", c.UserAgent)
+		}
+		if ranged {
+			req.Header.Set("Range", "bytes=0-0")
+		}
+
+		resp, err := c.clientForBlindRedirects.Do(req)
+		if err != nil {
+			return nil, newErrorf(err, "fetching %s", location)
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && ranged:
+			continue
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return nil, ErrUnauthorized
+		case resp.StatusCode >= 300:
+			return nil, newErrorf(nil, "fetching %s failed with status %d", location, resp.StatusCode)
+		case resp.StatusCode == http.StatusPartialContent:
+			// The Content-Length of a ranged response is the length of the
+			// range, and the whole length is after the slash of its Content-Range
+			resp.Header.Del("Content-Length")
+			contentRange := resp.Header.Get("Content-Range")
+			if i := strings.LastIndex(contentRange, "/"); i != -1 && contentRange[i+1:] != "*" {
+				resp.Header.Set("Content-Length", contentRange[i+1:])
+			}
+		}
+
+		return resp.Header, nil
+	}
+
+	// The unranged request always returns
+	return nil, newErrorf(nil, "fetching %s", location)
+}
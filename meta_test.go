@@ -0,0 +1,132 @@
+package artifact
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestArtifactMeta(t *testing.T) {
+	header := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		expected ArtifactMeta
+	}{
+		{"no response", nil, ArtifactMeta{StorageType: "blob", ContentLength: -1}},
+		{
+			"blob artifact",
+			header("content-type", "text/plain", "x-amz-meta-content-length", "10", "content-length", "10", "x-amz-meta-content-sha256", emptySha256),
+			ArtifactMeta{StorageType: "blob", ContentType: "text/plain", ContentLength: 10, ContentSha256: emptySha256},
+		},
+		{
+			"gzip encoded blob artifact",
+			header("content-encoding", "gzip", "x-amz-meta-content-length", "100", "content-length", "20"),
+			ArtifactMeta{StorageType: "blob", ContentLength: 100, ContentEncoding: "gzip"},
+		},
+		{
+			"encoded response without a content length",
+			header("content-encoding", "gzip", "content-length", "20"),
+			ArtifactMeta{StorageType: "blob", ContentLength: -1, ContentEncoding: "gzip"},
+		},
+		{
+			"identity response with a content length",
+			header("content-type", "text/html", "content-length", "20"),
+			ArtifactMeta{StorageType: "blob", ContentType: "text/html", ContentLength: 20},
+		},
+		{
+			"invalid content length",
+			header("x-amz-meta-content-length", "lots"),
+			ArtifactMeta{StorageType: "blob", ContentLength: -1},
+		},
+	}
+
+	for _, tt := range tests {
+		if actual := newArtifactMeta("blob", tt.header); actual != tt.expected {
+			t.Errorf("%s: expected %#v, got %#v", tt.name, tt.expected, actual)
+		}
+	}
+}
+
+func TestHead(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	content := []byte("artifact which is not downloaded")
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/artifacts/error"):
+			w.Header().Set("x-taskcluster-artifact-storage-type", "error")
+			w.WriteHeader(424)
+		case strings.HasSuffix(r.URL.Path, "/artifacts/reference"):
+			w.Header().Set("x-taskcluster-artifact-storage-type", "reference")
+			w.Header().Set("location", ts.URL+"/s3/reference")
+			w.WriteHeader(303)
+		case strings.HasPrefix(r.URL.Path, "/task/"):
+			name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/s3/"+name)
+			w.WriteHeader(303)
+		case r.URL.Path == "/s3/blob":
+			if r.Header.Get("Range") != "bytes=0-0" {
+				t.Errorf("expected a ranged request, got range %q", r.Header.Get("Range"))
+			}
+			w.Header().Set("content-type", "text/plain")
+			w.Header().Set("content-encoding", "gzip")
+			w.Header().Set("x-amz-meta-content-length", sl(content))
+			w.Header().Set("content-range", "bytes 0-0/12")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte{0x1f})
+		case r.URL.Path == "/s3/empty":
+			if r.Header.Get("Range") != "" {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			w.Header().Set("x-amz-meta-content-length", "0")
+			w.Header().Set("x-amz-meta-content-sha256", emptySha256)
+		case r.URL.Path == "/s3/reference":
+			// Ranges are optional, so this ignores it
+			w.Header().Set("content-type", "application/json")
+			w.Write(content)
+		case r.URL.Path == "/s3/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	tests := []struct {
+		name     string
+		expected ArtifactMeta
+		err      error
+	}{
+		{"blob", ArtifactMeta{"blob", "text/plain", int64(len(content)), "gzip", ""}, nil},
+		{"empty", ArtifactMeta{StorageType: "blob", ContentSha256: emptySha256}, nil},
+		{"reference", ArtifactMeta{"reference", "application/json", int64(len(content)), "", ""}, nil},
+		{"error", ArtifactMeta{StorageType: "error", ContentLength: -1}, ErrErr},
+		{"forbidden", ArtifactMeta{StorageType: "blob", ContentLength: -1}, ErrUnauthorized},
+	}
+
+	for _, tt := range tests {
+		meta, err := client.Head(fakeTaskID, "0", tt.name)
+		if err != tt.err {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+		}
+		if meta != tt.expected {
+			t.Errorf("%s: expected %#v, got %#v", tt.name, tt.expected, meta)
+		}
+	}
+}
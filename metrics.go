@@ -0,0 +1,48 @@
+package artifact
+
+import "time"
+
+// Metrics receives measurements of the requests this library makes, so that
+// they can be reported to a metrics backend like Prometheus or StatsD.  A
+// Client reports to a no-op implementation unless one is set with SetMetrics.
+// Methods may be called concurrently when uploading parts concurrently
+type Metrics interface {
+	// ObserveUpload is called after each request body, such as a single part
+	// of a multipart upload, has been uploaded successfully
+	ObserveUpload(bytes int64, dur time.Duration)
+	// ObserveDownload is called after each response body has been downloaded
+	// successfully.  The number of bytes is the number transfered, which is
+	// the compressed size of gzip encoded artifacts
+	ObserveDownload(bytes int64, dur time.Duration)
+	// IncRetry is called for each request or Queue call which failed with a
+	// retryable error
+	IncRetry()
+	// IncCorrupt is called for each response which failed verification
+	IncCorrupt()
+}
+
+// noopMetrics discards all measurements
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveUpload(bytes int64, dur time.Duration)   {}
+func (noopMetrics) ObserveDownload(bytes int64, dur time.Duration) {}
+func (noopMetrics) IncRetry()                                      {}
+func (noopMetrics) IncCorrupt()                                    {}
+
+// Report the outcome of a request run by the agent to the metrics hook.  The
+// request body length is passed separately from the callSummary because the
+// callSummary's is recorded before the body is sent
+func (c client) observe(upload bool, requestLength, responseLength int64, retryable bool, err error, dur time.Duration) {
+	switch {
+	case err == ErrCorrupt:
+		c.metrics.IncCorrupt()
+	case err != nil:
+	case upload:
+		c.metrics.ObserveUpload(requestLength, dur)
+	default:
+		c.metrics.ObserveDownload(responseLength, dur)
+	}
+	if err != nil && retryable {
+		c.metrics.IncRetry()
+	}
+}
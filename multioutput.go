@@ -0,0 +1,36 @@
+package artifact
+
+import (
+	"io"
+	"strings"
+)
+
+// A multiOutput writes a download to several outputs at once, so that an
+// artifact which is needed in more than one place is only downloaded and
+// verified once.  Like io.MultiWriter, a write fails as soon as one of the
+// outputs fails.  The outputs are kept so that each of them can be checked
+// before the download and have an error response discarded from it
+type multiOutput struct {
+	outputs []io.Writer
+	w       io.Writer
+}
+
+func newMultiOutput(outputs []io.Writer) *multiOutput {
+	return &multiOutput{
+		outputs: outputs,
+		w:       io.MultiWriter(outputs...),
+	}
+}
+
+func (m *multiOutput) Write(p []byte) (int, error) {
+	return m.w.Write(p)
+}
+
+// Name the outputs in log messages
+func (m *multiOutput) Name() string {
+	names := make([]string, len(m.outputs))
+	for i, output := range m.outputs {
+		names[i] = findName(output)
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
@@ -0,0 +1,88 @@
+package artifact
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMultiOutputDownload(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	data := []byte("downloaded once, written twice")
+	blobRequests := 0
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/task/"):
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/blob"+strings.TrimPrefix(r.URL.Path, "/task/"+fakeTaskID+"/runs/0/artifacts"))
+			w.WriteHeader(303)
+		case r.URL.Path == "/blob/public/intact":
+			blobRequests++
+			w.Header().Set("x-amz-meta-content-length", sl(data))
+			w.Header().Set("x-amz-meta-content-sha256", hb(data))
+			w.Write(data)
+		case r.URL.Path == "/blob/public/corrupt":
+			blobRequests++
+			w.Header().Set("x-amz-meta-content-length", sl(data))
+			w.Header().Set("x-amz-meta-content-sha256", hb([]byte("something else")))
+			w.Write(data)
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	t.Run("writes to every output", func(t *testing.T) {
+		blobRequests = 0
+		file, err := ioutil.TempFile("", "multi-output")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		var buf bytes.Buffer
+		if err := client.Download(fakeTaskID, "0", "public/intact", &buf, file); err != nil {
+			t.Fatal(err)
+		}
+		if blobRequests != 1 {
+			t.Errorf("expected the artifact to be downloaded once, got %d requests", blobRequests)
+		}
+		written, err := ioutil.ReadFile(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) || !bytes.Equal(written, data) {
+			t.Fatalf("expected %q in both outputs, got %q and %q", data, buf.Bytes(), written)
+		}
+	})
+
+	t.Run("checks every output is empty", func(t *testing.T) {
+		blobRequests = 0
+		var empty bytes.Buffer
+		nonEmpty := &bytesReadWriteSeeker{buf: []byte("stale")}
+		if err := client.Download(fakeTaskID, "0", "public/intact", &empty, nonEmpty); err != ErrBadOutputWriter {
+			t.Fatalf("expected ErrBadOutputWriter, got %v", err)
+		}
+		if blobRequests != 0 || empty.Len() != 0 {
+			t.Fatal("did not expect anything to be downloaded")
+		}
+	})
+
+	t.Run("verifies once for every output", func(t *testing.T) {
+		var first, second bytes.Buffer
+		if err := client.Download(fakeTaskID, "0", "public/corrupt", &first, &second); err != ErrCorrupt {
+			t.Fatalf("expected ErrCorrupt, got %v", err)
+		}
+	})
+}
@@ -1,5 +1,10 @@
 package artifact
 
+import (
+	"bytes"
+	"fmt"
+)
+
 type namer interface {
 	Name() string
 }
@@ -8,11 +13,24 @@ type namer interface {
 // io.Reader/io.Writer's which are files to format down to a string instead of
 // needing this function
 // Maybe also do this for requests
+//
+// Things which have a name, like an *os.File, are described by it.  In memory
+// readers and writers don't have names, so they're described by their type and
+// size.  Anything else is described by its type so that error messages at
+// least say what kind of input or output was involved
 func findName(n interface{}) string {
 	switch v := n.(type) {
 	case namer:
 		return v.Name()
+	case *bytes.Reader:
+		return fmt.Sprintf("<%T of %d bytes>", v, v.Size())
+	case *bytes.Buffer:
+		return fmt.Sprintf("<%T of %d bytes>", v, v.Len())
+	case *bytesReadWriteSeeker:
+		return fmt.Sprintf("<in memory output of %d bytes>", len(v.Bytes()))
+	case nil:
+		return "<nil>"
 	default:
-		return "<unnamed>"
+		return fmt.Sprintf("<unnamed %T>", v)
 	}
 }
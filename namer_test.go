@@ -0,0 +1,36 @@
+package artifact
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFindName(t *testing.T) {
+	f, err := ioutil.TempFile("", "findname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	names := []struct {
+		n        interface{}
+		expected string
+	}{
+		{f, f.Name()},
+		{bytes.NewReader([]byte("1234")), "<*bytes.Reader of 4 bytes>"},
+		{bytes.NewBufferString("123"), "<*bytes.Buffer of 3 bytes>"},
+		{&bytesReadWriteSeeker{buf: []byte("12")}, "<in memory output of 2 bytes>"},
+		{strings.NewReader("1"), "<unnamed *strings.Reader>"},
+		{nil, "<nil>"},
+	}
+
+	for _, tt := range names {
+		if actual := findName(tt.n); actual != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, actual)
+		}
+	}
+}
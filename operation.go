@@ -0,0 +1,49 @@
+package artifact
+
+import (
+	"context"
+)
+
+// Begin an operation, like an upload or a download, which must be finished
+// within the OperationTimeout and is stopped when the Client's Context is
+// done.  The Client returned is a copy whose requests are aborted once the
+// operation is stopped, and which is used for the whole operation.  The
+// artifact, as taskID/runID/name, prefixes the log messages of its requests
+// and is empty when the operation isn't for a named artifact.  The function
+// returned must be called once the operation is over to release the timer.
+// No operation is started while the Client's circuit breaker is open
+func (c *Client) startOperation(artifact string) (*Client, func(), error) {
+	if err := c.circuit.allow(); err != nil {
+		return nil, nil, err
+	}
+	if c.OperationTimeout <= 0 && c.Context == nil && artifact == "" {
+		return c, func() {}, nil
+	}
+	op := *c
+	op.agent.artifact = artifact
+	op.agent.operation = c.Context
+	if c.OperationTimeout <= 0 {
+		return &op, func() {}, nil
+	}
+	parent := c.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, c.OperationTimeout)
+	op.agent.operation = ctx
+	return &op, cancel, nil
+}
+
+// Determine the error to return from an operation.  Once the operation has
+// timed out or been cancelled, whatever failed because of that is reported as
+// ErrTimeout or ErrCancelled
+func (c *Client) operationError(err error) error {
+	if err == nil || err == ErrTimeout || err == ErrCancelled {
+		return err
+	}
+	if stopErr := c.agent.stopped(); stopErr != nil {
+		c.agent.logf("operation stopped with %v: %v", stopErr, err)
+		return stopErr
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package artifact
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOperationTimeout(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	data := []byte("an artifact which takes too long")
+
+	// Closed once the test is over, so that handlers which are still waiting
+	// return before the server is closed
+	release := make(chan struct{})
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/slow", "method": "PUT", "headers": {}}
+			]}`))
+		case r.Method == "PUT" && r.URL.Path == "/s3/slow":
+			<-release
+		case strings.HasPrefix(r.URL.Path, "/task/"):
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/s3/slow")
+			w.WriteHeader(303)
+		case r.URL.Path == "/s3/slow":
+			w.Header().Set("x-amz-meta-content-length", sl(data))
+			w.Header().Set("x-amz-meta-content-sha256", hb(data))
+			w.Write(data[:5])
+			w.(http.Flusher).Flush()
+			<-release
+			w.Write(data[5:])
+		}
+	})
+	defer ts.Close()
+	defer close(release)
+
+	client := New(q)
+	client.AllowInsecure = true
+	client.OperationTimeout = 100 * time.Millisecond
+
+	t.Run("upload", func(t *testing.T) {
+		start := time.Now()
+		if err := client.UploadBytes(fakeTaskID, "0", "public/slow", data, false, false); err != ErrTimeout {
+			t.Fatalf("expected ErrTimeout, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("upload took %s to time out", elapsed)
+		}
+	})
+
+	t.Run("download", func(t *testing.T) {
+		var output bytes.Buffer
+		result, err := client.DownloadWithResult(fakeTaskID, "0", "public/slow", &output)
+		if err != ErrTimeout {
+			t.Fatalf("expected ErrTimeout, got %v", err)
+		}
+		if result.BytesWritten != int64(output.Len()) {
+			t.Fatalf("expected the result to describe the %d bytes written, got %d", output.Len(), result.BytesWritten)
+		}
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		start := time.Now()
+		if _, err := client.Verify(fakeTaskID, "0", "public/slow"); err != ErrTimeout {
+			t.Fatalf("expected ErrTimeout, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("verify took %s to time out", elapsed)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancellable := New(q)
+		cancellable.AllowInsecure = true
+		cancellable.Context = ctx
+
+		time.AfterFunc(100*time.Millisecond, cancel)
+
+		var output bytes.Buffer
+		if _, err := cancellable.DownloadWithResult(fakeTaskID, "0", "public/slow", &output); err != ErrCancelled {
+			t.Fatalf("expected ErrCancelled, got %v", err)
+		}
+
+		// Once cancelled, uploads stop before their artifact is created
+		if err := cancellable.UploadBytes(fakeTaskID, "0", "public/slow", data, false, false); err != ErrCancelled {
+			t.Fatalf("expected ErrCancelled, got %v", err)
+		}
+	})
+
+	t.Run("cancelled while retrying the Queue", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		calls := 0
+		failing, failingServer := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			cancel()
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		defer failingServer.Close()
+
+		cancellable := New(failing)
+		cancellable.Context = ctx
+		cancellable.RetryDelay = 10 * time.Second
+
+		start := time.Now()
+		if err := cancellable.UploadBytes(fakeTaskID, "0", "public/slow", data, false, false); err != ErrCancelled {
+			t.Fatalf("expected ErrCancelled, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("upload took %s to be cancelled", elapsed)
+		}
+		if calls != 1 {
+			t.Fatalf("expected no retries once cancelled, got %d calls", calls)
+		}
+	})
+
+	t.Run("no timeout", func(t *testing.T) {
+		fast := New(q)
+		fast.AllowInsecure = true
+		op, done, err := fast.startOperation("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer done()
+		if op != fast || op.agent.operation != nil {
+			t.Fatal("expected operations without a timeout to use the client as is")
+		}
+	})
+}
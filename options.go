@@ -0,0 +1,120 @@
+package artifact
+
+import (
+	"time"
+)
+
+// An Option configures a Client created with New.  Each option does what one
+// of the Client's setters or fields does, and is applied after all of the
+// options have been given, so the order they're given in doesn't matter
+type Option func(*options)
+
+// The options given to New.  Each is nil unless it was given, so that zero
+// values are checked like the setters check them instead of being ignored
+type options struct {
+	chunkSize      *int
+	partSize       *int
+	concurrency    *int
+	expires        *time.Time
+	http2          *HTTP2Mode
+	circuitBreaker *CircuitBreaker
+}
+
+// WithChunkSize sets the chunk size, as SetInternalSizes does.  The part size
+// stays the same unless it's set with WithPartSize
+func WithChunkSize(size int) Option {
+	return func(o *options) {
+		o.chunkSize = &size
+	}
+}
+
+// WithPartSize sets the part size of multipart uploads, as SetInternalSizes
+// does.  The chunk size stays the same unless it's set with WithChunkSize
+func WithPartSize(size int) Option {
+	return func(o *options) {
+		o.partSize = &size
+	}
+}
+
+// WithConcurrency sets the number of parts of a multipart upload which are
+// uploaded at the same time, as SetUploadConcurrency does
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = &n
+	}
+}
+
+// WithExpires sets when artifacts created by the Client expire, as the
+// Expires field does
+func WithExpires(expires time.Time) Option {
+	return func(o *options) {
+		o.expires = &expires
+	}
+}
+
+// WithHTTP2 sets whether uploads and downloads are made with HTTP/2, as
+// SetHTTP2 does
+func WithHTTP2(mode HTTP2Mode) Option {
+	return func(o *options) {
+		o.http2 = &mode
+	}
+}
+
+// WithCircuitBreaker sets when the Client stops starting uploads and
+// downloads because its requests keep failing, as SetCircuitBreaker does
+func WithCircuitBreaker(cb CircuitBreaker) Option {
+	return func(o *options) {
+		o.circuitBreaker = &cb
+	}
+}
+
+// Apply options to a newly created Client
+func (c *Client) applyOptions(opts []Option) error {
+	if len(opts) == 0 {
+		return nil
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// Both sizes are set together, since the part size must be a multiple of
+	// the chunk size
+	if o.chunkSize != nil || o.partSize != nil {
+		chunkSize, partSize := c.GetInternalSizes()
+		if o.chunkSize != nil {
+			chunkSize = *o.chunkSize
+		}
+		if o.partSize != nil {
+			partSize = *o.partSize
+		}
+		if err := c.SetInternalSizes(chunkSize, partSize); err != nil {
+			return err
+		}
+	}
+
+	if o.concurrency != nil {
+		if err := c.SetUploadConcurrency(*o.concurrency); err != nil {
+			return err
+		}
+	}
+
+	if o.expires != nil {
+		c.Expires = *o.expires
+	}
+
+	if o.http2 != nil {
+		if err := c.SetHTTP2(*o.http2); err != nil {
+			return err
+		}
+	}
+
+	if o.circuitBreaker != nil {
+		if err := c.SetCircuitBreaker(o.circuitBreaker); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
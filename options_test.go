@@ -0,0 +1,81 @@
+package artifact
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	t.Run("defaults without options", func(t *testing.T) {
+		client := New(nil)
+		if cs, ps := client.GetInternalSizes(); cs != DefaultChunkSize || ps != DefaultPartSize {
+			t.Fatalf("expected default sizes, got %d and %d", cs, ps)
+		}
+		if client.GetUploadConcurrency() != 1 || !client.Expires.IsZero() || client.GetHTTP2() != HTTP2Auto || client.GetCircuitBreaker() != nil {
+			t.Fatal("expected the defaults")
+		}
+	})
+
+	t.Run("every option", func(t *testing.T) {
+		expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		cb := CircuitBreaker{Window: 10, FailureRate: 0.5, Cooldown: time.Minute}
+
+		// The part size isn't a multiple of the default chunk size, so the
+		// sizes must be applied together
+		client := New(nil,
+			WithPartSize(6*1000*1000),
+			WithChunkSize(3000),
+			WithConcurrency(4),
+			WithExpires(expires),
+			WithHTTP2(HTTP2Disabled),
+			WithCircuitBreaker(cb),
+		)
+
+		if cs, ps := client.GetInternalSizes(); cs != 3000 || ps != 6*1000*1000 {
+			t.Errorf("expected sizes 3000 and 6000000, got %d and %d", cs, ps)
+		}
+		if n := client.GetUploadConcurrency(); n != 4 {
+			t.Errorf("expected concurrency 4, got %d", n)
+		}
+		if !client.Expires.Equal(expires) {
+			t.Errorf("expected expiry %s, got %s", expires, client.Expires)
+		}
+		if mode := client.GetHTTP2(); mode != HTTP2Disabled {
+			t.Errorf("expected HTTP/2 to be disabled, got mode %d", mode)
+		}
+		if got := client.GetCircuitBreaker(); got == nil || *got != cb {
+			t.Errorf("expected circuit breaker %#v, got %#v", cb, got)
+		}
+	})
+
+	t.Run("returns an invalid option's error", func(t *testing.T) {
+		invalid := []Option{
+			WithPartSize(1024),
+			WithChunkSize(0),
+			WithPartSize(0),
+			WithConcurrency(0),
+			WithHTTP2(HTTP2Mode(42)),
+			WithCircuitBreaker(CircuitBreaker{}),
+		}
+		for i, opt := range invalid {
+			client, err := NewWithOptions(nil, opt)
+			if err == nil || client != nil {
+				t.Errorf("expected invalid option %d to be rejected", i)
+			}
+		}
+
+		if _, err := NewWithOptions(nil, WithConcurrency(2)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("panics for an invalid option", func(t *testing.T) {
+		defer func() {
+			err, ok := recover().(artifactError)
+			if !ok || err.SuperError() != ErrBadSize {
+				t.Fatalf("expected a panic caused by ErrBadSize, got %v", err)
+			}
+		}()
+		New(nil, WithPartSize(1024))
+	})
+}
@@ -0,0 +1,47 @@
+package artifact
+
+// UploadPlan describes how an upload would be split into parts, without
+// preparing it
+type UploadPlan struct {
+	// TransferSize is the number of bytes which would be uploaded.  The size of
+	// a gzip encoded upload can't be known without compressing the input, so
+	// the input's size is used as an estimate
+	TransferSize int64
+	// Parts contains the size of each part, in order.  Single part uploads and
+	// empty uploads have a single part
+	Parts []int64
+	// Fits is true when the upload is within the limits S3 places on uploads
+	// and the Client's MaxArtifactSize.  Upload rejects uploads which don't
+	// fit
+	Fits bool
+}
+
+// PlanUpload describes how an input of size bytes would be uploaded by Upload
+// with this Client's part size.  Nothing is read or sent
+func (c *Client) PlanUpload(size int64, gzip, multipart bool) (UploadPlan, error) {
+	if size < 0 {
+		return UploadPlan{}, newErrorf(ErrBadSize, "cannot plan upload of %d bytes", size)
+	}
+
+	partSize := int64(c.chunkSize * c.multipartPartChunkCount)
+
+	plan := UploadPlan{
+		TransferSize: size,
+		Fits:         checkUploadSize(size, multipart, partSize) == nil && (c.MaxArtifactSize <= 0 || size <= c.MaxArtifactSize),
+	}
+
+	// Like Upload, an empty multipart upload is sent as a single part
+	if !multipart || size == 0 {
+		plan.Parts = []int64{size}
+		return plan, nil
+	}
+
+	count := (size + partSize - 1) / partSize
+	plan.Parts = make([]int64, count)
+	for i := range plan.Parts {
+		plan.Parts[i] = partSize
+	}
+	plan.Parts[count-1] = size - partSize*(count-1)
+
+	return plan, nil
+}
@@ -0,0 +1,53 @@
+package artifact
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanUpload(t *testing.T) {
+	client := New(nil)
+	if err := client.SetInternalSizes(1024*1024, 5*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	mb := int64(1024 * 1024)
+
+	plans := []struct {
+		size      int64
+		multipart bool
+		expected  UploadPlan
+	}{
+		{0, false, UploadPlan{0, []int64{0}, true}},
+		{0, true, UploadPlan{0, []int64{0}, true}},
+		{12 * mb, false, UploadPlan{12 * mb, []int64{12 * mb}, true}},
+		{12 * mb, true, UploadPlan{12 * mb, []int64{5 * mb, 5 * mb, 2 * mb}, true}},
+		{10 * mb, true, UploadPlan{10 * mb, []int64{5 * mb, 5 * mb}, true}},
+		{MaxSinglePartSize + 1, false, UploadPlan{MaxSinglePartSize + 1, []int64{MaxSinglePartSize + 1}, false}},
+	}
+
+	for _, tt := range plans {
+		plan, err := client.PlanUpload(tt.size, false, tt.multipart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(plan, tt.expected) {
+			t.Errorf("for %d bytes with multipart=%t, expected %v, got %v", tt.size, tt.multipart, tt.expected, plan)
+		}
+	}
+
+	t.Run("too many parts", func(t *testing.T) {
+		plan, err := client.PlanUpload(5*mb*MaxPartCount+1, true, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Fits || len(plan.Parts) != MaxPartCount+1 {
+			t.Errorf("expected %d parts which don't fit, got %d parts and fits=%t", MaxPartCount+1, len(plan.Parts), plan.Fits)
+		}
+	})
+
+	t.Run("negative size", func(t *testing.T) {
+		if _, err := client.PlanUpload(-1, false, false); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -3,12 +3,13 @@ package artifact
 import (
 	"bytes"
 	gziplib "compress/gzip"
-	"crypto/sha256"
 	"fmt"
+	"hash"
 	"io"
 	"math"
+	"runtime"
 	"strings"
-	"time"
+	"sync"
 )
 
 // Part is a description of a single part of a multipart upload
@@ -16,11 +17,13 @@ type part struct {
 	Sha256 []byte
 	Size   int64
 	Start  int64
+	CRC32C uint32
+	MD5    []byte
 }
 
 // Part should implement the Stringer interface
 func (u part) String() string {
-	return fmt.Sprintf("Sha256: %x, Start: %d, Size: %d", u.Sha256, u.Start, u.Size)
+	return fmt.Sprintf("Sha256: %x, Start: %d, Size: %d, CRC32C: %08x, MD5: %x", u.Sha256, u.Start, u.Size, u.CRC32C, u.MD5)
 }
 
 // Upload contains information relevant to program internals about the upload
@@ -30,6 +33,8 @@ type upload struct {
 	Size            int64
 	TransferSha256  []byte
 	TransferSize    int64
+	TransferCRC32C  uint32
+	TransferMD5     []byte
 	ContentEncoding string
 	Parts           []part
 }
@@ -55,15 +60,28 @@ func (u upload) String() string {
 // for which the parts were calculated.  It is a defect in calling code to not
 // compared the []byte return value to that of the the file which is expected
 // to be read.  Comparison can be made with bytes.Equal()
-func hashFileParts(input io.ReadSeeker, size int64, chunkSize, chunksInPart int) ([]part, []byte, error) {
+//
+// When the input is also an io.ReaderAt, as an *os.File is, the parts are
+// hashed concurrently while the overall hash is calculated in a single
+// streaming pass.  Otherwise, everything is hashed in a single pass.  The MD5
+// of each part is only calculated when contentMD5 is set
+func hashFileParts(input io.ReadSeeker, size int64, chunkSize, chunksInPart int, newHash func() hash.Hash, contentMD5 bool) ([]part, []byte, error) {
 	if _, err := input.Seek(0, io.SeekStart); err != nil {
 		return []part{}, []byte{}, newErrorf(err, "failed to seek input %s", findName(input))
 	}
 
-	hash := sha256.New()
-	partHash := sha256.New()
+	if ra, ok := input.(io.ReaderAt); ok {
+		return hashFilePartsAt(input, ra, size, chunkSize, chunksInPart, newHash, contentMD5)
+	}
+
+	hash := newHash()
+	partHash := newHash()
+	partCRC := newCRC32C()
+	partMD5 := newContentMD5(contentMD5)
 
-	buf := make([]byte, chunkSize)
+	bufp := getBuffer(chunkSize)
+	defer putBuffer(bufp)
+	buf := *bufp
 
 	// We need to keep track of which part we're currently working in
 	currentPart := 0
@@ -83,24 +101,29 @@ func hashFileParts(input io.ReadSeeker, size int64, chunkSize, chunksInPart int)
 	parts := make([]part, totalParts)
 
 	for {
-		nBytes, err := input.Read(buf)
+		// Parts are counted in chunks, so every chunk but the last must be
+		// full.  An io.Reader may return fewer bytes than were asked for, so
+		// we must keep reading until the buffer is full or the input ends
+		nBytes, err := io.ReadFull(input, buf)
+
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return []part{}, []byte{}, newErrorf(err, "reading from %s", findName(input))
+		}
 
 		if nBytes == 0 {
 			if currentPartSize > 0 {
-				parts[currentPart] = part{partHash.Sum(nil), currentPartSize, int64(currentPart) * partSize}
+				parts[currentPart] = part{partHash.Sum(nil), currentPartSize, int64(currentPart) * partSize, partCRC.Sum32(), partMD5.Sum(nil)}
 			}
 			break
 		}
 
-		if err != nil {
-			return []part{}, []byte{}, newErrorf(err, "reading from %s", findName(input))
-		}
-
 		// The hash.Hash interface docs state that the Write function never
 		// returns an error, so we can ignore errors returned from the two
 		// method invocations below.
 		_, _ = hash.Write(buf[:nBytes])
 		_, _ = partHash.Write(buf[:nBytes])
+		_, _ = partCRC.Write(buf[:nBytes])
+		_, _ = partMD5.Write(buf[:nBytes])
 
 		currentPartSize += int64(nBytes)
 
@@ -108,8 +131,10 @@ func hashFileParts(input io.ReadSeeker, size int64, chunkSize, chunksInPart int)
 		// if we're in the last chunk of the part
 		if currentPartChunk == (chunksInPart - 1) {
 			// If we're in the last chunk, we should set the part information
-			parts[currentPart] = part{partHash.Sum(nil), currentPartSize, int64(currentPart) * partSize}
+			parts[currentPart] = part{partHash.Sum(nil), currentPartSize, int64(currentPart) * partSize, partCRC.Sum32(), partMD5.Sum(nil)}
 			partHash.Reset()
+			partCRC.Reset()
+			partMD5.Reset()
 			currentPartChunk = 0
 			currentPart++
 			currentPartSize = 0
@@ -123,35 +148,231 @@ func hashFileParts(input io.ReadSeeker, size int64, chunkSize, chunksInPart int)
 	return parts, hash.Sum(nil), nil
 }
 
+// A partHashingWriter calculates the hash of each part of the bytes written
+// to it, as they are written, so that the parts of a multipart upload can be
+// determined in the same pass as the rest of the upload's information.  The
+// hash of a part is reset at each part boundary, like in hashFileParts
+type partHashingWriter struct {
+	partSize        int64
+	partHash        hash.Hash
+	partCRC         hash.Hash32
+	partMD5         hash.Hash
+	currentPartSize int64
+	parts           []part
+	offset          int64
+}
+
+func newPartHashingWriter(partSize int64, newHash func() hash.Hash, contentMD5 bool) *partHashingWriter {
+	return &partHashingWriter{
+		partSize: partSize,
+		partHash: newHash(),
+		partCRC:  newCRC32C(),
+		partMD5:  newContentMD5(contentMD5),
+		parts:    []part{},
+	}
+}
+
+func (w *partHashingWriter) Write(p []byte) (int, error) {
+	nBytes := len(p)
+	// A single write can be split across a part boundary, so we write only up
+	// to the end of the current part each time through this loop
+	for len(p) > 0 {
+		n := w.partSize - w.currentPartSize
+		if int64(len(p)) < n {
+			n = int64(len(p))
+		}
+		// The hash.Hash interface docs state that the Write function never
+		// returns an error
+		_, _ = w.partHash.Write(p[:n])
+		_, _ = w.partCRC.Write(p[:n])
+		_, _ = w.partMD5.Write(p[:n])
+		w.currentPartSize += n
+		p = p[n:]
+		if w.currentPartSize == w.partSize {
+			w.finishPart()
+		}
+	}
+	return nBytes, nil
+}
+
+func (w *partHashingWriter) finishPart() {
+	w.parts = append(w.parts, part{w.partHash.Sum(nil), w.currentPartSize, w.offset, w.partCRC.Sum32(), w.partMD5.Sum(nil)})
+	w.offset += w.currentPartSize
+	w.currentPartSize = 0
+	w.partHash.Reset()
+	w.partCRC.Reset()
+	w.partMD5.Reset()
+}
+
+// Complete the final part, if needed, and return all of the parts
+func (w *partHashingWriter) finish() []part {
+	if w.currentPartSize > 0 {
+		w.finishPart()
+	}
+	return w.parts
+}
+
+// Hash the parts of the input using a pool of workers, each of which reads its
+// part with an io.SectionReader so that no worker moves the offset used by
+// another.  The overall hash is calculated by reading the input through its
+// io.Reader interface at the same time.  This is done instead of combining the
+// part hashes so that, like the single pass version, a file which has changed
+// size since the parts were planned results in a hash which doesn't match
+func hashFilePartsAt(input io.Reader, ra io.ReaderAt, size int64, chunkSize, chunksInPart int, newHash func() hash.Hash, contentMD5 bool) ([]part, []byte, error) {
+	partSize := int64(chunkSize * chunksInPart)
+	totalParts := int(math.Ceil(float64(size) / float64(partSize)))
+
+	parts := make([]part, totalParts)
+
+	// We only want to record the first error, since the others are likely to
+	// be caused by the same thing
+	var firstErr error
+	var errOnce sync.Once
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+		})
+	}
+
+	var wg sync.WaitGroup
+
+	hash := newHash()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		bufp := getBuffer(chunkSize)
+		defer putBuffer(bufp)
+		if _, err := io.CopyBuffer(hash, input, *bufp); err != nil {
+			setErr(newErrorf(err, "reading from %s", findName(input)))
+		}
+	}()
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > totalParts {
+		workers = totalParts
+	}
+
+	jobs := make(chan int)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bufp := getBuffer(chunkSize)
+			defer putBuffer(bufp)
+			buf := *bufp
+			partHash := newHash()
+			partCRC := newCRC32C()
+			partMD5 := newContentMD5(contentMD5)
+			for i := range jobs {
+				start := int64(i) * partSize
+				currentPartSize := partSize
+				if start+currentPartSize > size {
+					currentPartSize = size - start
+				}
+
+				partHash.Reset()
+				partCRC.Reset()
+				partMD5.Reset()
+				nBytes, err := io.CopyBuffer(io.MultiWriter(partHash, partCRC, partMD5), io.NewSectionReader(ra, start, currentPartSize), buf)
+				if err != nil {
+					setErr(newErrorf(err, "reading part %d from %s", i, findName(input)))
+					continue
+				}
+				if nBytes != currentPartSize {
+					setErr(newErrorf(nil, "read %d bytes of part %d from %s, expected %d", nBytes, i, findName(input), currentPartSize))
+					continue
+				}
+
+				parts[i] = part{partHash.Sum(nil), currentPartSize, start, partCRC.Sum32(), partMD5.Sum(nil)}
+			}
+		}()
+	}
+
+	for i := 0; i < totalParts; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return []part{}, []byte{}, firstErr
+	}
+
+	return parts, hash.Sum(nil), nil
+}
+
+// Prepare a multipart upload without gzip encoding which is uploaded straight
+// from an input which is also an io.ReaderAt, so nothing is written to an
+// output.  The parts are hashed concurrently from their offsets of the input
+// while the whole input is hashed and counted in a single streaming pass, so
+// an input which changes size while it's hashed is caught by the caller
+// comparing the size of the upload to the size it expected.  The CRC32C of
+// the whole input is only sent for single part uploads, which an empty input
+// is the only multipart upload to become, so it isn't calculated, and neither
+// is its MD5
+func readerAtMultipartUpload(input io.ReadSeeker, ra io.ReaderAt, size int64, chunkSize, chunksInPart int, newHash func() hash.Hash, contentMD5 bool) (upload, error) {
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return upload{}, newErrorf(err, "failed to seek input %s", findName(input))
+	}
+
+	partSize := chunkSize * chunksInPart
+
+	if partSize < 1024*1024*5 {
+		return upload{}, newErrorf(nil, "partsize must be at least 5 MB, not %d", partSize)
+	}
+
+	counter := &byteCountingWriter{0}
+	parts, hash, err := hashFilePartsAt(io.TeeReader(input, counter), ra, size, chunkSize, chunksInPart, newHash, contentMD5)
+	if err != nil {
+		return upload{}, newErrorf(err, "error hashing file parts of %s", findName(input))
+	}
+
+	return upload{
+		Sha256:          hash,
+		Size:            counter.count,
+		TransferSha256:  hash,
+		TransferSize:    counter.count,
+		ContentEncoding: "identity",
+		Parts:           parts,
+	}, nil
+}
+
 // In order to do an upload of a single-part file, we need to do the following things:
 //   1. determine the input size
-//   2. calculate the input's sha256
+//   2. calculate the input's hash
 //   3. optionally gzip-encode the input
 //   4. write the intput to the output
 //   5. determine the output size
-//   6. calculate the output's sha256
+//   6. calculate the output's hash
 // For both gzip and non-gzip encoded resources, we write from the input to the
 // output.  This is done to ensure that the file which is uploaded is exactly
-// that which was hashed.
+// that which was hashed.  The MD5 of the output is only calculated when
+// contentMD5 is set.
 // Calling code is responsible for cleaning up whatever is written to output
-func singlePartUpload(input io.ReadSeeker, output io.Writer, gzip bool, chunkSize int) (upload, error) {
+func singlePartUpload(input io.ReadSeeker, output io.Writer, gzip bool, chunkSize int, newHash func() hash.Hash, gzipHeader GzipHeader, contentMD5 bool) (upload, error) {
 	if _, err := input.Seek(0, io.SeekStart); err != nil {
 		return upload{}, newErrorf(err, "failed to seek input %s", findName(input))
 	}
 
-	hash := sha256.New()
-	buf := make([]byte, chunkSize)
+	hash := newHash()
+	bufp := getBuffer(chunkSize)
+	defer putBuffer(bufp)
+	buf := *bufp
 
 	// When we're compressing using gzip, we're going to use a more complex copy routine
 	if gzip {
-		transferHash := sha256.New()
+		transferHash := newHash()
+		transferCRC := newCRC32C()
+		transferMD5 := newContentMD5(contentMD5)
 		// Unfortunately, the gzip.Writer doesn't track how many bytes were written
 		// to the underlying io.Writer, so we need to do that
 		transferSize := byteCountingWriter{0}
-		gzipWriter := gziplib.NewWriter(io.MultiWriter(transferHash, output, &transferSize))
+		gzipWriter := gziplib.NewWriter(io.MultiWriter(transferHash, transferCRC, transferMD5, output, &transferSize))
 
 		// We're setting constant headers so that gzip has deterministic output
-		gzipWriter.ModTime = time.Date(2000, time.January, 0, 0, 0, 0, 0, time.UTC)
+		gzipHeader.apply(gzipWriter)
 
 		_output := io.MultiWriter(gzipWriter, hash)
 
@@ -176,12 +397,16 @@ func singlePartUpload(input io.ReadSeeker, output io.Writer, gzip bool, chunkSiz
 			Size:            contentSize,
 			TransferSha256:  transferHash.Sum(nil),
 			TransferSize:    transferSize.count,
+			TransferCRC32C:  transferCRC.Sum32(),
+			TransferMD5:     transferMD5.Sum(nil),
 			ContentEncoding: "gzip",
 		}, nil
 	}
 
 	// Otherwise, identity encoding is drastically simpler
-	_output := io.MultiWriter(output, hash)
+	crc := newCRC32C()
+	md5 := newContentMD5(contentMD5)
+	_output := io.MultiWriter(output, hash, crc, md5)
 
 	totalBytes, err := io.CopyBuffer(_output, input, buf)
 	if err != nil {
@@ -193,6 +418,8 @@ func singlePartUpload(input io.ReadSeeker, output io.Writer, gzip bool, chunkSiz
 		Size:            totalBytes,
 		TransferSha256:  hash.Sum(nil),
 		TransferSize:    totalBytes,
+		TransferCRC32C:  crc.Sum32(),
+		TransferMD5:     md5.Sum(nil),
 		ContentEncoding: "identity",
 	}, nil
 }
@@ -201,7 +428,7 @@ func singlePartUpload(input io.ReadSeeker, output io.Writer, gzip bool, chunkSiz
 // copy/gzip operation from singlePartUpload is broken into parts and hashed.
 // The chunkSize and chunksInParts can be multiplied to determine the part size
 // Calling code is responsible for cleaning up whatever is written to output
-func multipartUpload(input io.ReadSeeker, output io.ReadWriteSeeker, gzip bool, chunkSize, chunksInPart int) (upload, error) {
+func multipartUpload(input io.ReadSeeker, output io.ReadWriteSeeker, gzip bool, chunkSize, chunksInPart int, newHash func() hash.Hash, gzipHeader GzipHeader, contentMD5 bool) (upload, error) {
 
 	// We want to make sure we're at the start of the input
 	if _, err := input.Seek(0, io.SeekStart); err != nil {
@@ -214,8 +441,23 @@ func multipartUpload(input io.ReadSeeker, output io.ReadWriteSeeker, gzip bool,
 		return upload{}, newErrorf(nil, "partsize must be at least 5 MB, not %d", partSize)
 	}
 
-	// First, we'll calculate the SinglePartUpload version of this
-	u, err := singlePartUpload(input, output, gzip, chunkSize)
+	// With identity encoding, the bytes we upload are the bytes we read from
+	// the input, so we can hash the parts while copying the input to the output
+	// instead of reading the output again afterwards
+	if !gzip {
+		phw := newPartHashingWriter(int64(partSize), newHash, contentMD5)
+		u, err := singlePartUpload(input, io.MultiWriter(output, phw), gzip, chunkSize, newHash, gzipHeader, contentMD5)
+		if err != nil {
+			return upload{}, newErrorf(err, "error handling input %s or output %s", findName(input), findName(output))
+		}
+		u.Parts = phw.finish()
+		return u, nil
+	}
+
+	// First, we'll calculate the SinglePartUpload version of this.  The part
+	// boundaries of gzip encoded uploads are in the compressed output, so we
+	// need to read the output a second time to hash them
+	u, err := singlePartUpload(input, output, gzip, chunkSize, newHash, gzipHeader, contentMD5)
 	if err != nil {
 		return upload{}, newErrorf(err, "error handling input %s or output %s", findName(input), findName(output))
 	}
@@ -226,16 +468,70 @@ func multipartUpload(input io.ReadSeeker, output io.ReadWriteSeeker, gzip bool,
 		return upload{}, newErrorf(err, "error seeking output %s back to beginning for multipart upload", findName(output))
 	}
 
-	parts, hash, err := hashFileParts(output, u.TransferSize, chunkSize, chunksInPart)
+	parts, hash, err := hashFileParts(output, u.TransferSize, chunkSize, chunksInPart, newHash, contentMD5)
 	if err != nil {
 		return upload{}, newErrorf(err, "error hasing file parts of %s", findName(output))
 	}
 
 	// We want to protect against the file changing between when we copied it to the new location
 	if !bytes.Equal(hash, u.TransferSha256) {
-		return upload{}, newErrorf(nil, "contents of %s changed while determining part information", findName(output))
+		logger.Printf("contents of %s changed while determining part information", findName(output))
+		return upload{}, ErrFileChanged
 	}
 
 	u.Parts = parts
 	return u, nil
 }
+
+// Prepare an input for upload without uploading it, returning the hashes,
+// sizes, encoding and parts which Upload would create its artifact with.  This
+// makes no requests, so it can be used to compute a manifest of artifacts
+// ahead of time.  Like Upload, the input is copied into output, optionally
+// with gzip encoding, and the output must be empty.  The chunk size is the
+// number of bytes read and written at a time, and a multipart upload has parts
+// of partChunks chunks, which must be at least 5MB.  Hashes are sha256, which
+// is the only hash the Queue accepts, and gzip encoding uses the
+// DefaultGzipHeader.  The result can be uploaded from the output with
+// UploadPrepared by a Client with the same internal sizes
+func Prepare(input io.ReadSeeker, output io.ReadWriteSeeker, gzip, multipart bool, chunkSize, partChunks int) (PreparedUpload, error) {
+	if err := checkInternalSizes(chunkSize, chunkSize*partChunks); err != nil {
+		return PreparedUpload{}, err
+	}
+
+	if output == nil {
+		return PreparedUpload{}, newErrorf(nil, "an output is needed to prepare %s", findName(input))
+	}
+	if outSize, err := output.Seek(0, io.SeekEnd); err != nil {
+		return PreparedUpload{}, newErrorf(err, "seeking output %s to determine its size", findName(output))
+	} else if outSize != 0 {
+		return PreparedUpload{}, ErrBadOutputWriter
+	}
+
+	var u upload
+	var err error
+	if multipart {
+		u, err = multipartUpload(input, output, gzip, chunkSize, partChunks, SHA256.New, DefaultGzipHeader, false)
+	} else {
+		u, err = singlePartUpload(input, output, gzip, chunkSize, SHA256.New, DefaultGzipHeader, false)
+	}
+	if err != nil {
+		return PreparedUpload{}, newErrorf(err, "preparing %s", findName(input))
+	}
+
+	p := PreparedUpload{
+		ContentSha256:   u.Sha256,
+		ContentLength:   u.Size,
+		TransferSha256:  u.TransferSha256,
+		TransferLength:  u.TransferSize,
+		ContentEncoding: u.ContentEncoding,
+	}
+
+	// An empty artifact has no parts, so it's uploaded as a single part
+	if u.TransferSize > 0 {
+		for _, part := range u.Parts {
+			p.Parts = append(p.Parts, PreparedPart{Sha256: part.Sha256, Size: part.Size})
+		}
+	}
+
+	return p, nil
+}
@@ -5,10 +5,13 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"testing"
+	"time"
 )
 
 func fileinfo(t *testing.T, filename string) (int64, []byte) {
@@ -48,7 +51,13 @@ func testUpload(t *testing.T, gzip bool, mp bool, filename string) {
 	}
 	defer os.Remove(output.Name())
 
-	u, err := singlePartUpload(input, output, gzip, chunkSize)
+	var u upload
+	if mp {
+		// 5MB parts, the smallest allowed
+		u, err = multipartUpload(input, output, gzip, chunkSize, 5*1024*1024/chunkSize, sha256.New, DefaultGzipHeader, false)
+	} else {
+		u, err = singlePartUpload(input, output, gzip, chunkSize, sha256.New, DefaultGzipHeader, false)
+	}
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -84,10 +93,32 @@ func testUpload(t *testing.T, gzip bool, mp bool, filename string) {
 		t.Errorf("Output sha256 %x did not match prepared sha256 %x", outputHash, u.Sha256)
 	}
 
+	outputBytes, err := ioutil.ReadFile(output.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crc := crc32.Checksum(outputBytes, crc32cTable); crc != u.TransferCRC32C {
+		t.Errorf("Output crc32c %08x did not match prepared crc32c %08x", crc, u.TransferCRC32C)
+	}
+
 	if mp {
+		if len(u.Parts) < 2 {
+			t.Errorf("Expected more than one part, got %d", len(u.Parts))
+		}
+
+		// The parts are of the bytes which will be uploaded, so we check them
+		// against the output
+		uploaded, err := os.Open(output.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer uploaded.Close()
+
+		var partsSize int64
 		for i, part := range u.Parts {
+			partsSize += part.Size
 			phash := sha256.New()
-			reader := io.NewSectionReader(input, part.Start, part.Size)
+			reader := io.NewSectionReader(uploaded, part.Start, part.Size)
 			partBytes, err := io.Copy(phash, reader)
 			if err != nil {
 				t.Fatal(err)
@@ -100,6 +131,15 @@ func testUpload(t *testing.T, gzip bool, mp bool, filename string) {
 				t.Errorf("Part %d sha256 %d did not match prepared sha256 %d", i, phash.Sum(nil), part.Sha256)
 			}
 
+			partCRC := crc32.Checksum(outputBytes[part.Start:part.Start+part.Size], crc32cTable)
+			if partCRC != part.CRC32C {
+				t.Errorf("Part %d crc32c %08x did not match prepared crc32c %08x", i, partCRC, part.CRC32C)
+			}
+
+		}
+
+		if partsSize != u.TransferSize {
+			t.Errorf("Parts total %d bytes, expected %d", partsSize, u.TransferSize)
 		}
 	}
 }
@@ -144,6 +184,203 @@ func TestUploadPreperation(t *testing.T) {
 	t.Run("singlepart identity", func(t *testing.T) {
 		testUpload(t, false, false, filename)
 	})
+
+	t.Run("concurrent part hashing", func(t *testing.T) {
+		testConcurrentPartHashing(t, filename)
+	})
+
+	t.Run("part hashing with short reads", func(t *testing.T) {
+		testShortReadPartHashing(t, filename)
+	})
+
+	t.Run("multipart identity from an io.ReaderAt", func(t *testing.T) {
+		testReaderAtMultipartUpload(t, filename)
+	})
+}
+
+func TestGzipDeterminism(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	input := make([]byte, 1024*1024)
+	if _, err := rand.Read(input); err != nil {
+		t.Fatal(err)
+	}
+
+	compress := func(header GzipHeader) []byte {
+		var output bytes.Buffer
+		_, err := singlePartUpload(bytes.NewReader(input), &output, true, 128*1024, sha256.New, header, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return output.Bytes()
+	}
+
+	t.Run("same input gives same output", func(t *testing.T) {
+		first := compress(DefaultGzipHeader)
+		second := compress(DefaultGzipHeader)
+		if !bytes.Equal(first, second) {
+			t.Fatal("compressing the same input twice gave different output")
+		}
+	})
+
+	t.Run("default header bytes", func(t *testing.T) {
+		// 1999-12-31T00:00:00Z is 946598400 seconds after the epoch
+		expected := []byte{0x1f, 0x8b, 0x08, 0x00, 0x00, 0xf2, 0x6b, 0x38, 0x00, 0xff}
+		if actual := compress(DefaultGzipHeader)[:10]; !bytes.Equal(actual, expected) {
+			t.Fatalf("expected header % x, got % x", expected, actual)
+		}
+	})
+
+	t.Run("configured header bytes", func(t *testing.T) {
+		header := GzipHeader{
+			ModTime: time.Unix(1, 0),
+			OS:      3,
+			Name:    "a",
+			Comment: "b",
+		}
+		expected := []byte{0x1f, 0x8b, 0x08, 0x18, 0x01, 0x00, 0x00, 0x00, 0x00, 0x03, 'a', 0x00, 'b', 0x00}
+		if actual := compress(header)[:14]; !bytes.Equal(actual, expected) {
+			t.Fatalf("expected header % x, got % x", expected, actual)
+		}
+	})
+}
+
+// onlyReadSeeker hides any other interfaces, like io.ReaderAt, which the
+// wrapped io.ReadSeeker implements
+type onlyReadSeeker struct {
+	io.ReadSeeker
+}
+
+// Ensure that hashing parts concurrently gives the same result as hashing
+// them in a single pass
+func testConcurrentPartHashing(t *testing.T, filename string) {
+	input, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	size, _ := fileinfo(t, filename)
+
+	// 1MB parts, which means we also get a final part which is smaller than
+	// the rest
+	chunkSize := 16 * 1024
+	chunksInPart := 64
+
+	expectedParts, expectedHash, err := hashFileParts(onlyReadSeeker{input}, size, chunkSize, chunksInPart, sha256.New, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts, hash, err := hashFileParts(input, size, chunkSize, chunksInPart, sha256.New, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(hash, expectedHash) {
+		t.Errorf("Overall sha256 %x did not match expected %x", hash, expectedHash)
+	}
+
+	if len(parts) != len(expectedParts) {
+		t.Fatalf("Got %d parts, expected %d", len(parts), len(expectedParts))
+	}
+
+	for i := range parts {
+		if parts[i].String() != expectedParts[i].String() {
+			t.Errorf("Part %d was %s, expected %s", i, parts[i], expectedParts[i])
+		}
+	}
+}
+
+// An identity encoded multipart upload prepared straight from an input which
+// is an io.ReaderAt must describe the same bytes as one staged in an output
+func testReaderAtMultipartUpload(t *testing.T, filename string) {
+	input, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	size, _ := fileinfo(t, filename)
+
+	chunkSize := 128 * 1024
+	chunksInPart := 40
+
+	var output bytesReadWriteSeeker
+	expected, err := multipartUpload(input, &output, false, chunkSize, chunksInPart, sha256.New, GzipHeader{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := readerAtMultipartUpload(input, input, size, chunkSize, chunksInPart, sha256.New, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The CRC32C of the whole input is only used by single part uploads
+	expected.TransferCRC32C = 0
+	if u.String() != expected.String() {
+		t.Errorf("Prepared %s, expected %s", u, expected)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	data := make([]byte, 6*1024*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	partChunks := 5 * 1024 * 1024 / DefaultChunkSize
+
+	for _, tt := range []struct{ gzip, multipart bool }{{false, false}, {true, false}, {false, true}, {true, true}} {
+		name := fmt.Sprintf("gzip=%t multipart=%t", tt.gzip, tt.multipart)
+		var output bytesReadWriteSeeker
+		p, err := Prepare(bytes.NewReader(data), &output, tt.gzip, tt.multipart, DefaultChunkSize, partChunks)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		transfered := output.Bytes()
+		if fmt.Sprintf("%x", p.ContentSha256) != hb(data) || p.ContentLength != int64(len(data)) {
+			t.Errorf("%s: content described as %x %d bytes", name, p.ContentSha256, p.ContentLength)
+		}
+		if fmt.Sprintf("%x", p.TransferSha256) != hb(transfered) || p.TransferLength != int64(len(transfered)) {
+			t.Errorf("%s: transfer described as %x %d bytes, output is %s %d bytes", name, p.TransferSha256, p.TransferLength, hb(transfered), len(transfered))
+		}
+
+		// The result must be accepted by UploadPrepared for a Client with the
+		// same sizes
+		if _, err := p.upload(int64(DefaultChunkSize*partChunks), sha256.Size); err != nil {
+			t.Errorf("%s: prepared upload is invalid: %v", name, err)
+		}
+		if tt.multipart != (len(p.Parts) > 0) {
+			t.Errorf("%s: got %d parts", name, len(p.Parts))
+		}
+	}
+
+	if _, err := Prepare(bytes.NewReader(data), &bytesReadWriteSeeker{}, false, true, DefaultChunkSize, 1); err == nil || err.(artifactError).SuperError() != ErrBadSize {
+		t.Errorf("expected ErrBadSize to be the cause of %v", err)
+	}
+
+	output := &bytesReadWriteSeeker{}
+	output.Write([]byte("stale"))
+	if _, err := Prepare(bytes.NewReader(data), output, false, false, DefaultChunkSize, partChunks); err != ErrBadOutputWriter {
+		t.Errorf("expected ErrBadOutputWriter, got %v", err)
+	}
+
+	// Uploading without an output fails before the Queue is called
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	})
+	defer ts.Close()
+	p, err := Prepare(bytes.NewReader(data), &bytesReadWriteSeeker{}, false, false, DefaultChunkSize, partChunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := New(q).UploadPrepared(fakeTaskID, "0", "public/prepared", p, nil, ""); err == nil {
+		t.Error("expected an error for a nil output")
+	}
 }
 
 func BenchmarkPrepare(b *testing.B) {
@@ -190,7 +427,7 @@ func BenchmarkPrepare(b *testing.B) {
 					defer os.Remove(output.Name())
 
 					b.ResetTimer()
-					singlePartUpload(input, output, gzip, chunkSize)
+					singlePartUpload(input, output, gzip, chunkSize, sha256.New, DefaultGzipHeader, false)
 					b.StopTimer()
 
 				})
@@ -210,7 +447,7 @@ func BenchmarkPrepare(b *testing.B) {
 					defer os.Remove(output.Name())
 
 					b.ResetTimer()
-					multipartUpload(input, output, gzip, chunkSize, 10*1024*1024/chunkSize)
+					multipartUpload(input, output, gzip, chunkSize, 10*1024*1024/chunkSize, sha256.New, DefaultGzipHeader, false)
 					b.StopTimer()
 
 				})
@@ -219,3 +456,56 @@ func BenchmarkPrepare(b *testing.B) {
 	}
 
 }
+
+// shortReadSeeker returns at most n bytes from each read, like a network
+// filesystem or pipe might
+type shortReadSeeker struct {
+	io.ReadSeeker
+	n int
+}
+
+func (s shortReadSeeker) Read(p []byte) (int, error) {
+	if len(p) > s.n {
+		p = p[:s.n]
+	}
+	return s.ReadSeeker.Read(p)
+}
+
+// Ensure that an input which returns less than a chunk from each read still
+// gives the same parts as reading whole chunks
+func testShortReadPartHashing(t *testing.T, filename string) {
+	input, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	size, _ := fileinfo(t, filename)
+
+	chunkSize := 16 * 1024
+	chunksInPart := 64
+
+	expectedParts, expectedHash, err := hashFileParts(input, size, chunkSize, chunksInPart, sha256.New, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts, hash, err := hashFileParts(shortReadSeeker{input, 1000}, size, chunkSize, chunksInPart, sha256.New, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(hash, expectedHash) {
+		t.Errorf("Overall sha256 %x did not match expected %x", hash, expectedHash)
+	}
+
+	if len(parts) != len(expectedParts) {
+		t.Fatalf("Got %d parts, expected %d", len(parts), len(expectedParts))
+	}
+
+	for i := range parts {
+		if parts[i].String() != expectedParts[i].String() {
+			t.Errorf("Part %d was %s, expected %s", i, parts[i], expectedParts[i])
+		}
+	}
+}
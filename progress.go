@@ -0,0 +1,57 @@
+package artifact
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// A transferProgress totals the bytes transfered by the requests of a single
+// upload or download and reports the running total to a progress callback.
+// The parts of a multipart upload share a transferProgress, so add may be
+// called concurrently
+type transferProgress struct {
+	// transfered is accessed atomically, so it's first to ensure 64-bit alignment
+	transfered int64
+	// total is the number of bytes expected, or -1 when it isn't known
+	total  int64
+	report func(transfered, total int64)
+}
+
+func newTransferProgress(total int64, report func(transfered, total int64)) *transferProgress {
+	return &transferProgress{total: total, report: report}
+}
+
+func (p *transferProgress) add(n int) {
+	if n <= 0 {
+		return
+	}
+	p.report(atomic.AddInt64(&p.transfered, int64(n)), p.total)
+}
+
+// Take back n bytes which were added by a request that failed.  They are
+// transfered again if the request is retried, so they would otherwise be
+// counted twice and the progress could pass the total
+func (p *transferProgress) remove(n int64) {
+	if n <= 0 {
+		return
+	}
+	p.report(atomic.AddInt64(&p.transfered, -n), p.total)
+}
+
+// A chunkReader reports each chunk read through it to a callback, with the
+// chunk's offset in the artifact.  The chunk is the slice of the caller's
+// buffer which was read into, so nothing is copied
+type chunkReader struct {
+	r       io.Reader
+	offset  int64
+	onChunk func(offset int64, data []byte)
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.onChunk(c.offset, p[:n])
+		c.offset += int64(n)
+	}
+	return n, err
+}
@@ -0,0 +1,31 @@
+package artifact
+
+import "io"
+
+// A rangeWriter writes to a range of an io.WriterAt, like an *os.File, which
+// starts at offset.  Each write is made at the offset following the bytes
+// written so far instead of seeking, so many rangeWriters can write to
+// different ranges of the same output concurrently.  This is the write side
+// counterpart of a body created with newBodyAt, and is needed to download the
+// ranges of an artifact at the same time
+type rangeWriter struct {
+	wa      io.WriterAt
+	offset  int64
+	written int64
+}
+
+func newRangeWriter(wa io.WriterAt, offset int64) *rangeWriter {
+	return &rangeWriter{wa: wa, offset: offset}
+}
+
+func (r *rangeWriter) Write(p []byte) (int, error) {
+	n, err := r.wa.WriteAt(p, r.offset+r.written)
+	r.written += int64(n)
+	return n, err
+}
+
+// Name describes the underlying io.WriterAt so that error messages mention it
+// instead of the rangeWriter
+func (r *rangeWriter) Name() string {
+	return findName(r.wa)
+}
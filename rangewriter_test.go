@@ -0,0 +1,64 @@
+package artifact
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestRangeWriter(t *testing.T) {
+	if err := os.MkdirAll("testdata", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := ioutil.TempFile("testdata", "range-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(output.Name())
+	defer output.Close()
+
+	content := make([]byte, 64*1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ranges of uneven sizes, each written in small chunks by its own goroutine
+	ranges := []struct{ start, end int64 }{{0, 1000}, {1000, 20000}, {20000, 20001}, {20001, int64(len(content))}}
+
+	var wg sync.WaitGroup
+	writers := make([]*rangeWriter, len(ranges))
+	for i, r := range ranges {
+		writers[i] = newRangeWriter(output, r.start)
+		wg.Add(1)
+		go func(w *rangeWriter, r []byte) {
+			defer wg.Done()
+			if _, err := io.CopyBuffer(w, bytes.NewReader(r), make([]byte, 333)); err != nil {
+				t.Error(err)
+			}
+		}(writers[i], content[r.start:r.end])
+	}
+	wg.Wait()
+
+	for i, r := range ranges {
+		if writers[i].written != r.end-r.start {
+			t.Errorf("range %d: expected %d bytes written, got %d", i, r.end-r.start, writers[i].written)
+		}
+	}
+
+	written, err := ioutil.ReadFile(output.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, content) {
+		t.Fatal("assembled output does not match the content")
+	}
+
+	if name := findName(writers[0]); name != output.Name() {
+		t.Errorf("expected name %s, got %s", output.Name(), name)
+	}
+}
@@ -0,0 +1,85 @@
+package artifact
+
+import (
+	"io"
+)
+
+// A ReadWriteSeekCloser is storage which an upload can be staged in.  See
+// SetScratchFactory
+type ReadWriteSeekCloser interface {
+	io.ReadWriteSeeker
+	io.Closer
+}
+
+// A bytesReadWriteSeeker is an io.ReadWriteSeeker which stores its contents in
+// memory.  It is used as the output of uploads which happen entirely in memory
+// so that small artifacts don't need a file to be created.  It also
+// implements io.ReaderAt so that the parts of a multipart upload can be read
+// from it concurrently.
+type bytesReadWriteSeeker struct {
+	buf []byte
+	pos int64
+}
+
+func (b *bytesReadWriteSeeker) Read(p []byte) (int, error) {
+	if b.pos >= int64(len(b.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.buf[b.pos:])
+	b.pos += int64(n)
+	return n, nil
+}
+
+func (b *bytesReadWriteSeeker) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, newErrorf(nil, "cannot read at negative offset %d", off)
+	}
+	if off >= int64(len(b.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b *bytesReadWriteSeeker) Write(p []byte) (int, error) {
+	end := b.pos + int64(len(p))
+	// If we've seeked past the end, the gap is filled with zeros, like a file
+	if end > int64(len(b.buf)) {
+		if end > int64(cap(b.buf)) {
+			grown := make([]byte, len(b.buf), end*2)
+			copy(grown, b.buf)
+			b.buf = grown
+		}
+		b.buf = b.buf[:end]
+	}
+	n := copy(b.buf[b.pos:], p)
+	b.pos += int64(n)
+	return n, nil
+}
+
+func (b *bytesReadWriteSeeker) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = b.pos + offset
+	case io.SeekEnd:
+		pos = int64(len(b.buf)) + offset
+	default:
+		return 0, newErrorf(nil, "invalid whence %d", whence)
+	}
+	if pos < 0 {
+		return 0, newErrorf(nil, "cannot seek to negative position %d", pos)
+	}
+	b.pos = pos
+	return pos, nil
+}
+
+// Bytes returns the contents written so far
+func (b *bytesReadWriteSeeker) Bytes() []byte {
+	return b.buf
+}
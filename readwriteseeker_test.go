@@ -0,0 +1,103 @@
+package artifact
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBytesReadWriteSeeker(t *testing.T) {
+	t.Run("should read back what was written", func(t *testing.T) {
+		rws := &bytesReadWriteSeeker{}
+		_, err := rws.Write([]byte("hello, "))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = rws.Write([]byte("world"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = rws.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadAll(rws)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != "hello, world" {
+			t.Fatalf("read %q", data)
+		}
+	})
+
+	t.Run("should seek to the end to find the size", func(t *testing.T) {
+		rws := &bytesReadWriteSeeker{}
+		size, err := rws.Seek(0, io.SeekEnd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != 0 {
+			t.Fatalf("expected empty, got %d", size)
+		}
+
+		_, err = rws.Write(make([]byte, 100))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size, err = rws.Seek(0, io.SeekEnd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != 100 {
+			t.Fatalf("expected 100 bytes, got %d", size)
+		}
+	})
+
+	t.Run("should overwrite and zero fill like a file", func(t *testing.T) {
+		rws := &bytesReadWriteSeeker{}
+		rws.Write([]byte("aaaa"))
+		rws.Seek(2, io.SeekStart)
+		rws.Write([]byte("bb"))
+		rws.Seek(2, io.SeekCurrent)
+		rws.Write([]byte("c"))
+
+		if !bytes.Equal(rws.Bytes(), []byte("aabb\x00\x00c")) {
+			t.Fatalf("unexpected contents %q", rws.Bytes())
+		}
+	})
+
+	t.Run("should not seek before the start", func(t *testing.T) {
+		rws := &bytesReadWriteSeeker{}
+		_, err := rws.Seek(-1, io.SeekStart)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("should read at an offset", func(t *testing.T) {
+		rws := &bytesReadWriteSeeker{}
+		rws.Write([]byte("hello, world"))
+
+		buf := make([]byte, 5)
+		n, err := rws.ReadAt(buf, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "world" {
+			t.Fatalf("read %q", buf[:n])
+		}
+
+		n, err = rws.ReadAt(buf, 10)
+		if err != io.EOF {
+			t.Fatalf("expected io.EOF, got %v", err)
+		}
+		if string(buf[:n]) != "ld" {
+			t.Fatalf("read %q", buf[:n])
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package artifact
+
+import (
+	"net/http"
+)
+
+// A RedirectPolicy decides whether redirects of the requests made to download
+// artifact content are followed.  It works like the CheckRedirect
+// function of an http.Client: req is the redirected request about to be made
+// and via are the requests made so far, oldest first.  Returning
+// http.ErrUseLastResponse stops following redirects without an error, while
+// any other error fails the request
+type RedirectPolicy func(req *http.Request, via []*http.Request) error
+
+// SameOriginRedirects returns a RedirectPolicy which follows at most maxHops
+// redirects, as long as each is to the same scheme, host and port as the
+// original request.  Since the scheme must not change, redirects of https
+// requests must also be https.  Other redirects are not followed
+func SameOriginRedirects(maxHops int) RedirectPolicy {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxHops {
+			return http.ErrUseLastResponse
+		}
+		origin := via[0].URL
+		if req.URL.Scheme != origin.Scheme || req.URL.Host != origin.Host {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+}
+
+// SetRedirectPolicy sets the policy for following redirects of the requests
+// which download artifact content.  By default, and after setting a nil
+// policy, no redirects are followed.  The Queue's redirects to where an
+// artifact is stored are handled by this library, so they are never followed
+// regardless of the policy.  Uploads are never redirected either, since
+// following a redirect could turn them into GET requests.  This should not be
+// called while the Client is in use
+func (c *Client) SetRedirectPolicy(policy RedirectPolicy) {
+	c.redirectPolicy = policy
+}
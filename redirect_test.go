@@ -0,0 +1,85 @@
+package artifact
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedirectPolicy(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	content := []byte("artifact behind an S3 redirect")
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/task/"):
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/s3/moved")
+			w.WriteHeader(303)
+		case r.URL.Path == "/s3/moved":
+			w.Header().Set("location", ts.URL+"/s3/region")
+			w.WriteHeader(307)
+		case r.URL.Path == "/s3/region":
+			w.Header().Set("x-amz-meta-content-length", sl(content))
+			w.Header().Set("x-amz-meta-content-sha256", hb(content))
+			w.Write(content)
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	defer ts.Close()
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("did not expect a request to another origin")
+	}))
+	defer other.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	t.Run("redirects are not followed by default", func(t *testing.T) {
+		var output bytes.Buffer
+		if err := client.Download(fakeTaskID, "0", "public/moved", &output); err == nil {
+			t.Fatal("expected the redirected download to fail")
+		}
+	})
+
+	client.SetRedirectPolicy(SameOriginRedirects(2))
+	defer client.SetRedirectPolicy(nil)
+
+	t.Run("same origin redirects are followed", func(t *testing.T) {
+		var output bytes.Buffer
+		if err := client.Download(fakeTaskID, "0", "public/moved", &output); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(output.Bytes(), content) {
+			t.Fatalf("expected %q, got %q", content, output.Bytes())
+		}
+	})
+
+	t.Run("other origins are not followed", func(t *testing.T) {
+		policy := SameOriginRedirects(2)
+		origin, _ := http.NewRequest("GET", ts.URL+"/s3/moved", nil)
+		redirected, _ := http.NewRequest("GET", other.URL+"/s3/region", nil)
+		if err := policy(redirected, []*http.Request{origin}); err != http.ErrUseLastResponse {
+			t.Fatalf("expected redirect to another origin to be refused, got %v", err)
+		}
+	})
+
+	t.Run("hops are limited", func(t *testing.T) {
+		policy := SameOriginRedirects(1)
+		origin, _ := http.NewRequest("GET", ts.URL+"/a", nil)
+		hop, _ := http.NewRequest("GET", ts.URL+"/b", nil)
+		redirected, _ := http.NewRequest("GET", ts.URL+"/c", nil)
+		if err := policy(hop, []*http.Request{origin}); err != nil {
+			t.Fatalf("expected first hop to be followed, got %v", err)
+		}
+		if err := policy(redirected, []*http.Request{origin, hop}); err != http.ErrUseLastResponse {
+			t.Fatalf("expected second hop to be refused, got %v", err)
+		}
+	})
+}
@@ -2,8 +2,10 @@ package artifact
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
-	"crypto/sha256"
+	"compress/zlib"
+	"context"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -11,6 +13,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -49,13 +52,82 @@ func (r request) String() string {
 type client struct {
 	transport *http.Transport
 	client    *http.Client
+	// The value of the User-Agent header to set on requests which do not
+	// already have one.  When empty, no header is added
+	userAgent string
+	// If no bytes of a request or response body are transfered for this long,
+	// the request is aborted.  When zero, requests never time out
+	requestTimeout time.Duration
+	// If fewer than minThroughput bytes per second are transfered during a
+	// throughputWindow, the request is aborted.  When zero, there is no minimum
+	minThroughput    int64
+	throughputWindow time.Duration
+	// The hash algorithm used to hash request and response bodies and to find
+	// the headers which contain the expected hashes of verified responses
+	hashAlgorithm HashAlgorithm
+	// The clock which the Date of responses is compared with
+	clock clock
+	// When raw is set, response bodies are written to the output exactly as
+	// they were transfered, without reversing any content-encoding.  Only the
+	// transfer length and hash of verified responses are checked
+	raw bool
+	// Measurements of each request are reported to metrics
+	metrics Metrics
+	// When set, the bytes of request and response bodies are reported to
+	// these as they are transfered
+	uploadProgress   *transferProgress
+	downloadProgress *transferProgress
+	// When set, each chunk of the body of a request which has one, or else of
+	// the response, is reported to this as it is transfered.  The offset of
+	// the first chunk is chunkOffset, which is where the body starts in the
+	// artifact
+	onChunk     func(offset int64, data []byte)
+	chunkOffset int64
+	// The trace ID sent in the TraceIDHeader of requests which do not already
+	// have one.  When empty, each request gets a new trace ID
+	traceID string
+	// When set, redirects of requests without a body are followed according
+	// to this policy instead of the http.Client's, which follows none
+	redirectPolicy RedirectPolicy
+	// When set, requests are aborted once this is done and return ErrTimeout
+	// or ErrCancelled.  It's the context of the whole operation which a
+	// request is part of
+	operation context.Context
+	// The artifact which requests are for, as taskID/runID/name.  When set,
+	// it prefixes log messages about requests, so that the messages of
+	// operations which run concurrently can be told apart
+	artifact string
 }
 
-// TODO: We might want to do a couple things here instead of just disabling
-// redirects altogether.  Since it's possible that S3 does redirect us, we
-// might want to do a couple checks like for HTTPS, same origin, etc, and then
-// follow the redirect, but for now let's ensure that the URLs that the Queue
-// gives us aren't redirecting
+// Log a message about a request, prefixed with the artifact it's for
+func (c client) logf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if c.artifact != "" {
+		msg = c.artifact + ": " + msg
+	}
+	_ = logger.Output(2, msg)
+}
+
+// Determine whether the operation which a request is part of has been
+// stopped, returning ErrTimeout if it ran out of time or ErrCancelled if the
+// Client's Context was cancelled
+func (c client) stopped() error {
+	if c.operation == nil {
+		return nil
+	}
+	switch c.operation.Err() {
+	case context.DeadlineExceeded:
+		return ErrTimeout
+	case context.Canceled:
+		return ErrCancelled
+	}
+	return nil
+}
+
+// By default, we ensure that the URLs that the Queue gives us aren't
+// redirecting by not following any redirects.  Since it's possible that S3
+// does redirect us, a Client can be given a less strict RedirectPolicy, like
+// SameOriginRedirects, with SetRedirectPolicy
 func checkRedirect(req *http.Request, via []*http.Request) error {
 	return http.ErrUseLastResponse
 }
@@ -63,6 +135,7 @@ func checkRedirect(req *http.Request, via []*http.Request) error {
 // Create a new client for running uploads and downloads
 func newAgent() client {
 	transport := &http.Transport{
+		Proxy:              http.ProxyFromEnvironment,
 		MaxIdleConns:       10,
 		IdleConnTimeout:    30 * time.Second,
 		DisableCompression: true,
@@ -71,7 +144,7 @@ func newAgent() client {
 		Transport:     transport,
 		CheckRedirect: checkRedirect,
 	}
-	return client{transport, _client}
+	return client{transport: transport, client: _client, hashAlgorithm: SHA256, clock: systemClock{}, metrics: noopMetrics{}}
 }
 
 // callSummary is a similar concept to that in the taskcluster-client-go
@@ -80,7 +153,8 @@ func newAgent() client {
 // hashes instead of payload bodies.  We return this instead of a raw response
 // because we need to add extra fields (e.g. hashes and whether it was
 // verified).  In this library, the callSummary is expected to be useful for
-// programatic acccess to the resulting requests
+// programatic acccess to the resulting requests.  The Sha256 fields contain
+// hashes made with the agent's hash algorithm, which is sha256 by default
 type callSummary struct {
 	Method         string
 	URL            string
@@ -89,10 +163,15 @@ type callSummary struct {
 	RequestLength  int64
 	RequestSha256  string
 	RequestHeader  *http.Header
+	TraceID        string
 	ResponseLength int64
 	ResponseSha256 string
 	ResponseHeader *http.Header
-	Verified       bool
+	// ContentEncoding is the content-encoding of the response body, like
+	// gzip, or identity when it isn't encoded.  It's empty for responses which
+	// weren't decoded, like those with an error status
+	ContentEncoding string
+	Verified        bool
 }
 
 func (cs callSummary) String() string {
@@ -125,16 +204,18 @@ func (cs callSummary) String() string {
 		verified = " (verified)"
 	}
 
-	return fmt.Sprintf("Call Summary:\n=============\n%s %s%s\nHTTP Status: %s\nRequest Size: %d bytes SHA256: %s\nRequest Headers:\n%s\nResponse Size: %d SHA256: %s\nResponse Headers:\n%s\n",
+	return fmt.Sprintf("Call Summary:\n=============\n%s %s%s\nTrace ID: %s\nHTTP Status: %s\nRequest Size: %d bytes SHA256: %s\nRequest Headers:\n%s\nResponse Size: %d SHA256: %s\nContent Encoding: %s\nResponse Headers:\n%s\n",
 		strings.ToUpper(cs.Method),
 		cs.URL,
 		verified,
+		cs.TraceID,
 		cs.Status,
 		cs.RequestLength,
 		cs.RequestSha256,
 		reqHBuf.String(),
 		cs.ResponseLength,
 		cs.ResponseSha256,
+		cs.ContentEncoding,
 		resHBuf.String(),
 	)
 
@@ -143,7 +224,8 @@ func (cs callSummary) String() string {
 // TODO: Add logging just before returning an error
 
 // Run a request where x-amz-meta-{transfer,content}-{sha256,length} are
-// checked against the request body.  If the outputFile option is passed in,
+// checked against the request body.  When a hash algorithm other than sha256
+// is configured, its name replaces sha256 in those header names.  If the outputFile option is passed in,
 // create a file and write to that file the response body.  If the response has
 // the Content-Encoding header and it's value is gzip, the response body will
 // be written post-gzip decompression.  The response struct returned from this
@@ -160,13 +242,69 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 
 	// For debugging, we want to log the SHA256 and Size of the request body that
 	// we're going to write to
-	reqBodyHash := sha256.New()
+	reqBodyHash := c.hashAlgorithm.New()
 	reqBodyCounter := &byteCountingWriter{0}
 
+	// Once the request is finished, successfully or not, report it to the
+	// metrics hook
+	start := time.Now()
+	defer func() {
+		c.observe(inputReader != nil, reqBodyCounter.count, cs.ResponseLength, retryable, err, time.Since(start))
+	}()
+
+	// When a request timeout is set, we abort the request once it stops making
+	// progress.  Every read of the request or response body counts as progress
+	parent := c.operation
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	if c.uploadProgress != nil && inputReader != nil {
+		progress := c.uploadProgress
+		var sent int64
+		inputReader = progressReader{inputReader, func(n int) {
+			atomic.AddInt64(&sent, int64(n))
+			progress.add(n)
+		}}
+		defer func() {
+			if err != nil {
+				progress.remove(atomic.LoadInt64(&sent))
+			}
+		}()
+	}
+
+	var stall *stallTimer
+	if c.requestTimeout > 0 {
+		stall = newStallTimer(c.requestTimeout, cancel)
+		defer stall.stop()
+		if inputReader != nil {
+			inputReader = progressReader{inputReader, stall.progress}
+		}
+	}
+
+	// When a minimum throughput is set, we abort transfers of request and
+	// response bodies which are too slow, even if they are making progress
+	var sendMonitor *throughputMonitor
+	if c.minThroughput > 0 && inputReader != nil {
+		sendMonitor = newThroughputMonitor(c.minThroughput, c.throughputWindow, cancel)
+		defer sendMonitor.stop()
+		inputReader = sendMonitor.reader(inputReader)
+	}
+
+	if c.onChunk != nil && inputReader != nil {
+		inputReader = &chunkReader{inputReader, c.chunkOffset, c.onChunk}
+	}
+
 	var body io.Reader
 
+	// Whether the whole request body was read tells a body which doesn't match
+	// its Content-Length apart from one which stopped part way through
+	var bodyEnd *endReader
 	if inputReader != nil {
-		body = io.TeeReader(inputReader, io.MultiWriter(reqBodyHash, reqBodyCounter))
+		bodyEnd = &endReader{r: io.TeeReader(inputReader, io.MultiWriter(reqBodyHash, reqBodyCounter))}
+		body = bodyEnd
 	} else {
 		body = nil
 		// We need to write an empty byte slice to the Hash in order to get the
@@ -182,12 +320,26 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 	if err != nil {
 		return cs, false, newErrorf(err, "making %s request to %s", request.Method, request.URL)
 	}
+	httpRequest = httpRequest.WithContext(ctx)
 
 	// If we have headers in the request, let's set them
 	if request.Header != nil {
 		httpRequest.Header = *request.Header
 	}
 
+	if c.userAgent != "" && httpRequest.Header.Get("User-Agent") == "" {
+		httpRequest.Header.Set("User-Agent", c.userAgent)
+	}
+
+	if httpRequest.Header.Get(TraceIDHeader) == "" {
+		traceID := c.traceID
+		if traceID == "" {
+			traceID = newTraceID()
+		}
+		httpRequest.Header.Set(TraceIDHeader, traceID)
+	}
+	cs.TraceID = httpRequest.Header.Get(TraceIDHeader)
+
 	// Rather unintuitively, the Go HTTP library will ignore any content-length
 	// set in the headers, instead using the http.Request.ContentLength to figure
 	// out what to replace it with.... Except that for non-fixed length bodies,
@@ -209,12 +361,38 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 	}
 
 	cs.RequestHeader = &httpRequest.Header
-	cs.RequestLength = reqBodyCounter.count
-	cs.RequestSha256 = hex.EncodeToString(reqBodyHash.Sum(nil))
 	// Run the actual request
+	// Following a redirect of an upload could turn it into a GET request, so
+	// only requests without a body are given the redirect policy
+	httpClient := c.client
+	if c.redirectPolicy != nil && inputReader == nil {
+		// Copying the http.Client still shares its transport
+		withPolicy := *c.client
+		withPolicy.CheckRedirect = c.redirectPolicy
+		httpClient = &withPolicy
+	}
 	var resp *http.Response
-	resp, err = c.client.Do(httpRequest)
+	resp, err = httpClient.Do(httpRequest)
+	// The request body has been sent by now, or sending it failed, so these
+	// describe what was sent
+	cs.RequestLength = reqBodyCounter.count
+	cs.RequestSha256 = hex.EncodeToString(reqBodyHash.Sum(nil))
 	if err != nil {
+		// The operation has run out of time or was cancelled, so there's no
+		// point retrying
+		if stopErr := c.stopped(); stopErr != nil {
+			return cs, false, stopErr
+		}
+		if hadCL && bodyEnd != nil && bodyEnd.ended && reqBodyCounter.count != contentLength {
+			return cs, false, &ContentLengthError{request.Method, request.URL, contentLength, reqBodyCounter.count}
+		}
+		// A stalled request is likely a network issue, so it's worth trying again
+		if stall != nil && stall.isStalled() {
+			return cs, true, newErrorf(err, "%s request to %s made no progress for %s (retryable)", request.Method, request.URL, c.requestTimeout)
+		}
+		if sendMonitor != nil && sendMonitor.isTooSlow() {
+			return cs, true, newErrorf(err, "%s request to %s sent fewer than %d bytes/s over %s (retryable)", request.Method, request.URL, c.minThroughput, c.throughputWindow)
+		}
 		return cs, false, newErrorf(err, "running %s request to %s", request.Method, request.URL)
 	}
 
@@ -236,11 +414,17 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 	}()
 
 	// If the HTTP library reads in a different number of bytes than we're
-	// expecting to have, we know that something is wrong.  This could also be a
-	// panic() call, however, in this case we know we're accessing big disks
-	// which are likely not even on the machine running this code.  Given that,
-	// let's instead treat this as local I/O corruption and mark it as retryable
+	// expecting to have, we know that something is wrong.  When the whole body
+	// was read, the body and its Content-Length header disagree, which will
+	// happen again on every attempt.  Otherwise the body stopped part way
+	// through.  This could also be a panic() call, however, in this case we
+	// know we're accessing big disks which are likely not even on the machine
+	// running this code.  Given that, let's instead treat this as local I/O
+	// corruption and mark it as retryable
 	if hadCL && httpRequest.ContentLength != reqBodyCounter.count {
+		if bodyEnd != nil && bodyEnd.ended {
+			return cs, false, &ContentLengthError{request.Method, request.URL, contentLength, reqBodyCounter.count}
+		}
 		return cs, true, newErrorf(nil, "read %d bytes from response of %s to %s when we should have read %d",
 			reqBodyCounter.count, request.Method, request.URL, contentLength)
 	}
@@ -248,7 +432,11 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 	if resp.StatusCode >= 500 {
 		var errBody []byte
 		if errBody, err = ioutil.ReadAll(resp.Body); err == nil {
-			logger.Printf("Retryable Error %s\nBody:\n%s", cs, errBody)
+			c.logf("Retryable Error %s\nBody:\n%s", cs, errBody)
+			// The error message is the output of a failed request
+			if outputWriter != nil {
+				_, err = outputWriter.Write(errBody)
+			}
 		}
 		return cs, true, newErrorf(err, "received %s (retryable)", resp.Status)
 	}
@@ -257,15 +445,50 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 	if resp.StatusCode >= 400 {
 		var errBody []byte
 		if errBody, err = ioutil.ReadAll(resp.Body); err == nil {
-			logger.Printf("Non-Retryable Error %s\nBody:\n%s", cs, errBody)
+			c.logf("Non-Retryable Error %s\nBody:\n%s", cs, errBody)
+			// The error message is the output of a failed request
+			if outputWriter != nil {
+				_, err = outputWriter.Write(errBody)
+			}
+		}
+		if err == nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			if bytes.Contains(errBody, []byte("RequestTimeTooSkewed")) {
+				c.logf("%s %s was refused because the local clock is too far from S3's clock", request.Method, request.URL)
+				return cs, false, ErrClockSkew
+			}
+			// Both S3 ("Request has expired") and the Queue mention expiry
+			// when refusing a signed URL which has expired.  A URL which was
+			// signed with a local clock that is far behind looks expired as
+			// soon as it's signed, which the Date of the response shows
+			if bytes.Contains(bytes.ToLower(errBody), []byte("expired")) {
+				if skew, ok := clockSkew(resp.Header, c.clock.Now()); ok {
+					c.logf("%s %s was refused as expired and the local clock is %s off from the server's clock", request.Method, request.URL, skew)
+					return cs, false, ErrClockSkew
+				}
+				return cs, false, ErrSignedURLExpired
+			}
+			return cs, false, ErrUnauthorized
 		}
 		return cs, false, newErrorf(err, "received %s (non-retryable)", resp.Status)
 	}
 
-	// We're going to need to have the Sha256 calculated of both the bytes
+	// A response with a Content-Length other than the length the artifact was
+	// stored with can't be valid.  It's rejected before its body is written to
+	// the output, instead of once the whole body has been downloaded and
+	// hashed.  The Content-Length is unknown when the transport has already
+	// reversed a content-encoding, so those responses are only verified after
+	if verify && resp.StatusCode < 300 && resp.ContentLength >= 0 {
+		if expected, ok := expectedTransferLength(resp.Header); ok && expected != resp.ContentLength {
+			c.logf("Response %s %s (trace %s) is INVALID. Its Content-Length is %d bytes, expected %d bytes",
+				request.Method, request.URL, cs.TraceID, resp.ContentLength, expected)
+			return cs, true, ErrCorrupt
+		}
+	}
+
+	// We're going to need to have the hash calculated of both the bytes
 	// transfered and the decoded bytes if there's a content-encoding to reverse
-	transferHash := sha256.New()
-	contentHash := sha256.New()
+	transferHash := c.hashAlgorithm.New()
+	contentHash := c.hashAlgorithm.New()
 
 	// We're going to need to have the number of bytes received and size of the
 	// content
@@ -275,28 +498,70 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 	// This io.Reader is a reference to the response body, after setting up all
 	// the required plumbing for doing transfer byte counting and hashing as well
 	// as any possible content-decoding
-	input := io.TeeReader(resp.Body, io.MultiWriter(transferHash, transferCounter))
-
-	// We want to handle content encoding.  In this case, we only accept the
-	// header being unset (implies identity), 'indentity' or 'gzip'.  We do not
-	// support having more than one content-encoding scheme.  This switch will
-	// set up any changes to the readers needed (e.g. wrapping the reader with a
-	// gzip reader) as well as making assertions specific to the content-encoding
-	// in question
-	switch enc := strings.TrimSpace(resp.Header.Get("content-encoding")); enc {
-	case "":
-		fallthrough
-	case "identity":
-	case "gzip":
-		var zr *gzip.Reader
-		zr, err = gzip.NewReader(input)
-		if err != nil {
-			return cs, false, newErrorf(err, "creating gzip reader for %s to %s", request.Method, request.URL)
+	var respBody io.Reader = resp.Body
+	if stall != nil {
+		respBody = progressReader{resp.Body, stall.progress}
+	}
+	if c.onChunk != nil && inputReader == nil {
+		respBody = &chunkReader{respBody, c.chunkOffset, c.onChunk}
+	}
+	if c.downloadProgress != nil {
+		// The response's Content-Length is -1 when it isn't known
+		c.downloadProgress.total = resp.ContentLength
+		respBody = progressReader{respBody, c.downloadProgress.add}
+	}
+	var receiveMonitor *throughputMonitor
+	if c.minThroughput > 0 {
+		receiveMonitor = newThroughputMonitor(c.minThroughput, c.throughputWindow, cancel)
+		defer receiveMonitor.stop()
+		respBody = receiveMonitor.reader(respBody)
+	}
+	input := io.TeeReader(respBody, io.MultiWriter(transferHash, transferCounter))
+
+	// We want to handle content encoding.  The header lists the encodings in
+	// the order they were applied, so they're reversed in the opposite order by
+	// wrapping the input with a decoder for each one.  Identity encodings are
+	// no-ops and any encoding we don't understand is an error
+	var encodings []string
+	encodings, err = parseContentEncoding(resp.Header.Get("content-encoding"))
+	if err != nil {
+		return cs, false, newErrorf(err, "handling content-encoding for %s to %s", request.Method, request.URL)
+	}
+	cs.ContentEncoding = "identity"
+	if len(encodings) > 0 {
+		cs.ContentEncoding = strings.Join(encodings, ", ")
+	}
+	if c.raw && len(encodings) > 0 {
+		c.logf("Resource %s %s is %s encoded, not decoding it", request.Method, request.URL, strings.Join(encodings, ", "))
+		encodings = nil
+	}
+	for i := len(encodings) - 1; i >= 0; i-- {
+		switch encodings[i] {
+		case "gzip":
+			var zr *gzip.Reader
+			zr, err = gzip.NewReader(input)
+			if isDecodeError(err) {
+				c.logf("Response %s %s (trace %s) has an invalid gzip header: %v", request.Method, request.URL, cs.TraceID, err)
+				return cs, true, ErrCorrupt
+			}
+			if err != nil {
+				return cs, false, newErrorf(err, "creating gzip reader for %s to %s", request.Method, request.URL)
+			}
+			input = zr
+			c.logf("Resource %s %s is gzip encoded", request.Method, request.URL)
+		case "deflate":
+			var dr io.ReadCloser
+			dr, err = newDeflateReader(input)
+			if isDecodeError(err) {
+				c.logf("Response %s %s (trace %s) has an invalid zlib header: %v", request.Method, request.URL, cs.TraceID, err)
+				return cs, true, ErrCorrupt
+			}
+			if err != nil {
+				return cs, false, newErrorf(err, "creating deflate reader for %s to %s", request.Method, request.URL)
+			}
+			input = dr
+			c.logf("Resource %s %s is deflate encoded", request.Method, request.URL)
 		}
-		input = zr
-		logger.Printf("Resource %s %s is gzip encoded", request.Method, request.URL)
-	default:
-		return cs, false, newErrorf(nil, "unexpected content-encoding %s for %s to %s", enc, request.Method, request.URL)
 	}
 
 	// This io.Writer is a reference to the output stream.  This is at least the
@@ -304,19 +569,50 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 	// this will also write the output to a file
 	var output io.Writer
 
+	// Errors writing to the output are recorded so that they can be told apart
+	// from errors reading the response
+	outputErrs := &errorRecordingWriter{w: outputWriter}
+
 	if outputWriter == nil {
 		output = io.MultiWriter(contentHash, contentCounter)
 	} else {
-		output = io.MultiWriter(outputWriter, contentHash, contentCounter)
+		output = io.MultiWriter(outputErrs, contentHash, contentCounter)
 	}
 
 	// Read buffer
-	buf := make([]byte, chunkSize)
+	bufp := getBuffer(chunkSize)
+	defer putBuffer(bufp)
 
-	_, err = io.CopyBuffer(output, input, buf)
+	_, err = io.CopyBuffer(output, input, *bufp)
 	if err != nil {
-		// Retryable because this is likely a local issue only
-		return cs, true, newErrorf(err, "writing request %s to %s to output %s", request.Method, request.URL, findName(outputWriter))
+		if stopErr := c.stopped(); stopErr != nil {
+			return cs, false, stopErr
+		}
+		if hadCL && bodyEnd != nil && bodyEnd.ended && reqBodyCounter.count != contentLength {
+			return cs, false, &ContentLengthError{request.Method, request.URL, contentLength, reqBodyCounter.count}
+		}
+		if stall != nil && stall.isStalled() {
+			return cs, true, newErrorf(err, "response of %s to %s made no progress for %s (retryable)", request.Method, request.URL, c.requestTimeout)
+		}
+		if receiveMonitor != nil && receiveMonitor.isTooSlow() {
+			return cs, true, newErrorf(err, "response of %s to %s received fewer than %d bytes/s over %s (retryable)", request.Method, request.URL, c.minThroughput, c.throughputWindow)
+		}
+		if outputErrs.err != nil {
+			// Retrying won't free up disk space or make a filesystem writable
+			if isPermanentWriteError(outputErrs.err) {
+				return cs, false, newErrorf(err, "writing response of %s to %s to output %s (non-retryable)", request.Method, request.URL, findName(outputWriter))
+			}
+			// Retryable because this is likely a transient local issue only
+			return cs, true, newErrorf(err, "writing response of %s to %s to output %s (retryable)", request.Method, request.URL, findName(outputWriter))
+		}
+		// A body which can't be decoded is as corrupt as one with the wrong
+		// hash, and could have been corrupted on the wire just the same
+		if isDecodeError(err) {
+			c.logf("Response %s %s (trace %s) could not be decoded: %v", request.Method, request.URL, cs.TraceID, err)
+			return cs, true, ErrCorrupt
+		}
+		// The response was interrupted, which is likely a network issue
+		return cs, true, newErrorf(err, "reading response of %s to %s (retryable)", request.Method, request.URL)
 	}
 
 	transferBytes := transferCounter.count
@@ -347,7 +643,7 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 
 		// Figure out what content size we're expecting
 		if cSize := resp.Header.Get("x-amz-meta-content-length"); cSize == "" {
-			logger.Printf("Expected header X-Amz-Meta-Content-Length to have a value")
+			c.logf("Expected header X-Amz-Meta-Content-Length to have a value")
 			valid = false
 		} else {
 			var i int64
@@ -360,9 +656,20 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 			expectedSize = i
 		}
 
+		// Without a content-encoding, the transfered bytes are the content, so
+		// the content's size and hash are expected when the transfer's are
+		// missing.  Encoded responses can't be checked without them
+		encodings, encErr := parseContentEncoding(resp.Header.Get("content-encoding"))
+		identity := encErr == nil && len(encodings) == 0
+
 		// Figure out which transfer size we're expecting
 		if tSize := resp.Header.Get("x-amz-meta-transfer-length"); tSize == "" {
-			expectedTransferSize = expectedSize
+			if identity {
+				expectedTransferSize = expectedSize
+			} else {
+				c.logf("WARNING: X-Amz-Meta-Transfer-Length is missing, cannot verify the transfer length of %s %s", request.Method, request.URL)
+				expectedTransferSize = -1
+			}
 		} else {
 			var i int64
 			i, err = strconv.ParseInt(tSize, 10, 64)
@@ -374,50 +681,50 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 			expectedTransferSize = i
 		}
 
-		// Let's get the text out that we need
-		expectedSha256 = resp.Header.Get("x-amz-meta-content-sha256")
-		expectedTransferSha256 = resp.Header.Get("x-amz-meta-transfer-sha256")
+		// Let's get the text out that we need.  The headers are named after the
+		// hash algorithm, e.g. x-amz-meta-content-sha256
+		hashName := c.hashAlgorithm.Name
+		expectedSha256 = resp.Header.Get("x-amz-meta-content-" + hashName)
+		expectedTransferSha256 = resp.Header.Get("x-amz-meta-transfer-" + hashName)
+
+		// Each byte of the hash is two hex characters
+		hashLength := contentHash.Size() * 2
 
 		if expectedSha256 == "" {
-			logger.Printf("Expected a X-Amz-Meta-Content-Sha256 to have a value")
+			c.logf("Expected a X-Amz-Meta-Content-%s to have a value", hashName)
 			valid = false
-		} else if len(expectedSha256) != 64 {
-			logger.Printf("Expected X-Amz-Meta-Content-Sha256 to be 64 chars, not %d", len(expectedSha256))
+		} else if len(expectedSha256) != hashLength {
+			c.logf("Expected X-Amz-Meta-Content-%s to be %d chars, not %d", hashName, hashLength, len(expectedSha256))
 			valid = false
 		}
 
 		if expectedTransferSha256 == "" {
-			expectedTransferSha256 = expectedSha256
+			if identity {
+				expectedTransferSha256 = expectedSha256
+			} else {
+				c.logf("WARNING: X-Amz-Meta-Transfer-%s is missing, cannot verify the transfer %s of %s %s", hashName, hashName, request.Method, request.URL)
+			}
 		}
 
-		if expectedTransferSize != transferBytes {
-			logger.Printf("Resource %s %s has incorrect transfer length.  Expected: %d received: %d",
-				request.Method, request.URL, expectedTransferSize, transferBytes)
-			valid = false
+		// The content is the transfered bytes when they aren't decoded
+		if c.raw {
+			expectedSize = expectedTransferSize
+			expectedSha256 = expectedTransferSha256
 		}
 
-		if expectedTransferSha256 != sTransferHash {
-			logger.Printf("Resource %s %s has incorrect transfer sha256.  Expected: %s received: %s",
-				request.Method, request.URL, expectedTransferSha256, sTransferHash)
+		resource := request.Method + " " + request.URL
+		if !checkLengthAndHash(c.logf, resource, "transfer", hashName, expectedTransferSize, transferBytes, expectedTransferSha256, sTransferHash) {
 			valid = false
 		}
-
-		if expectedSize != contentBytes {
-			logger.Printf("Resource %s %s has incorrect content length.  Expected: %d received: %d",
-				request.Method, request.URL, expectedSize, contentBytes)
-			valid = false
-		}
-
-		if expectedSha256 != sContentHash {
-			logger.Printf("Resource %s %s has incorrect content sha256.  Expected: %s received: %s",
-				request.Method, request.URL, expectedSha256, sContentHash)
+		if !checkLengthAndHash(c.logf, resource, "content", hashName, expectedSize, contentBytes, expectedSha256, sContentHash) {
 			valid = false
 		}
 
 		if !valid {
-			logger.Printf("Response %s %s is INVALID. Received: transfer: %s %d bytes content: %s %d bytes",
+			c.logf("Response %s %s (trace %s) is INVALID. Received: transfer: %s %d bytes content: %s %d bytes",
 				request.Method,
 				request.URL,
+				cs.TraceID,
 				sTransferHash[:7],
 				transferBytes,
 				sContentHash[:7],
@@ -428,17 +735,19 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 		}
 	}
 	if verify {
-		logger.Printf("Response %s %s is valid. transfer: %s %d bytes content: %s %d bytes",
+		c.logf("Response %s %s (trace %s) is valid. transfer: %s %d bytes content: %s %d bytes",
 			request.Method,
 			request.URL,
+			cs.TraceID,
 			sTransferHash[:7],
 			transferBytes,
 			sContentHash[:7],
 			contentBytes)
 	} else {
-		logger.Printf("Response %s %s is complete. transfer: %s %d bytes content: %s %d bytes",
+		c.logf("Response %s %s (trace %s) is complete. transfer: %s %d bytes content: %s %d bytes",
 			request.Method,
 			request.URL,
+			cs.TraceID,
 			sTransferHash[:7],
 			transferBytes,
 			sContentHash[:7],
@@ -446,3 +755,86 @@ func (c client) run(request request, inputReader io.Reader, chunkSize int, outpu
 	}
 	return cs, false, nil
 }
+
+// S3 refuses requests which were signed more than 15 minutes away from its
+// own time, so a clock which is further off than that is skewed
+const maxClockSkew = 15 * time.Minute
+
+// Determine how far the local clock, which reads now, is from the clock of
+// the server which sent a response, according to the response's Date header,
+// and whether that's far enough to make signed URLs fail
+func clockSkew(header http.Header, now time.Time) (time.Duration, bool) {
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	skew := now.Sub(date)
+	if skew < 0 {
+		skew = -skew
+	}
+	return skew.Round(time.Second), skew > maxClockSkew
+}
+
+// Determine the number of bytes which a response for a stored artifact should
+// have from its headers.  When the transfer length wasn't stored, the content
+// length is the transfer length of artifacts without a content-encoding
+func expectedTransferLength(header http.Header) (int64, bool) {
+	length := header.Get("x-amz-meta-transfer-length")
+	if length == "" {
+		if encodings, err := parseContentEncoding(header.Get("content-encoding")); err != nil || len(encodings) > 0 {
+			return 0, false
+		}
+		length = header.Get("x-amz-meta-content-length")
+	}
+	i, err := strconv.ParseInt(length, 10, 64)
+	if err != nil || i < 0 {
+		return 0, false
+	}
+	return i, true
+}
+
+// An endReader records whether the reader it wraps has been read to its end
+type endReader struct {
+	r     io.Reader
+	ended bool
+}
+
+func (e *endReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF {
+		e.ended = true
+	}
+	return n, err
+}
+
+// Parse the value of a content-encoding header into the encodings which were
+// applied to a response body, in the order they were applied.  Values are
+// case-insensitive and may be a comma separated list.  Identity encodings
+// don't change the body, so they're left out.  An error is returned for
+// encodings which we can't decode
+func parseContentEncoding(value string) ([]string, error) {
+	var encodings []string
+	for _, enc := range strings.Split(value, ",") {
+		switch enc = strings.ToLower(strings.TrimSpace(enc)); enc {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			encodings = append(encodings, "gzip")
+		case "deflate":
+			encodings = append(encodings, "deflate")
+		default:
+			return nil, newErrorf(nil, "unexpected content-encoding %s", enc)
+		}
+	}
+	return encodings, nil
+}
+
+// Determine whether an error returned while decoding a response body means
+// that the body isn't validly encoded, like a gzip body with the wrong CRC
+func isDecodeError(err error) bool {
+	switch err {
+	case gzip.ErrHeader, gzip.ErrChecksum, zlib.ErrHeader, zlib.ErrChecksum, zlib.ErrDictionary:
+		return true
+	}
+	_, ok := err.(flate.CorruptInputError)
+	return ok
+}
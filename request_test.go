@@ -2,7 +2,9 @@ package artifact
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
@@ -12,7 +14,9 @@ import (
 	"net/http/httptest"
 	"os"
 	"strconv"
+	"syscall"
 	"testing"
+	"time"
 )
 
 const emptySha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
@@ -195,6 +199,400 @@ func TestRequestRunning(t *testing.T) {
 
 	})
 
+	t.Run("writes error response body to output", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(404)
+			w.Write([]byte("not found"))
+		}))
+		defer ts.Close()
+
+		req := newRequest(ts.URL, "GET", nil)
+
+		var output bytes.Buffer
+
+		_, _, err = client.run(req, nil, 1024, &output, false)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		if output.String() != "not found" {
+			t.Fatalf("expected error body in output, got %q", output.String())
+		}
+	})
+
+	t.Run("classifies output write errors", func(t *testing.T) {
+		ts := createServer(http.StatusOK, sl(b), hb(b), "", "", "", b)
+		defer ts.Close()
+
+		writeErrors := []struct {
+			err       error
+			retryable bool
+		}{
+			{&os.PathError{Op: "write", Path: "full", Err: syscall.ENOSPC}, false},
+			{&os.PathError{Op: "write", Path: "readonly", Err: syscall.EROFS}, false},
+			{os.ErrClosed, false},
+			{&os.PathError{Op: "write", Path: "flaky", Err: syscall.EIO}, true},
+		}
+
+		for _, tt := range writeErrors {
+			req := newRequest(ts.URL, "GET", nil)
+			_, retryable, err := client.run(req, nil, 1024, failingWriter{tt.err}, true)
+			if err == nil {
+				t.Errorf("expected an error writing to output failing with %v", tt.err)
+			} else if retryable != tt.retryable {
+				t.Errorf("expected retryable to be %t for %v, got %t", tt.retryable, tt.err, retryable)
+			}
+		}
+	})
+
+	t.Run("content length mismatches", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(ioutil.Discard, r.Body)
+		}))
+		defer ts.Close()
+
+		for _, length := range []int{5, 15} {
+			header := &http.Header{}
+			header.Set("Content-Length", "10")
+			req := newRequest(ts.URL, "PUT", header)
+			_, retryable, err := client.run(req, bytes.NewReader(make([]byte, length)), 1024, nil, false)
+			clErr, ok := err.(*ContentLengthError)
+			if !ok {
+				t.Fatalf("expected a ContentLengthError for a body of %d bytes, got %v", length, err)
+			}
+			if retryable {
+				t.Errorf("expected a body of %d bytes not to be retryable", length)
+			}
+			if clErr.ContentLength != 10 || clErr.BodyLength != int64(length) {
+				t.Errorf("expected a content length of 10 and a body length of %d, got %d and %d", length, clErr.ContentLength, clErr.BodyLength)
+			}
+		}
+	})
+
+	t.Run("sets user agent", func(t *testing.T) {
+		var userAgent string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userAgent = r.Header.Get("User-Agent")
+		}))
+		defer ts.Close()
+
+		uaClient := newAgent()
+		uaClient.userAgent = DefaultUserAgent
+
+		t.Run("when unset", func(t *testing.T) {
+			req := newRequest(ts.URL, "GET", nil)
+			_, _, err = uaClient.run(req, nil, 1024, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if userAgent != DefaultUserAgent {
+				t.Fatalf("expected User-Agent %s, got %s", DefaultUserAgent, userAgent)
+			}
+		})
+
+		t.Run("without overwriting", func(t *testing.T) {
+			header := &http.Header{}
+			header.Set("User-Agent", "custom")
+			req := newRequest(ts.URL, "GET", header)
+			_, _, err = uaClient.run(req, nil, 1024, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if userAgent != "custom" {
+				t.Fatalf("expected User-Agent custom, got %s", userAgent)
+			}
+		})
+	})
+
+	t.Run("sets trace id", func(t *testing.T) {
+		var traceID string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceID = r.Header.Get(TraceIDHeader)
+		}))
+		defer ts.Close()
+
+		t.Run("when configured", func(t *testing.T) {
+			traceClient := newAgent()
+			traceClient.traceID = "configured-trace"
+			req := newRequest(ts.URL, "GET", nil)
+			cs, _, err := traceClient.run(req, nil, 1024, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if traceID != "configured-trace" || cs.TraceID != "configured-trace" {
+				t.Fatalf("expected trace id configured-trace, sent %q and summarized %q", traceID, cs.TraceID)
+			}
+		})
+
+		t.Run("when unset", func(t *testing.T) {
+			req := newRequest(ts.URL, "GET", nil)
+			cs, _, err := client.run(req, nil, 1024, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(traceID) != 32 || cs.TraceID != traceID {
+				t.Fatalf("expected a generated trace id, sent %q and summarized %q", traceID, cs.TraceID)
+			}
+		})
+
+		t.Run("without overwriting", func(t *testing.T) {
+			header := &http.Header{}
+			header.Set(TraceIDHeader, "custom")
+			req := newRequest(ts.URL, "GET", header)
+			if _, _, err := client.run(req, nil, 1024, nil, false); err != nil {
+				t.Fatal(err)
+			}
+			if traceID != "custom" {
+				t.Fatalf("expected trace id custom, got %s", traceID)
+			}
+		})
+	})
+
+	t.Run("request timeouts", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+			// Write the response slowly, but steadily, unless we're asked to stall
+			for i := 0; i < 10; i++ {
+				if r.URL.Path == "/stall" && i == 5 {
+					time.Sleep(500 * time.Millisecond)
+				}
+				w.Write([]byte("beep"))
+				w.(http.Flusher).Flush()
+				time.Sleep(20 * time.Millisecond)
+			}
+		}))
+		defer ts.Close()
+
+		timeoutClient := newAgent()
+		timeoutClient.requestTimeout = 100 * time.Millisecond
+
+		t.Run("allow slow requests which make progress", func(t *testing.T) {
+			req := newRequest(ts.URL+"/slow", "GET", nil)
+			var output bytes.Buffer
+			_, _, err = timeoutClient.run(req, nil, 1024, &output, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output.Len() != 40 {
+				t.Fatalf("expected 40 bytes, got %d", output.Len())
+			}
+		})
+
+		t.Run("abort stalled requests", func(t *testing.T) {
+			req := newRequest(ts.URL+"/stall", "GET", nil)
+			var retryable bool
+			_, retryable, err = timeoutClient.run(req, nil, 1024, nil, false)
+			if err == nil {
+				t.Fatal("expected stalled request to fail")
+			}
+			if !retryable {
+				t.Fatal("expected stalled request to be retryable")
+			}
+		})
+	})
+
+	t.Run("minimum throughput", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+			if r.URL.Path != "/trickle" {
+				w.Write(b)
+				return
+			}
+			// Never stall, but never send much either
+			for i := 0; i < 20; i++ {
+				w.Write([]byte("b"))
+				w.(http.Flusher).Flush()
+				time.Sleep(25 * time.Millisecond)
+			}
+		}))
+		defer ts.Close()
+
+		slowClient := newAgent()
+		slowClient.minThroughput = 1024
+		slowClient.throughputWindow = 100 * time.Millisecond
+
+		t.Run("allows fast requests", func(t *testing.T) {
+			req := newRequest(ts.URL, "GET", nil)
+			var output bytes.Buffer
+			_, _, err = slowClient.run(req, nil, 1024, &output, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(output.Bytes(), b) {
+				t.Fatal("Response output does not match expected value")
+			}
+		})
+
+		t.Run("aborts trickling requests", func(t *testing.T) {
+			req := newRequest(ts.URL+"/trickle", "GET", nil)
+			var retryable bool
+			_, retryable, err = slowClient.run(req, nil, 1024, nil, false)
+			if err == nil {
+				t.Fatal("expected trickling request to fail")
+			}
+			if !retryable {
+				t.Fatal("expected trickling request to be retryable")
+			}
+		})
+	})
+
+	t.Run("verifies using the configured hash algorithm", func(t *testing.T) {
+		sha512Client := newAgent()
+		sha512Client.hashAlgorithm = SHA512
+
+		hash := SHA512.New()
+		hash.Write(b)
+		expected := hex.EncodeToString(hash.Sum(nil))
+
+		t.Run("with matching headers", func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("x-amz-meta-content-length", sl(b))
+				w.Header().Set("x-amz-meta-content-sha512", expected)
+				w.Write(b)
+			}))
+			defer ts.Close()
+
+			req := newRequest(ts.URL, "GET", nil)
+			_, _, err := sha512Client.run(req, nil, 1024, nil, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		t.Run("with only sha256 headers", func(t *testing.T) {
+			ts := createServer(http.StatusOK, sl(b), hb(b), "", "", "", b)
+			defer ts.Close()
+
+			req := newRequest(ts.URL, "GET", nil)
+			_, _, err := sha512Client.run(req, nil, 1024, nil, true)
+			if err != ErrCorrupt {
+				t.Fatalf("expected ErrCorrupt, got %v", err)
+			}
+		})
+	})
+
+	t.Run("raw responses are not decoded", func(t *testing.T) {
+		rawClient := newAgent()
+		rawClient.raw = true
+
+		t.Run("gzip encoding", func(t *testing.T) {
+			ts := createServer(http.StatusOK, sl(b), hb(b), sl(gzipBody), hb(gzipBody), "gzip", gzipBody)
+			defer ts.Close()
+
+			var output bytes.Buffer
+			req := newRequest(ts.URL, "GET", nil)
+			_, _, err := rawClient.run(req, nil, 1024, &output, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(output.Bytes(), gzipBody) {
+				t.Fatal("expected output to be the gzip encoded body")
+			}
+		})
+
+		t.Run("returns error for incorrect transfer hash", func(t *testing.T) {
+			ts := createServer(http.StatusOK, sl(b), hb(b), sl(gzipBody), hb(b), "gzip", gzipBody)
+			defer ts.Close()
+
+			req := newRequest(ts.URL, "GET", nil)
+			_, _, err := rawClient.run(req, nil, 1024, nil, true)
+			if err != ErrCorrupt {
+				t.Fatalf("expected ErrCorrupt, got %v", err)
+			}
+		})
+	})
+
+	t.Run("refused requests", func(t *testing.T) {
+		// The Date of each response is relative to the local clock, so
+		// skewed responses are an hour behind it
+		k := newFakeClock()
+		refusedClient := newAgent()
+		refusedClient.clock = k
+
+		refusals := []struct {
+			status   int
+			body     string
+			skew     time.Duration
+			expected error
+		}{
+			{http.StatusForbidden, "<Error><Code>AccessDenied</Code><Message>Request has expired</Message></Error>", 0, ErrSignedURLExpired},
+			{http.StatusForbidden, "<Error><Code>AccessDenied</Code><Message>Request has expired</Message></Error>", maxClockSkew - time.Minute, ErrSignedURLExpired},
+			{http.StatusForbidden, "<Error><Code>AccessDenied</Code><Message>Request has expired</Message></Error>", time.Hour, ErrClockSkew},
+			{http.StatusForbidden, "<Error><Code>AccessDenied</Code><Message>Request has expired</Message></Error>", -time.Hour, ErrClockSkew},
+			{http.StatusForbidden, "<Error><Code>RequestTimeTooSkewed</Code><Message>The difference between the request time and the current time is too large.</Message></Error>", 0, ErrClockSkew},
+			{http.StatusForbidden, "<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>", time.Hour, ErrUnauthorized},
+			{http.StatusUnauthorized, `{"code": "AuthenticationFailed"}`, 0, ErrUnauthorized},
+			{http.StatusNotFound, "", 0, nil},
+		}
+
+		for _, tt := range refusals {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Date", k.Now().Add(-tt.skew).Format(http.TimeFormat))
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			req := newRequest(ts.URL, "GET", nil)
+			_, _, err := refusedClient.run(req, nil, 1024, nil, true)
+			ts.Close()
+
+			if err == nil {
+				t.Errorf("expected an error for %d %s", tt.status, tt.body)
+			} else if tt.expected != nil && err != tt.expected {
+				t.Errorf("expected %v for %d %s %s behind, got %v", tt.expected, tt.status, tt.body, tt.skew, err)
+			} else if tt.expected == nil && (err == ErrUnauthorized || err == ErrSignedURLExpired || err == ErrClockSkew) {
+				t.Errorf("did not expect %v for %d", err, tt.status)
+			}
+		}
+	})
+
+	t.Run("reports metrics", func(t *testing.T) {
+		metrics := &recordingMetrics{}
+		metricsClient := newAgent()
+		metricsClient.metrics = metrics
+
+		t.Run("for uploads", func(t *testing.T) {
+			ts := createServer(http.StatusOK, "0", emptySha256, "", "", "", nil)
+			defer ts.Close()
+
+			req := newRequest(ts.URL, "PUT", nil)
+			_, _, err := metricsClient.run(req, bytes.NewReader([]byte("metrics")), 1024, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if metrics.uploaded != 7 {
+				t.Fatalf("expected 7 bytes uploaded, got %d", metrics.uploaded)
+			}
+		})
+
+		t.Run("for downloads", func(t *testing.T) {
+			ts := createServer(http.StatusOK, sl(b), hb(b), "", "", "", b)
+			defer ts.Close()
+
+			req := newRequest(ts.URL, "GET", nil)
+			_, _, err := metricsClient.run(req, nil, 1024, nil, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if metrics.downloaded != int64(len(b)) {
+				t.Fatalf("expected %d bytes downloaded, got %d", len(b), metrics.downloaded)
+			}
+		})
+
+		t.Run("for corrupt responses", func(t *testing.T) {
+			ts := createServer(http.StatusOK, sl(b), hb([]byte("notcorrect")), "", "", "", b)
+			defer ts.Close()
+
+			req := newRequest(ts.URL, "GET", nil)
+			_, _, err := metricsClient.run(req, nil, 1024, nil, true)
+			if err != ErrCorrupt {
+				t.Fatalf("expected ErrCorrupt, got %v", err)
+			}
+			if metrics.corrupt != 1 || metrics.retries != 1 {
+				t.Fatalf("expected 1 corrupt response and 1 retry, got %d and %d", metrics.corrupt, metrics.retries)
+			}
+		})
+	})
+
 	t.Run("sha256 and length verified requests", func(t *testing.T) {
 		t.Run("identity encoding", func(t *testing.T) {
 			t.Run("can run an empty request", func(t *testing.T) {
@@ -261,6 +659,28 @@ func TestRequestRunning(t *testing.T) {
 				}
 			})
 
+			t.Run("rejects a wrong length before writing the output", func(t *testing.T) {
+				// Like S3, this server sends a Content-Length
+				ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("x-amz-meta-content-length", "123456")
+					w.Header().Set("x-amz-meta-content-sha256", hb(b))
+					w.Header().Set("content-length", sl(b))
+					w.Write(b)
+				}))
+				defer ts.Close()
+
+				req := newRequest(ts.URL, "GET", nil)
+				var output bytes.Buffer
+				cs, retryable, err := client.run(req, nil, 1024, &output, true)
+
+				if err != ErrCorrupt || !retryable {
+					t.Fatalf("expected retryable ErrCorrupt, got %t %v", retryable, err)
+				}
+				if output.Len() != 0 || cs.ResponseLength != 0 {
+					t.Fatalf("expected nothing to be downloaded, wrote %d bytes of %d", output.Len(), cs.ResponseLength)
+				}
+			})
+
 			t.Run("returns error when the content hash is wrong", func(t *testing.T) {
 				ts := createServer(http.StatusOK, sl(b), hb([]byte("notcorrect")), "", "", "", b)
 				defer ts.Close()
@@ -276,6 +696,49 @@ func TestRequestRunning(t *testing.T) {
 			})
 		})
 
+		t.Run("deflate encoding", func(t *testing.T) {
+			var zlibBody bytes.Buffer
+			zlw := zlib.NewWriter(&zlibBody)
+			zlw.Write(b)
+			zlw.Close()
+
+			var rawBody bytes.Buffer
+			fw, _ := flate.NewWriter(&rawBody, flate.DefaultCompression)
+			fw.Write(b)
+			fw.Close()
+
+			for _, body := range []struct {
+				name string
+				data []byte
+			}{{"zlib wrapped", zlibBody.Bytes()}, {"raw", rawBody.Bytes()}} {
+				t.Run("can run a request with "+body.name+" deflate", func(t *testing.T) {
+					ts := createServer(http.StatusOK, sl(b), hb(b), sl(body.data), hb(body.data), "deflate", body.data)
+					defer ts.Close()
+
+					req := newRequest(ts.URL, "GET", nil)
+					var output bytes.Buffer
+					_, _, err := client.run(req, nil, 1024, &output, true)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(output.Bytes(), b) {
+						t.Fatal("expected output to be the decoded body")
+					}
+				})
+			}
+
+			t.Run("returns error for invalid deflate bodies", func(t *testing.T) {
+				ts := createServer(http.StatusOK, sl(b), hb(b), sl(b), hb(b), "deflate", b)
+				defer ts.Close()
+
+				req := newRequest(ts.URL, "GET", nil)
+				_, _, err := client.run(req, nil, 1024, nil, true)
+				if err == nil {
+					t.Fatal("expected an error for a body which isn't deflate encoded")
+				}
+			})
+		})
+
 		t.Run("gzip encoding", func(t *testing.T) {
 			t.Run("can run a request", func(t *testing.T) {
 				ts := createServer(http.StatusOK, sl(b), hb(b), sl(gzipBody), hb(gzipBody), "gzip", gzipBody)
@@ -318,6 +781,130 @@ func TestRequestRunning(t *testing.T) {
 				}
 			})
 
+			t.Run("can run a request without transfer length", func(t *testing.T) {
+				ts := createServer(http.StatusOK, sl(b), hb(b), "", hb(gzipBody), "gzip", gzipBody)
+				defer ts.Close()
+
+				req := newRequest(ts.URL, "GET", nil)
+				_, _, err := client.run(req, nil, 1024, nil, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			t.Run("can run a request without transfer length or hash", func(t *testing.T) {
+				ts := createServer(http.StatusOK, sl(b), hb(b), "", "", "gzip", gzipBody)
+				defer ts.Close()
+
+				req := newRequest(ts.URL, "GET", nil)
+				_, _, err := client.run(req, nil, 1024, nil, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			t.Run("still checks the content without transfer length", func(t *testing.T) {
+				ts := createServer(http.StatusOK, sl(b), hb([]byte("notcorrect")), "", hb(gzipBody), "gzip", gzipBody)
+				defer ts.Close()
+
+				req := newRequest(ts.URL, "GET", nil)
+				_, _, err := client.run(req, nil, 1024, nil, true)
+				if err != ErrCorrupt {
+					t.Fatalf("expected ErrCorrupt, got %v", err)
+				}
+			})
+
+			t.Run("accepts any case and lists including identity", func(t *testing.T) {
+				for _, ce := range []string{"Gzip", "GZIP", "gzip, identity", " identity , x-gzip "} {
+					ts := createServer(http.StatusOK, sl(b), hb(b), sl(gzipBody), hb(gzipBody), ce, gzipBody)
+					req := newRequest(ts.URL, "GET", nil)
+					_, _, err := client.run(req, nil, 1024, nil, true)
+					ts.Close()
+					if err != nil {
+						t.Errorf("content-encoding %q: %v", ce, err)
+					}
+				}
+			})
+
+			t.Run("reports the observed encoding", func(t *testing.T) {
+				for ce, expected := range map[string]string{"": "identity", "identity": "identity", "X-Gzip": "gzip"} {
+					body := b
+					if expected == "gzip" {
+						body = gzipBody
+					}
+					ts := createServer(http.StatusOK, sl(b), hb(b), sl(body), hb(body), ce, body)
+					req := newRequest(ts.URL, "GET", nil)
+					cs, _, err := client.run(req, nil, 1024, nil, true)
+					ts.Close()
+					if err != nil {
+						t.Fatalf("content-encoding %q: %v", ce, err)
+					}
+					if cs.ContentEncoding != expected {
+						t.Errorf("content-encoding %q: expected %q, got %q", ce, expected, cs.ContentEncoding)
+					}
+				}
+			})
+
+			t.Run("reverses encodings in the opposite order", func(t *testing.T) {
+				var twiceBody bytes.Buffer
+				zw := gzip.NewWriter(&twiceBody)
+				zw.Write(gzipBody)
+				zw.Close()
+				twice := twiceBody.Bytes()
+
+				ts := createServer(http.StatusOK, sl(b), hb(b), sl(twice), hb(twice), "gzip, gzip", twice)
+				defer ts.Close()
+
+				req := newRequest(ts.URL, "GET", nil)
+				var output bytes.Buffer
+				_, _, err := client.run(req, nil, 1024, &output, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(output.Bytes(), b) {
+					t.Fatal("expected output to be decoded twice")
+				}
+			})
+
+			t.Run("returns error for unknown encodings", func(t *testing.T) {
+				ts := createServer(http.StatusOK, sl(b), hb(b), sl(gzipBody), hb(gzipBody), "gzip, br", gzipBody)
+				defer ts.Close()
+
+				req := newRequest(ts.URL, "GET", nil)
+				_, _, err := client.run(req, nil, 1024, nil, true)
+				if err == nil {
+					t.Fatal("expected an error for an unknown content-encoding")
+				}
+			})
+
+			t.Run("returns ErrCorrupt for a tampered gzip trailer", func(t *testing.T) {
+				tampered := make([]byte, len(gzipBody))
+				copy(tampered, gzipBody)
+				tampered[len(tampered)-1] ^= 0xff
+
+				ts := createServer(http.StatusOK, sl(b), hb(b), sl(tampered), hb(tampered), "gzip", tampered)
+				defer ts.Close()
+
+				req := newRequest(ts.URL, "GET", nil)
+				_, retryable, err := client.run(req, nil, 1024, nil, true)
+				if err != ErrCorrupt {
+					t.Fatalf("expected ErrCorrupt, got %v", err)
+				}
+				if !retryable {
+					t.Fatal("expected a corrupt gzip body to be retryable")
+				}
+			})
+
+			t.Run("returns ErrCorrupt for a gzip body with an invalid header", func(t *testing.T) {
+				ts := createServer(http.StatusOK, sl(b), hb(b), sl(b), hb(b), "gzip", b)
+				defer ts.Close()
+
+				req := newRequest(ts.URL, "GET", nil)
+				if _, _, err := client.run(req, nil, 1024, nil, true); err != ErrCorrupt {
+					t.Fatalf("expected ErrCorrupt, got %v", err)
+				}
+			})
+
 			t.Run("returns error for invalid gzip bodies", func(t *testing.T) {
 
 				ts := createServer(http.StatusOK, sl(b), hb(b), sl(b), hb(b), "gzip", b)
@@ -334,3 +921,36 @@ func TestRequestRunning(t *testing.T) {
 		})
 	})
 }
+
+// recordingMetrics totals the measurements it receives
+type recordingMetrics struct {
+	uploaded   int64
+	downloaded int64
+	retries    int
+	corrupt    int
+}
+
+func (m *recordingMetrics) ObserveUpload(bytes int64, dur time.Duration) {
+	m.uploaded += bytes
+}
+
+func (m *recordingMetrics) ObserveDownload(bytes int64, dur time.Duration) {
+	m.downloaded += bytes
+}
+
+func (m *recordingMetrics) IncRetry() {
+	m.retries++
+}
+
+func (m *recordingMetrics) IncCorrupt() {
+	m.corrupt++
+}
+
+// failingWriter fails every write with err
+type failingWriter struct {
+	err error
+}
+
+func (f failingWriter) Write(p []byte) (int, error) {
+	return 0, f.err
+}
@@ -0,0 +1,99 @@
+package artifact
+
+import (
+	"io"
+)
+
+// A truncateSeeker is an output, like an *os.File, which can have what was
+// written to it discarded
+type truncateSeeker interface {
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// Download from a URL created by sign, which is a function returning a newly
+// signed Queue URL for the artifact.  A signed URL, or the URL which the Queue
+// redirects to, can expire before it's used, for example while a download is
+// waiting for a retry.  When a download is refused because a URL has expired,
+// a new URL is signed and the download is started again from the beginning.
+// Expired URLs are refused before any of the artifact is sent, so only the
+// error response needs to be discarded from the output first.  That is only
+// possible when nothing was written or the output can be truncated, like an
+// *os.File, so ErrSignedURLExpired is returned for other outputs.  A newly
+// signed URL should never be expired, so this is only done once.  The
+// artifact names what is downloaded in log messages
+func (c *Client) downloadSigned(artifact string, sign func() (string, error), output io.Writer, raw bool) (DownloadResult, error) {
+	op, done, err := c.startOperation(artifact)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	defer done()
+
+	result, err := op.downloadResigning(sign, output, raw)
+	return result, op.operationError(err)
+}
+
+// Download like downloadSigned, as part of an operation which has already
+// been started
+func (c *Client) downloadResigning(sign func() (string, error), output io.Writer, raw bool) (DownloadResult, error) {
+	u, err := sign()
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	result, err := c.downloadURL(u, output, raw)
+	if err != ErrSignedURLExpired {
+		return result, err
+	}
+
+	if discardErr := discardOutput(output, result.BytesWritten); discardErr != nil {
+		c.agent.logf("cannot download %s again after its URL expired: %v", u, discardErr)
+		return result, err
+	}
+
+	c.agent.logf("URL for %s expired, downloading it again with a newly signed URL", u)
+
+	if u, err = sign(); err != nil {
+		return DownloadResult{}, err
+	}
+
+	return c.downloadURL(u, output, raw)
+}
+
+// Discard the last written bytes of output, leaving it as it was
+// before they were written.  They are discarded from each output of a
+// multiOutput, since they were written to all of them
+func discardOutput(output io.Writer, written int64) error {
+	if written == 0 {
+		return nil
+	}
+
+	if m, ok := output.(*multiOutput); ok {
+		for _, o := range m.outputs {
+			if err := discardOutput(o, written); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ts, ok := output.(truncateSeeker)
+	if !ok {
+		return newErrorf(nil, "output %s has %d bytes written to it and cannot be truncated", findName(output), written)
+	}
+
+	end, err := ts.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return newErrorf(err, "finding position of output %s", findName(output))
+	}
+
+	start := end - written
+	if err := ts.Truncate(start); err != nil {
+		return newErrorf(err, "truncating output %s to %d bytes", findName(output), start)
+	}
+	if _, err := ts.Seek(start, io.SeekStart); err != nil {
+		return newErrorf(err, "seeking output %s to %d", findName(output), start)
+	}
+
+	return nil
+}
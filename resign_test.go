@@ -0,0 +1,96 @@
+package artifact
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExpiredURLResigning(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	content := []byte("artifact which outlived its URL")
+
+	var redirects, expired int
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/task/"):
+			redirects++
+			w.Header().Set("x-taskcluster-artifact-storage-type", "blob")
+			w.Header().Set("location", ts.URL+"/s3/artifact")
+			w.WriteHeader(303)
+		case expired > 0:
+			expired--
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("<Error><Code>AccessDenied</Code><Message>Request has expired</Message></Error>"))
+		default:
+			w.Header().Set("x-amz-meta-content-length", sl(content))
+			w.Header().Set("x-amz-meta-content-sha256", hb(content))
+			w.Write(content)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+
+	if err := os.MkdirAll("testdata", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("restarts the download with a new URL", func(t *testing.T) {
+		output, err := ioutil.TempFile("testdata", "resign")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(output.Name())
+		defer output.Close()
+
+		redirects, expired = 0, 1
+		if err := client.Download(fakeTaskID, "0", "public/expiring", output); err != nil {
+			t.Fatal(err)
+		}
+		if redirects != 2 {
+			t.Errorf("expected the URL to be signed and followed twice, got %d", redirects)
+		}
+
+		written, err := ioutil.ReadFile(output.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(written, content) {
+			t.Fatalf("expected output to be only the artifact, got %q", written)
+		}
+	})
+
+	t.Run("only restarts once", func(t *testing.T) {
+		output, err := ioutil.TempFile("testdata", "resign")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(output.Name())
+		defer output.Close()
+
+		redirects, expired = 0, 2
+		if err := client.Download(fakeTaskID, "0", "public/expiring", output); err != ErrSignedURLExpired {
+			t.Fatalf("expected ErrSignedURLExpired, got %v", err)
+		}
+	})
+
+	t.Run("outputs which cannot be truncated are not restarted", func(t *testing.T) {
+		var output bytes.Buffer
+		redirects, expired = 0, 1
+		if err := client.Download(fakeTaskID, "0", "public/expiring", &output); err != ErrSignedURLExpired {
+			t.Fatalf("expected ErrSignedURLExpired, got %v", err)
+		}
+		if redirects != 1 {
+			t.Errorf("expected the URL to be followed once, got %d", redirects)
+		}
+	})
+}
@@ -0,0 +1,276 @@
+package artifact
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/taskcluster/taskcluster-client-go/tcqueue"
+)
+
+// UploadState is the progress of a blob artifact upload.  When a Client has
+// an UploadStateFile, the state of each upload is saved there once its
+// artifact has been created and again as each part is uploaded, so that an
+// upload which was interrupted, for example by its process crashing, can be
+// finished with ResumeUpload without uploading the parts which were already
+// uploaded
+type UploadState struct {
+	TaskID string `json:"taskId"`
+	RunID  string `json:"runId"`
+	Name   string `json:"name"`
+	// Request is the createArtifact request, which describes the upload
+	Request tcqueue.BlobArtifactRequest `json:"request"`
+	// Response is the createArtifact response, which has the requests which
+	// upload each part
+	Response tcqueue.BlobArtifactResponse `json:"response"`
+	// Etags has the etag of each part which has been uploaded, in order.  The
+	// etags of parts which haven't been uploaded are empty
+	Etags []string `json:"etags"`
+	// CRC32C has the CRC32C of each part, which is sent with it when the
+	// Client has EnableCRC32C set
+	CRC32C []uint32 `json:"crc32c"`
+	// MD5 has the MD5 of each part, which is sent with it when the Client has
+	// EnableContentMD5 set.  It's empty when the MD5s weren't computed
+	MD5 [][]byte `json:"md5,omitempty"`
+}
+
+// An uploadStateFile keeps the state of an upload and saves it to a file as
+// it changes.  Parts are uploaded concurrently, so the state is locked while
+// it's changed.  When the path is empty, the state is only kept in memory.
+// The summaries of the part requests are only kept in memory, since they
+// describe the requests made by this process
+type uploadStateFile struct {
+	path  string
+	lock  sync.Mutex
+	state UploadState
+	parts []PartSummary
+}
+
+// Save the state to its file.  The state is written to a temporary file which
+// replaces the old one, so a crash while saving never leaves a partial state
+func (f *uploadStateFile) save() error {
+	if f.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(&f.state)
+	if err != nil {
+		return newErrorf(err, "serializing upload state of %s/%s/%s", f.state.TaskID, f.state.RunID, f.state.Name)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path))
+	if err != nil {
+		return newErrorf(err, "creating upload state file for %s", f.path)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return newErrorf(err, "writing upload state file for %s", f.path)
+	}
+
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return newErrorf(err, "replacing upload state file %s", f.path)
+	}
+	return nil
+}
+
+// Record the etag of an uploaded part and save the state
+func (f *uploadStateFile) setEtag(i int, etag string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.state.Etags[i] = etag
+	return f.save()
+}
+
+// Record the summary of a part's request, whether or not it succeeded
+func (f *uploadStateFile) addPart(p PartSummary) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.parts = append(f.parts, p)
+}
+
+// Describe the upload as it currently is
+func (f *uploadStateFile) result() UploadResult {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return UploadResult{
+		Etags:    append([]string(nil), f.state.Etags...),
+		Request:  f.state.Request,
+		Response: f.state.Response,
+		Parts:    append([]PartSummary(nil), f.parts...),
+	}
+}
+
+// Remove the state's file once the upload no longer needs to be resumed
+func (f *uploadStateFile) remove() {
+	if f.path == "" {
+		return
+	}
+	if err := os.Remove(f.path); err != nil {
+		logger.Printf("could not remove upload state file %s: %v", f.path, err)
+	}
+}
+
+// ReadUploadState reads the state of an upload from a file which was saved
+// because a Client had it as its UploadStateFile
+func ReadUploadState(path string) (UploadState, error) {
+	var state UploadState
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return state, newErrorf(err, "reading upload state file %s", path)
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, newErrorf(err, "parsing upload state file %s", path)
+	}
+
+	return state, nil
+}
+
+// ResumeUpload finishes an upload whose state was saved to stateFile, using
+// the output which the upload was staged in, or its input for uploads which
+// were uploaded straight from the input.  Only the parts without an etag are
+// uploaded, and each of them is checked against the hash it was created with
+// as it's read, so an output which has changed can't be uploaded.  The
+// requests saved in the state are used first.  If they have expired, the
+// artifact is created again with the same request to get new ones, which the
+// Queue treats as the same artifact.  Once the artifact is completed, the
+// state file is removed
+func (c *Client) ResumeUpload(stateFile string, output io.ReadSeeker) error {
+	state, err := ReadUploadState(stateFile)
+	if err != nil {
+		return err
+	}
+
+	if err := validateArtifact(state.TaskID, state.RunID, state.Name); err != nil {
+		return err
+	}
+
+	// The saved part hashes are sha256 hashes, which is all the Queue accepts
+	if c.hashAlgorithm.Name != SHA256.Name {
+		return newErrorf(nil, "the Queue does not support %s hashes, cannot upload %s/%s/%s", c.hashAlgorithm.Name, state.TaskID, state.RunID, state.Name)
+	}
+
+	u, err := state.upload()
+	if err != nil {
+		return newErrorf(err, "invalid upload state in %s", stateFile)
+	}
+
+	inputName := findName(output)
+
+	op, done, err := c.startOperation(state.TaskID + "/" + state.RunID + "/" + state.Name)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	// Each part is checked against its hash as it's uploaded
+	concurrency, outputBody := op.partBodies(output)
+	hashes := map[int64][]byte{}
+	for _, part := range u.Parts {
+		hashes[part.Start] = part.Sha256
+	}
+	if u.Parts == nil {
+		hashes[0] = u.TransferSha256
+	}
+	partBody := func(start, size int64) (io.Reader, error) {
+		body, err := outputBody(start, size)
+		if err != nil {
+			return nil, err
+		}
+		return newVerifyingReader(body, size, hashes[start], op.hashAlgorithm.New()), nil
+	}
+
+	f := &uploadStateFile{path: stateFile, state: state}
+
+	logger.Printf("Resuming upload of %s to %s/%s/%s", inputName, state.TaskID, state.RunID, state.Name)
+
+	err = op.uploadParts(inputName, u, f, concurrency, partBody)
+	if err == ErrSignedURLExpired {
+		logger.Printf("requests to upload %s/%s/%s have expired, creating the artifact again", state.TaskID, state.RunID, state.Name)
+		bares, createErr := op.createBlobArtifact(state.TaskID, state.RunID, state.Name, inputName, &f.state.Request)
+		if createErr != nil {
+			return op.operationError(createErr)
+		}
+		if len(bares.Requests) != len(f.state.Etags) {
+			return newErrorf(nil, "artifact %s/%s/%s was created again with %d parts, not %d", state.TaskID, state.RunID, state.Name, len(bares.Requests), len(f.state.Etags))
+		}
+		f.state.Response = bares
+		if err := f.save(); err != nil {
+			return err
+		}
+		err = op.uploadParts(inputName, u, f, concurrency, partBody)
+	}
+	if err != nil {
+		return op.operationError(op.uploadFailed(f, err))
+	}
+
+	if err := op.completeUpload(inputName, f); err != nil {
+		return op.operationError(op.uploadFailed(f, err))
+	}
+
+	return nil
+}
+
+// Convert the state of an upload back to the upload it was created from, so
+// that its parts can be uploaded
+func (s UploadState) upload() (upload, error) {
+	u := upload{
+		Size:            s.Request.ContentLength,
+		TransferSize:    s.Request.TransferLength,
+		ContentEncoding: s.Request.ContentEncoding,
+	}
+
+	var err error
+	if u.Sha256, err = hex.DecodeString(s.Request.ContentSha256); err != nil {
+		return upload{}, newErrorf(err, "decoding content sha256 %s", s.Request.ContentSha256)
+	}
+	if u.TransferSha256, err = hex.DecodeString(s.Request.TransferSha256); err != nil {
+		return upload{}, newErrorf(err, "decoding transfer sha256 %s", s.Request.TransferSha256)
+	}
+
+	requests := 1
+	if len(s.Request.Parts) > 0 {
+		requests = len(s.Request.Parts)
+	}
+	if len(s.Response.Requests) != requests || len(s.Etags) != requests || len(s.CRC32C) != requests {
+		return upload{}, newErrorf(nil, "expected %d requests, etags and checksums, got %d, %d and %d", requests, len(s.Response.Requests), len(s.Etags), len(s.CRC32C))
+	}
+	md5s := s.MD5
+	if len(md5s) == 0 {
+		md5s = make([][]byte, requests)
+	} else if len(md5s) != requests {
+		return upload{}, newErrorf(nil, "expected %d MD5s, got %d", requests, len(md5s))
+	}
+
+	if len(s.Request.Parts) == 0 {
+		u.TransferCRC32C = s.CRC32C[0]
+		u.TransferMD5 = md5s[0]
+		return u, nil
+	}
+
+	var start int64
+	for i, p := range s.Request.Parts {
+		sha, err := hex.DecodeString(p.Sha256)
+		if err != nil {
+			return upload{}, newErrorf(err, "decoding sha256 %s of part %d", p.Sha256, i)
+		}
+		u.Parts = append(u.Parts, part{sha, p.Size, start, s.CRC32C[i], md5s[i]})
+		start += p.Size
+	}
+
+	if start != u.TransferSize {
+		return upload{}, newErrorf(nil, "parts total %d bytes, expected %d", start, u.TransferSize)
+	}
+
+	return u, nil
+}
@@ -0,0 +1,178 @@
+package artifact
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/taskcluster/taskcluster-client-go/tcqueue"
+)
+
+func TestResumeUpload(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	dir, err := ioutil.TempDir("", "resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var lock sync.Mutex
+	var creates int
+	var uploaded []string
+	var completed tcqueue.CompleteArtifactRequest
+	// The response for each part, with parts which aren't listed succeeding
+	failures := map[string]int{}
+
+	var ts *httptest.Server
+	q, ts := createFakeQueue(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch {
+		case r.Method == "POST":
+			creates++
+			w.Write([]byte(`{"storageType": "blob", "expires": "2030-01-01T00:00:00.000Z", "requests": [
+				{"url": "` + ts.URL + `/s3/part1", "method": "PUT", "headers": {}},
+				{"url": "` + ts.URL + `/s3/part2", "method": "PUT", "headers": {}}
+			]}`))
+		case strings.HasPrefix(r.URL.Path, "/s3/"):
+			if _, err := ioutil.ReadAll(r.Body); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			part := strings.TrimPrefix(r.URL.Path, "/s3/")
+			if status, ok := failures[part]; ok {
+				delete(failures, part)
+				w.WriteHeader(status)
+				if status == http.StatusForbidden {
+					w.Write([]byte("<Error><Message>Request has expired</Message></Error>"))
+				}
+				return
+			}
+			uploaded = append(uploaded, part)
+			w.Header().Set("etag", part+"etag")
+		case r.Method == "PUT":
+			json.NewDecoder(r.Body).Decode(&completed)
+		}
+	})
+	defer ts.Close()
+
+	client := New(q)
+	client.AllowInsecure = true
+	if err := client.SetInternalSizes(DefaultChunkSize, 5*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("resumable"), 1024*1024)
+
+	// Upload with the second part failing, leaving the state of the upload
+	// and the output it was staged in.  The input isn't an io.ReaderAt, so
+	// that it's staged in the output instead of being uploaded straight from
+	// the input
+	interrupt := func(t *testing.T) (string, *os.File) {
+		stateFile := filepath.Join(dir, "state.json")
+		client.UploadStateFile = stateFile
+		defer func() { client.UploadStateFile = "" }()
+
+		output, err := ioutil.TempFile(dir, "output")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		failures["part2"] = 400
+		creates, uploaded = 0, nil
+		if err := client.Upload(fakeTaskID, "0", "public/resumable", onlyReadSeeker{bytes.NewReader(data)}, output, false, true); err == nil {
+			t.Fatal("expected the upload to fail")
+		}
+
+		state, err := ReadUploadState(stateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(state.Etags) != 2 || state.Etags[0] != "part1etag" || state.Etags[1] != "" {
+			t.Fatalf("expected only the first part to have an etag, got %q", state.Etags)
+		}
+		return stateFile, output
+	}
+
+	t.Run("uploads only the missing parts", func(t *testing.T) {
+		stateFile, output := interrupt(t)
+		defer output.Close()
+
+		uploaded = nil
+		completed = tcqueue.CompleteArtifactRequest{}
+		if err := client.ResumeUpload(stateFile, output); err != nil {
+			t.Fatal(err)
+		}
+		if len(uploaded) != 1 || uploaded[0] != "part2" {
+			t.Fatalf("expected only part2 to be uploaded, got %q", uploaded)
+		}
+		if len(completed.Etags) != 2 || completed.Etags[0] != "part1etag" || completed.Etags[1] != "part2etag" {
+			t.Fatalf("unexpected etags %q", completed.Etags)
+		}
+		if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+			t.Fatalf("expected the state file to be removed, got %v", err)
+		}
+	})
+
+	t.Run("creates the artifact again for expired requests", func(t *testing.T) {
+		stateFile, output := interrupt(t)
+		defer output.Close()
+
+		failures["part2"] = http.StatusForbidden
+		creates, uploaded = 0, nil
+		if err := client.ResumeUpload(stateFile, output); err != nil {
+			t.Fatal(err)
+		}
+		if creates != 1 {
+			t.Fatalf("expected the artifact to be created again once, got %d", creates)
+		}
+		if len(uploaded) != 1 || uploaded[0] != "part2" {
+			t.Fatalf("expected only part2 to be uploaded, got %q", uploaded)
+		}
+	})
+
+	t.Run("other hash algorithm", func(t *testing.T) {
+		stateFile, output := interrupt(t)
+		defer output.Close()
+
+		sha512Client := New(q)
+		sha512Client.AllowInsecure = true
+		sha512Client.hashAlgorithm = SHA512
+
+		uploaded = nil
+		if err := sha512Client.ResumeUpload(stateFile, output); err == nil {
+			t.Fatal("expected resuming with sha512 hashes to fail")
+		}
+		if len(uploaded) != 0 {
+			t.Fatalf("expected no parts to be uploaded, got %q", uploaded)
+		}
+	})
+
+	t.Run("changed output", func(t *testing.T) {
+		stateFile, output := interrupt(t)
+		defer output.Close()
+
+		if _, err := output.WriteAt([]byte("changed"), int64(len(data)-10)); err != nil {
+			t.Fatal(err)
+		}
+
+		uploaded = nil
+		if err := client.ResumeUpload(stateFile, output); err == nil {
+			t.Fatal("expected resuming with a changed output to fail")
+		}
+		if len(uploaded) != 0 {
+			t.Fatalf("expected no parts to be uploaded, got %q", uploaded)
+		}
+		if _, err := os.Stat(stateFile); err != nil {
+			t.Fatalf("expected the state file to be kept, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package artifact
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+)
+
+// DefaultMaxRetries is the number of times a new Client will retry a failed
+// Queue call
+const DefaultMaxRetries = 5
+
+// DefaultRetryDelay is how long a new Client waits before the first retry of
+// a failed Queue call.  The delay doubles after each retry
+const DefaultRetryDelay = 250 * time.Millisecond
+
+// The longest that doubling a retry delay makes it, so that many retries
+// neither wait for hours nor overflow the delay
+const maxRetryDelay = 5 * time.Minute
+
+// Determine the HTTP status code of the response to a failed Queue call.  If
+// there wasn't a response, 0 is returned
+func queueStatusCode(err error) int {
+	if apiErr, ok := err.(*tcclient.APICallException); ok {
+		if apiErr.CallSummary != nil && apiErr.CallSummary.HTTPResponse != nil {
+			return apiErr.CallSummary.HTTPResponse.StatusCode
+		}
+	}
+	return 0
+}
+
+// Describe a failed Queue call.  Calls which were refused because of the
+// credentials used are reported as ErrUnauthorized, since they're otherwise
+// easily mistaken for other problems
+func queueCallError(err error, format string, a ...interface{}) error {
+	if sc := queueStatusCode(err); sc == http.StatusUnauthorized || sc == http.StatusForbidden {
+		logger.Printf("%s: %v", fmt.Sprintf(format, a...), err)
+		return ErrUnauthorized
+	}
+	return newErrorf(err, format, a...)
+}
+
+// Determine whether a failed Queue call is worth retrying.  Calls which
+// received a 4xx response will fail the same way each time, but server errors
+// and calls which did not receive a response at all might succeed later
+func isRetryableQueueError(err error) bool {
+	sc := queueStatusCode(err)
+	return sc == 0 || sc >= http.StatusInternalServerError
+}
+
+// Run a Queue call until it succeeds, fails with an error that isn't
+// retryable, or has been retried c.MaxRetries times.  The error from the last
+// attempt is returned, unless the operation is stopped while waiting to retry
+func (c *Client) retryQueueCall(description string, call func() error) error {
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if err == nil || isRetryableQueueError(err) {
+			c.circuit.record(err != nil)
+		}
+		if err == nil || !isRetryableQueueError(err) || attempt >= c.MaxRetries {
+			return err
+		}
+		c.metrics.IncRetry()
+		logger.Printf("%s failed, retrying in %s (retry %d of %d)", description, delay, attempt+1, c.MaxRetries)
+		if err := c.sleep(delay); err != nil {
+			return c.operationError(err)
+		}
+		delay = nextRetryDelay(delay)
+	}
+}
+
+// Double a retry delay, up to maxRetryDelay.  A delay which is already longer
+// than that was chosen by the user and is kept as it is
+func nextRetryDelay(delay time.Duration) time.Duration {
+	if delay >= maxRetryDelay {
+		return delay
+	}
+	if delay > maxRetryDelay/2 {
+		return maxRetryDelay
+	}
+	return delay * 2
+}
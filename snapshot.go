@@ -0,0 +1,58 @@
+package artifact
+
+import (
+	"io"
+)
+
+// A snapshotReader reads at most size bytes of an io.ReadSeeker, as though
+// it ended there.  Inputs which are still being appended to, like the log of
+// a running process, can be uploaded as they were when their size was taken,
+// with everything appended afterwards ignored
+type snapshotReader struct {
+	rs   io.ReadSeeker
+	size int64
+	pos  int64
+}
+
+func newSnapshotReader(rs io.ReadSeeker, size int64) *snapshotReader {
+	return &snapshotReader{rs: rs, size: size}
+}
+
+func (s *snapshotReader) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	if remaining := s.size - s.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := s.rs.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+func (s *snapshotReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = s.pos + offset
+	case io.SeekEnd:
+		pos = s.size + offset
+	default:
+		return s.pos, newErrorf(nil, "invalid whence %d seeking %s", whence, findName(s.rs))
+	}
+	if pos < 0 {
+		return s.pos, newErrorf(nil, "cannot seek %s to negative position %d", findName(s.rs), pos)
+	}
+	if _, err := s.rs.Seek(pos, io.SeekStart); err != nil {
+		return s.pos, err
+	}
+	s.pos = pos
+	return pos, nil
+}
+
+// Name is the name of the snapshotted input
+func (s *snapshotReader) Name() string {
+	return findName(s.rs)
+}
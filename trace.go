@@ -0,0 +1,21 @@
+package artifact
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// TraceIDHeader is the header which carries the trace ID of each request this
+// library makes, so that an artifact operation can be followed through the
+// logs of the Queue, S3 and this library
+const TraceIDHeader = "X-Taskcluster-Trace-Id"
+
+// Generate a random trace ID
+func newTraceID() string {
+	b := make([]byte, 16)
+	// crypto/rand only fails when the system's source of randomness is
+	// unavailable.  A trace ID is only for debugging, so an ID of zeros is
+	// better than failing the operation
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
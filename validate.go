@@ -0,0 +1,61 @@
+package artifact
+
+import (
+	"regexp"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This is the pattern which the Queue uses for taskIds.  It matches the slugs
+// generated by slugid.Nice() and slugid.V4()
+var taskIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{8}[Q-T][A-Za-z0-9_-][CGKOSWaeimquy26-][A-Za-z0-9_-]{10}[AQgw]$`)
+
+// The Queue will not accept runIds above this value
+const maxRunID = 1000
+
+// Check that a taskID, runID and artifact name are acceptable to the Queue.
+// We do this before making any Queue calls so that obviously bad values give
+// an error which explains what's wrong instead of an error from the server
+func validateArtifact(taskID, runID, name string) error {
+	if err := validateTaskID(taskID); err != nil {
+		return err
+	}
+
+	r, err := strconv.Atoi(runID)
+	if err != nil || r < 0 || r > maxRunID || strconv.Itoa(r) != runID {
+		return newErrorf(nil, "runID %q is not an integer between 0 and %d", runID, maxRunID)
+	}
+
+	return validateName(name)
+}
+
+// Check that a taskID is a slugid, for calls about a task rather than one of
+// its artifacts
+func validateTaskID(taskID string) error {
+	if !taskIDPattern.MatchString(taskID) {
+		return newErrorf(nil, "taskID %q is not a valid slugid", taskID)
+	}
+	return nil
+}
+
+// Check that an artifact name is acceptable to the Queue.  Names are used as
+// part of the URL path, so they must be valid UTF-8 and must not contain
+// control characters
+func validateName(name string) error {
+	if name == "" {
+		return newError(nil, "artifact name must not be empty")
+	}
+
+	if !utf8.ValidString(name) {
+		return newErrorf(nil, "artifact name %q is not valid UTF-8", name)
+	}
+
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return newErrorf(nil, "artifact name %q contains control character %U", name, r)
+		}
+	}
+
+	return nil
+}
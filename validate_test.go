@@ -0,0 +1,48 @@
+package artifact
+
+import (
+	"testing"
+
+	"github.com/taskcluster/slugid-go/slugid"
+)
+
+func TestValidation(t *testing.T) {
+	taskID := slugid.Nice()
+
+	valid := []struct {
+		taskID, runID, name string
+	}{
+		{taskID, "0", "public/logs/live.log"},
+		{taskID, "1000", "public/build/target.tar.gz"},
+		{slugid.V4(), "5", "private/ünïcödé name"},
+	}
+
+	for _, tt := range valid {
+		if err := validateArtifact(tt.taskID, tt.runID, tt.name); err != nil {
+			t.Errorf("expected %s/%s/%s to be valid: %s", tt.taskID, tt.runID, tt.name, err)
+		}
+	}
+
+	invalid := []struct {
+		taskID, runID, name string
+	}{
+		{"", "0", "public/logs/live.log"},
+		{"taskid", "0", "public/logs/live.log"},
+		{taskID + "a", "0", "public/logs/live.log"},
+		{taskID, "", "public/logs/live.log"},
+		{taskID, "-1", "public/logs/live.log"},
+		{taskID, "1001", "public/logs/live.log"},
+		{taskID, "01", "public/logs/live.log"},
+		{taskID, "latest", "public/logs/live.log"},
+		{taskID, "0", ""},
+		{taskID, "0", "public/logs/live\n.log"},
+		{taskID, "0", "public/logs/\x00"},
+		{taskID, "0", "public/\xff"},
+	}
+
+	for _, tt := range invalid {
+		if err := validateArtifact(tt.taskID, tt.runID, tt.name); err == nil {
+			t.Errorf("expected %q/%q/%q to be invalid", tt.taskID, tt.runID, tt.name)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// Compare the length and hash of the bytes of a resource with what was
+// expected, logging each mismatch with logf.  The kind is which bytes of the resource
+// these are, either its transfer or its content.  Downloads check both kinds,
+// and only find a resource valid when every check passes.  A negative expected
+// length or an empty expected hash isn't known, so it isn't checked
+func checkLengthAndHash(logf func(format string, v ...interface{}), resource, kind, hashName string, expectedLength, length int64, expectedHash, hash string) bool {
+	valid := true
+
+	if expectedLength >= 0 && expectedLength != length {
+		logf("Resource %s has incorrect %s length.  Expected: %d received: %d",
+			resource, kind, expectedLength, length)
+		valid = false
+	}
+
+	if expectedHash != "" && expectedHash != hash {
+		logf("Resource %s has incorrect %s %s.  Expected: %s received: %s",
+			resource, kind, hashName, expectedHash, hash)
+		valid = false
+	}
+
+	return valid
+}
+
+// VerifyReader reads all of r and checks that it is the content described by
+// expected, using the same checks as a download.  This is useful for
+// validating artifacts which were fetched through another channel, like a
+// cache, using the metadata returned by Head or a previous download.  The
+// expected metadata must have a ContentLength and a ContentSha256.  If the
+// content doesn't match, ErrCorrupt is returned
+func VerifyReader(r io.Reader, expected ArtifactMeta) error {
+	return verifyContent("reader", r, expected)
+}
+
+// VerifyFile checks that the file at path is the content described by
+// expected.  See VerifyReader
+func VerifyFile(path string, expected ArtifactMeta) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return newErrorf(err, "opening %s to verify", path)
+	}
+	defer f.Close()
+
+	return verifyContent(path, f, expected)
+}
+
+// Check the content read from r, which is named in log messages as resource
+func verifyContent(resource string, r io.Reader, expected ArtifactMeta) error {
+	if expected.ContentLength < 0 || expected.ContentSha256 == "" {
+		return newErrorf(nil, "verifying requires a content length and sha256, got %d and %q", expected.ContentLength, expected.ContentSha256)
+	}
+
+	hash := sha256.New()
+	length, err := io.Copy(hash, r)
+	if err != nil {
+		return newErrorf(err, "reading %s to verify", resource)
+	}
+
+	if !checkLengthAndHash(logger.Printf, resource, "content", "sha256", expected.ContentLength, length, expected.ContentSha256, hex.EncodeToString(hash.Sum(nil))) {
+		return ErrCorrupt
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package artifact
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyContent(t *testing.T) {
+	SetLogOutput(newUnitTestLogWriter(t))
+
+	content := []byte("content fetched from a cache")
+	sum := sha256.Sum256(content)
+	expected := ArtifactMeta{StorageType: "blob", ContentLength: int64(len(content)), ContentSha256: hex.EncodeToString(sum[:])}
+
+	t.Run("reader", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			input    []byte
+			expected ArtifactMeta
+			err      error
+		}{
+			{"matching content", content, expected, nil},
+			{"truncated content", content[:10], expected, ErrCorrupt},
+			{"changed content", bytes.ToUpper(content), expected, ErrCorrupt},
+			{"empty content", []byte{}, ArtifactMeta{ContentSha256: emptySha256}, nil},
+		}
+
+		for _, tt := range tests {
+			if err := VerifyReader(bytes.NewReader(tt.input), tt.expected); err != tt.err {
+				t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+			}
+		}
+	})
+
+	t.Run("metadata without a hash", func(t *testing.T) {
+		err := VerifyReader(bytes.NewReader(content), ArtifactMeta{ContentLength: int64(len(content))})
+		if err == nil || err == ErrCorrupt {
+			t.Fatalf("expected an error about the metadata, got %v", err)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "verify")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "artifact")
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := VerifyFile(path, expected); err != nil {
+			t.Fatalf("expected the file to verify, got %v", err)
+		}
+
+		if err := ioutil.WriteFile(path, content[1:], 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := VerifyFile(path, expected); err != ErrCorrupt {
+			t.Fatalf("expected ErrCorrupt, got %v", err)
+		}
+
+		if err := VerifyFile(filepath.Join(dir, "missing"), expected); err == nil {
+			t.Fatal("expected an error for a missing file")
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package artifact
+
+import (
+	"bytes"
+	"hash"
+	"io"
+)
+
+// A verifyingReader reads exactly size bytes from an io.Reader, hashing them
+// as they are read.  Once all of the bytes have been read, their hash is
+// compared to the expected hash.  If it doesn't match, or the input ends
+// early, an error is returned instead of the final bytes.  When used as a
+// request body, this means that the server never receives the complete body,
+// so data which doesn't match what was promised to the Queue is never
+// successfully uploaded
+type verifyingReader struct {
+	r        io.Reader
+	size     int64
+	expected []byte
+	hash     hash.Hash
+	count    int64
+}
+
+func newVerifyingReader(r io.Reader, size int64, expected []byte, h hash.Hash) *verifyingReader {
+	return &verifyingReader{
+		r:        io.LimitReader(r, size),
+		size:     size,
+		expected: expected,
+		hash:     h,
+	}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	if n == 0 {
+		if err == io.EOF && v.count != v.size {
+			return 0, newErrorf(nil, "read %d bytes, expected %d bytes", v.count, v.size)
+		}
+		return 0, err
+	}
+
+	// The hash.Hash interface docs state that the Write function never returns
+	// an error
+	_, _ = v.hash.Write(p[:n])
+	v.count += int64(n)
+
+	if v.count == v.size {
+		if sum := v.hash.Sum(nil); !bytes.Equal(sum, v.expected) {
+			return 0, newErrorf(ErrCorrupt, "read bytes with hash %x, expected %x", sum, v.expected)
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,53 @@
+package artifact
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"testing"
+)
+
+func TestVerifyingReader(t *testing.T) {
+	data := []byte("some data to verify")
+	hash := sha256.Sum256(data)
+
+	t.Run("reads matching data", func(t *testing.T) {
+		b, err := ioutil.ReadAll(newVerifyingReader(bytes.NewReader(data), int64(len(data)), hash[:], sha256.New()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("expected %q, got %q", data, b)
+		}
+	})
+
+	t.Run("reads only size bytes", func(t *testing.T) {
+		long := append(append([]byte{}, data...), []byte("extra")...)
+		b, err := ioutil.ReadAll(newVerifyingReader(bytes.NewReader(long), int64(len(data)), hash[:], sha256.New()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("expected %q, got %q", data, b)
+		}
+	})
+
+	t.Run("withholds the final bytes when the hash is wrong", func(t *testing.T) {
+		corrupt := append([]byte{}, data...)
+		corrupt[len(corrupt)-1] = 'x'
+		b, err := ioutil.ReadAll(newVerifyingReader(bytes.NewReader(corrupt), int64(len(data)), hash[:], sha256.New()))
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if len(b) == len(data) {
+			t.Fatal("expected the final bytes to be withheld")
+		}
+	})
+
+	t.Run("returns an error for short input", func(t *testing.T) {
+		_, err := ioutil.ReadAll(newVerifyingReader(bytes.NewReader(data[:5]), int64(len(data)), hash[:], sha256.New()))
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package artifact
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A stallTimer will call the cancel function it's created with when more than
+// timeout passes without progress() being called.  This is used to abort
+// requests which have stopped making progress without putting a limit on how
+// long a request which is making progress can take.  This is important because
+// a request with a 5GB body can take a very long time to complete
+type stallTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+	stalled int32
+}
+
+func newStallTimer(timeout time.Duration, cancel func()) *stallTimer {
+	s := &stallTimer{timeout: timeout}
+	s.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&s.stalled, 1)
+		cancel()
+	})
+	return s
+}
+
+// Record that progress has been made, pushing back the deadline
+func (s *stallTimer) progress(n int) {
+	if n > 0 {
+		s.timer.Reset(s.timeout)
+	}
+}
+
+// Stop the timer.  This must be called once the request has completed
+func (s *stallTimer) stop() {
+	s.timer.Stop()
+}
+
+// Determine whether the timer has fired
+func (s *stallTimer) isStalled() bool {
+	return atomic.LoadInt32(&s.stalled) == 1
+}
+
+// A progressReader calls the progress function with the number of bytes
+// returned from each call to the underlying reader's Read method
+type progressReader struct {
+	r        io.Reader
+	progress func(int)
+}
+
+func (p progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.progress(n)
+	return n, err
+}
+
+// A throughputMonitor will call the cancel function it's created with if fewer
+// than minBytes are transfered in any window.  Unlike the stallTimer, this
+// catches connections which trickle a few bytes at a time.  Bytes are counted
+// by reading through the io.Reader returned by the reader method, and the
+// monitor stops once that reader is exhausted
+type throughputMonitor struct {
+	// count is accessed atomically, so it's first to ensure 64-bit alignment
+	count    int64
+	minBytes int64
+	tooSlow  int32
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newThroughputMonitor(bytesPerSec int64, window time.Duration, cancel func()) *throughputMonitor {
+	m := &throughputMonitor{
+		minBytes: int64(float64(bytesPerSec) * window.Seconds()),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-ticker.C:
+				if atomic.SwapInt64(&m.count, 0) < m.minBytes {
+					atomic.StoreInt32(&m.tooSlow, 1)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return m
+}
+
+// Wrap an io.Reader so that bytes read from it are counted by the monitor
+func (m *throughputMonitor) reader(r io.Reader) io.Reader {
+	return monitoredReader{r, m}
+}
+
+type monitoredReader struct {
+	r io.Reader
+	m *throughputMonitor
+}
+
+func (mr monitoredReader) Read(b []byte) (int, error) {
+	n, err := mr.r.Read(b)
+	atomic.AddInt64(&mr.m.count, int64(n))
+	// Once the reader is exhausted, there's nothing left to transfer.  We
+	// don't want to time out while waiting on the other side to respond
+	if err != nil {
+		mr.m.stop()
+	}
+	return n, err
+}
+
+// Stop monitoring.  It is safe to call this more than once
+func (m *throughputMonitor) stop() {
+	m.once.Do(func() {
+		close(m.done)
+	})
+}
+
+// Determine whether the monitor has found the transfer to be too slow
+func (m *throughputMonitor) isTooSlow() bool {
+	return atomic.LoadInt32(&m.tooSlow) == 1
+}